@@ -0,0 +1,234 @@
+package httptap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/myhops/httptap/yamljson"
+)
+
+// mediaTypeJSON is the media type of the decoder that fills ReqBodyJSON and RespBodyJSON.
+const mediaTypeJSON = "application/json"
+
+// BodyDecoder decodes a captured body into a generic value.
+// params are the parameters of the Content-Type, e.g. charset.
+type BodyDecoder interface {
+	DecodeBody(b []byte, params map[string]string) (any, error)
+}
+
+type BodyDecoderFunc func(b []byte, params map[string]string) (any, error)
+
+func (f BodyDecoderFunc) DecodeBody(b []byte, params map[string]string) (any, error) {
+	return f(b, params)
+}
+
+var bodyDecoders = struct {
+	sync.RWMutex
+	m map[string]BodyDecoder
+}{
+	m: map[string]BodyDecoder{},
+}
+
+// RegisterBodyDecoder registers dec for mediaType, e.g. application/json.
+// A decoder registered for application/<suffix> also decodes media types
+// with a structured suffix, e.g. application/json decodes application/problem+json.
+// Registering a media type again replaces the decoder.
+func RegisterBodyDecoder(mediaType string, dec BodyDecoder) {
+	bodyDecoders.Lock()
+	defer bodyDecoders.Unlock()
+	bodyDecoders.m[strings.ToLower(mediaType)] = dec
+}
+
+// lookupBodyDecoder returns the decoder for mediaType and the media type it was registered for.
+func lookupBodyDecoder(mediaType string) (BodyDecoder, string, bool) {
+	bodyDecoders.RLock()
+	defer bodyDecoders.RUnlock()
+	mediaType = strings.ToLower(mediaType)
+	if dec, ok := bodyDecoders.m[mediaType]; ok {
+		return dec, mediaType, true
+	}
+	// Try the structured suffix, e.g. +json.
+	if i := strings.LastIndexByte(mediaType, '+'); i >= 0 {
+		mt := "application/" + mediaType[i+1:]
+		if dec, ok := bodyDecoders.m[mt]; ok {
+			return dec, mt, true
+		}
+	}
+	return nil, "", false
+}
+
+// bodyDecoderFor returns the decoder for the Content-Type in h, the parameters
+// of the Content-Type and the media type the decoder was registered for.
+func bodyDecoderFor(h http.Header) (BodyDecoder, map[string]string, string, error) {
+	cth := h.Get("Content-Type")
+	if cth == "" {
+		return nil, nil, "", errors.New("no Content-Type header")
+	}
+	ct, params, err := mime.ParseMediaType(cth)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("error parsing Content-Type: %w", err)
+	}
+	dec, mt, ok := lookupBodyDecoder(ct)
+	if !ok {
+		return nil, nil, "", fmt.Errorf("no decoder for %s", ct)
+	}
+	return dec, params, mt, nil
+}
+
+// decodeBody decodes b with the decoder for the Content-Type in h.
+// It returns the media type of the decoder.
+func decodeBody(h http.Header, b []byte) (any, string, error) {
+	dec, params, mt, err := bodyDecoderFor(h)
+	if err != nil {
+		return nil, "", err
+	}
+	obj, err := dec.DecodeBody(b, params)
+	if err != nil {
+		return nil, mt, fmt.Errorf("error decoding %s: %w", mt, err)
+	}
+	return obj, mt, nil
+}
+
+func init() {
+	RegisterBodyDecoder(mediaTypeJSON, BodyDecoderFunc(decodeJSON))
+	RegisterBodyDecoder("application/x-ndjson", BodyDecoderFunc(decodeNDJSON))
+	RegisterBodyDecoder("application/jsonl", BodyDecoderFunc(decodeNDJSON))
+	RegisterBodyDecoder("application/xml", BodyDecoderFunc(decodeXML))
+	RegisterBodyDecoder("text/xml", BodyDecoderFunc(decodeXML))
+	RegisterBodyDecoder("application/yaml", BodyDecoderFunc(decodeYAML))
+	RegisterBodyDecoder("application/x-yaml", BodyDecoderFunc(decodeYAML))
+	RegisterBodyDecoder("text/yaml", BodyDecoderFunc(decodeYAML))
+	RegisterBodyDecoder("application/x-www-form-urlencoded", BodyDecoderFunc(decodeForm))
+}
+
+func decodeJSON(b []byte, _ map[string]string) (any, error) {
+	var obj any
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// decodeNDJSON decodes newline delimited JSON into a slice.
+func decodeNDJSON(b []byte, _ map[string]string) (any, error) {
+	res := []any{}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	s.Buffer(nil, len(b)+1)
+	for s.Scan() {
+		line := bytes.TrimSpace(s.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var obj any
+		if err := json.Unmarshal(line, &obj); err != nil {
+			return nil, err
+		}
+		res = append(res, obj)
+	}
+	return res, s.Err()
+}
+
+// decodeYAML decodes YAML into the same types as JSON.
+func decodeYAML(b []byte, _ map[string]string) (any, error) {
+	j, err := yamljson.Y2J(b)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON(j, nil)
+}
+
+// decodeForm decodes a url encoded form into a map.
+// Fields with one value map to a string, fields with more values to a slice.
+func decodeForm(b []byte, _ map[string]string) (any, error) {
+	v, err := url.ParseQuery(string(b))
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]any, len(v))
+	for k, vv := range v {
+		if len(vv) == 1 {
+			res[k] = vv[0]
+			continue
+		}
+		s := make([]any, 0, len(vv))
+		for _, x := range vv {
+			s = append(s, x)
+		}
+		res[k] = s
+	}
+	return res, nil
+}
+
+// decodeXML decodes XML into maps, like JSON.
+// Attributes are stored with a @ prefix and text next to child elements as #text.
+// Repeated elements become a slice, elements with only text become a string.
+func decodeXML(b []byte, _ map[string]string) (any, error) {
+	d := xml.NewDecoder(bytes.NewReader(b))
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			if err == io.EOF {
+				return nil, errors.New("no root element")
+			}
+			return nil, err
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			v, err := decodeXMLElement(d, se)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]any{se.Name.Local: v}, nil
+		}
+	}
+}
+
+func decodeXMLElement(d *xml.Decoder, se xml.StartElement) (any, error) {
+	m := map[string]any{}
+	for _, a := range se.Attr {
+		m["@"+a.Name.Local] = a.Value
+	}
+	var text strings.Builder
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			v, err := decodeXMLElement(d, t)
+			if err != nil {
+				return nil, err
+			}
+			name := t.Name.Local
+			switch old := m[name].(type) {
+			case nil:
+				m[name] = v
+			case []any:
+				m[name] = append(old, v)
+			default:
+				m[name] = []any{old, v}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			s := strings.TrimSpace(text.String())
+			if len(m) == 0 {
+				return s, nil
+			}
+			if s != "" {
+				m["#text"] = s
+			}
+			return m, nil
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package httptap
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeBody(t *testing.T) {
+	cases := []struct {
+		name        string
+		contentType string
+		body        string
+		want        any
+		wantType    string
+		wantErr     bool
+	}{
+		{
+			name:        "json",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"a":1}`,
+			want:        map[string]any{"a": 1.0},
+			wantType:    "application/json",
+		},
+		{
+			name:        "problem json",
+			contentType: "application/problem+json",
+			body:        `{"title":"oops"}`,
+			want:        map[string]any{"title": "oops"},
+			wantType:    "application/json",
+		},
+		{
+			name:        "ndjson",
+			contentType: "application/x-ndjson",
+			body:        "{\"a\":1}\n\n{\"a\":2}\n",
+			want:        []any{map[string]any{"a": 1.0}, map[string]any{"a": 2.0}},
+			wantType:    "application/x-ndjson",
+		},
+		{
+			name:        "xml",
+			contentType: "application/soap+xml",
+			body:        `<order id="7"><item>a</item><item>b</item><note lang="en">hi</note></order>`,
+			want: map[string]any{"order": map[string]any{
+				"@id":  "7",
+				"item": []any{"a", "b"},
+				"note": map[string]any{"@lang": "en", "#text": "hi"},
+			}},
+			wantType: "application/xml",
+		},
+		{
+			name:        "yaml",
+			contentType: "application/yaml",
+			body:        "a: 1\nb: [x, y]\n",
+			want:        map[string]any{"a": 1.0, "b": []any{"x", "y"}},
+			wantType:    "application/yaml",
+		},
+		{
+			name:        "form",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "name=peter&tag=a&tag=b",
+			want:        map[string]any{"name": "peter", "tag": []any{"a", "b"}},
+			wantType:    "application/x-www-form-urlencoded",
+		},
+		{
+			name:        "unknown",
+			contentType: "application/octet-stream",
+			body:        "xx",
+			wantErr:     true,
+		},
+		{
+			name:        "bad json",
+			contentType: "application/json",
+			body:        "{",
+			wantType:    "application/json",
+			wantErr:     true,
+		},
+	}
+	for _, cc := range cases {
+		t.Run(cc.name, func(t *testing.T) {
+			h := http.Header{"Content-Type": {cc.contentType}}
+			got, mt, err := decodeBody(h, []byte(cc.body))
+			if mt != cc.wantType {
+				t.Errorf("media type: got %q, want %q", mt, cc.wantType)
+			}
+			if cc.wantErr {
+				if err == nil {
+					t.Fatalf("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: %s", err)
+			}
+			if !reflect.DeepEqual(got, cc.want) {
+				t.Errorf("got %#v, want %#v", got, cc.want)
+			}
+		})
+	}
+}
+
+func TestRegisterBodyDecoder(t *testing.T) {
+	RegisterBodyDecoder("text/x-test", BodyDecoderFunc(func(b []byte, _ map[string]string) (any, error) {
+		return string(b), nil
+	}))
+	got, _, err := decodeBody(http.Header{"Content-Type": {"text/x-test"}}, []byte("plain"))
+	if err != nil || got != "plain" {
+		t.Errorf("got %v, %v", got, err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"log/slog"
 	"sync"
+	"sync/atomic"
 )
 
 const bufSize = 4 * 1024
@@ -11,6 +12,23 @@ const bufSize = 4 * 1024
 type BufferPool struct {
 	pool sync.Pool
 	size int
+
+	gets     atomic.Uint64
+	misses   atomic.Uint64
+	puts     atomic.Uint64
+	discards atomic.Uint64
+}
+
+// Stats are the counters of a BufferPool.
+type Stats struct {
+	// Gets is the number of buffers taken from the pool, Hits the number
+	// of them that were reused.
+	Gets uint64
+	Hits uint64
+	// Puts is the number of buffers returned to the pool, Discards the number
+	// of them that were too large to keep.
+	Puts     uint64
+	Discards uint64
 }
 
 var Default = New()
@@ -20,6 +38,7 @@ func New() *BufferPool {
 		size: bufSize,
 	}
 	b.pool.New = func() any {
+		b.misses.Add(1)
 		return &bytes.Buffer{}
 	}
 
@@ -27,6 +46,7 @@ func New() *BufferPool {
 }
 
 func (p *BufferPool) Get() *bytes.Buffer {
+	p.gets.Add(1)
 	b, ok := p.pool.Get().(*bytes.Buffer)
 	if !ok {
 		panic("BufferPool contains element of bad type")
@@ -39,7 +59,9 @@ func (p *BufferPool) Put(b *bytes.Buffer) {
 	if b == nil {
 		return
 	}
+	p.puts.Add(1)
 	if c := b.Cap(); c > p.size {
+		p.discards.Add(1)
 		slog.Default().Debug("discard large Buffer",
 			slog.String("package", "BufferPool"),
 			slog.Int("cap", c))
@@ -48,6 +70,18 @@ func (p *BufferPool) Put(b *bytes.Buffer) {
 	p.pool.Put(b)
 }
 
+// Stats returns the counters of the pool.
+func (p *BufferPool) Stats() Stats {
+	gets := p.gets.Load()
+	misses := p.misses.Load()
+	return Stats{
+		Gets:     gets,
+		Hits:     gets - min(misses, gets),
+		Puts:     p.puts.Load(),
+		Discards: p.discards.Load(),
+	}
+}
+
 func Get() *bytes.Buffer {
 	return Default.Get()
 }
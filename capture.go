@@ -0,0 +1,118 @@
+package httptap
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+
+	"github.com/myhops/httptap/bufpool"
+)
+
+// captureLimits limits the part of a body that is captured.
+type captureLimits struct {
+	// maxBytes is the maximum number of bytes captured, 0 is unlimited.
+	maxBytes int64
+	// spillThreshold is the size above which the capture moves to a temp file,
+	// 0 disables spilling.
+	spillThreshold int64
+	// spillDir is the directory of the temp files, empty uses os.TempDir.
+	spillDir string
+}
+
+// bodyCapture is the writer side of the tee on a body.
+// Write never fails, so the capture never affects the forwarded body.
+type bodyCapture struct {
+	limits  captureLimits
+	enabled bool
+	logger  *slog.Logger
+
+	buf       *bytes.Buffer
+	file      *os.File
+	size      int64
+	captured  int64
+	truncated bool
+}
+
+func newBodyCapture(enabled bool, limits captureLimits, logger *slog.Logger) *bodyCapture {
+	c := &bodyCapture{
+		limits:  limits,
+		enabled: enabled,
+		logger:  logger,
+	}
+	if enabled {
+		c.buf = bufpool.Get()
+	}
+	return c
+}
+
+func (c *bodyCapture) Write(p []byte) (int, error) {
+	n := len(p)
+	c.size += int64(n)
+	if !c.enabled || c.truncated {
+		return n, nil
+	}
+	if m := c.limits.maxBytes; m > 0 && c.captured+int64(len(p)) > m {
+		p = p[:m-c.captured]
+		c.truncated = true
+	}
+	if c.file == nil && c.limits.spillThreshold > 0 &&
+		int64(c.buf.Len()+len(p)) > c.limits.spillThreshold {
+		if err := c.spill(); err != nil {
+			c.logger.Error("spill failed, capture truncated", slog.String("err", err.Error()))
+			c.truncated = true
+			return n, nil
+		}
+	}
+	if c.file != nil {
+		if _, err := c.file.Write(p); err != nil {
+			c.logger.Error("write spill file failed, capture truncated", slog.String("err", err.Error()))
+			c.truncated = true
+			return n, nil
+		}
+	} else {
+		c.buf.Write(p)
+	}
+	c.captured += int64(len(p))
+	return n, nil
+}
+
+// spill moves the captured bytes to a temp file.
+func (c *bodyCapture) spill() error {
+	f, err := os.CreateTemp(c.limits.spillDir, "httptap-body-*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(c.buf.Bytes()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	c.logger.Debug("body spilled to file", slog.String("file", f.Name()))
+	bufpool.Put(c.buf)
+	c.buf = nil
+	c.file = f
+	return nil
+}
+
+// finish closes the spill file, the capture cannot be written to afterwards.
+func (c *bodyCapture) finish() (buf *bytes.Buffer, file string) {
+	c.enabled = false
+	if c.file == nil {
+		return c.buf, ""
+	}
+	if err := c.file.Close(); err != nil {
+		c.logger.Error("close spill file failed", slog.String("err", err.Error()))
+	}
+	return nil, c.file.Name()
+}
+
+// release removes the spill file and returns the buffer to the pool.
+func (c *bodyCapture) release() {
+	if c.file != nil {
+		c.file.Close()
+		os.Remove(c.file.Name())
+		c.file = nil
+	}
+	bufpool.Put(c.buf)
+	c.buf = nil
+}
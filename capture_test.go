@@ -0,0 +1,96 @@
+package httptap
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"testing"
+)
+
+func TestBodyCapture(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789"), 100)
+
+	cases := []struct {
+		name          string
+		enabled       bool
+		limits        captureLimits
+		wantBuf       []byte
+		wantFile      []byte
+		wantTruncated bool
+	}{
+		{
+			name:    "disabled",
+			enabled: false,
+		},
+		{
+			name:    "unlimited",
+			enabled: true,
+			wantBuf: body,
+		},
+		{
+			name:          "truncated",
+			enabled:       true,
+			limits:        captureLimits{maxBytes: 15},
+			wantBuf:       body[:15],
+			wantTruncated: true,
+		},
+		{
+			name:     "spilled",
+			enabled:  true,
+			limits:   captureLimits{spillThreshold: 100, spillDir: t.TempDir()},
+			wantFile: body,
+		},
+		{
+			name:          "spilled and truncated",
+			enabled:       true,
+			limits:        captureLimits{maxBytes: 500, spillThreshold: 100, spillDir: t.TempDir()},
+			wantFile:      body[:500],
+			wantTruncated: true,
+		},
+	}
+	for _, cc := range cases {
+		t.Run(cc.name, func(t *testing.T) {
+			c := newBodyCapture(cc.enabled, cc.limits, slog.Default())
+			// Write in small chunks like a tee does.
+			for i := 0; i < len(body); i += 7 {
+				chunk := body[i:min(i+7, len(body))]
+				n, err := c.Write(chunk)
+				if n != len(chunk) || err != nil {
+					t.Fatalf("write returned %d, %v", n, err)
+				}
+			}
+			buf, file := c.finish()
+			defer c.release()
+
+			if c.size != int64(len(body)) {
+				t.Errorf("size: got %d, want %d", c.size, len(body))
+			}
+			if c.truncated != cc.wantTruncated {
+				t.Errorf("truncated: got %t, want %t", c.truncated, cc.wantTruncated)
+			}
+			if cc.wantBuf != nil && (buf == nil || !bytes.Equal(buf.Bytes(), cc.wantBuf)) {
+				t.Errorf("buffer does not contain the expected body")
+			}
+			if cc.wantFile == nil {
+				if file != "" {
+					t.Errorf("unexpected spill file %s", file)
+				}
+				return
+			}
+			if buf != nil {
+				t.Errorf("expected no buffer for spilled body")
+			}
+			b, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatalf("error reading spill file: %s", err)
+			}
+			if !bytes.Equal(b, cc.wantFile) {
+				t.Errorf("spill file does not contain the expected body")
+			}
+			c.release()
+			if _, err := os.Stat(file); !os.IsNotExist(err) {
+				t.Errorf("spill file not removed")
+			}
+		})
+	}
+}
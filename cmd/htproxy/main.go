@@ -2,69 +2,178 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 
 	"github.com/myhops/httptap/command"
+	"github.com/myhops/httptap/command/convert"
+	"github.com/myhops/httptap/command/replay"
 	"github.com/myhops/httptap/command/serve"
+	"github.com/myhops/httptap/command/validate"
 	"github.com/myhops/httptap/command/values"
+	"github.com/myhops/httptap/command/version"
 )
 
-type cmd struct {
-	fs *values.FlagSet
-	gc *command.GlobalCmd
-	sc *serve.ServeCmd
+// subcommand describes a command of htproxy for the help text.
+type subcommand struct {
+	name string
+	// args are the arguments after the flags in the usage line.
+	args string
+	// summary is the line in the list of commands.
+	summary string
+	// help is printed with the flags of the command.
+	help   string
+	newCmd func(gc *command.GlobalCmd) command.Cmd
 }
 
-func newCmd() *cmd {
-	gc := &command.GlobalCmd{}
-	res := &cmd{
-		fs: values.NewFlagSet("htproxy", flag.ExitOnError),
-		gc: gc,
-		sc:serve.NewServeCmd(gc),
+var subcommands = []subcommand{
+	{
+		name:    "serve",
+		summary: "run the proxy and serve the exchanges to the taps",
+		help: `Proxies the requests to the upstream and serves the exchanges to the taps
+of the tap config file. The config file is reloaded when it changes and on SIGHUP.`,
+		newCmd: func(gc *command.GlobalCmd) command.Cmd { return serve.NewServeCmd(gc) },
+	},
+	{
+		name:    "validate",
+		args:    "file...",
+		summary: "check tap config files",
+		help: `Checks tap config files without starting the proxy and reports the problems
+with their line and column. Exits with 1 when a file has errors.`,
+		newCmd: func(gc *command.GlobalCmd) command.Cmd { return validate.NewValidateCmd(gc) },
+	},
+	{
+		name:    "replay",
+		args:    "[file...]",
+		summary: "send the requests recorded by the log tap again",
+		help: `Reads the records of the log tap, written with -logformat json, from the
+files or stdin and sends the requests to the target one by one. Prints the
+status of every response and exits with 1 when a status differs from the
+recorded one. Request bodies are only replayed when recorded with bodyJSON.`,
+		newCmd: func(gc *command.GlobalCmd) command.Cmd { return replay.NewReplayCmd(gc) },
+	},
+	{
+		name:    "convert",
+		args:    "file",
+		summary: "convert a tap config file between YAML and JSON",
+		help: `Converts a tap config file from YAML to JSON or from JSON to YAML, keeping
+the order of the fields. The file is checked like validate does first.`,
+		newCmd: func(gc *command.GlobalCmd) command.Cmd { return convert.NewConvertCmd(gc) },
+	},
+	{
+		name:    "version",
+		summary: "print the version",
+		help:    `Prints the version of htproxy, the Go version and the commit it is built from.`,
+		newCmd:  func(gc *command.GlobalCmd) command.Cmd { return version.NewVersionCmd(gc) },
+	},
+}
+
+func findSubcommand(name string) (subcommand, bool) {
+	for _, sc := range subcommands {
+		if sc.name == name {
+			return sc, true
+		}
 	}
-	res.gc.Flags(res.fs)
-	res.sc.Flags(res.fs)
-	return res
+	return subcommand{}, false
 }
 
-func (c *cmd) init() error {
-	if err := c.gc.Init(); err != nil {
-		return err
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "usage: htproxy <command> [flags] [args]\n\ncommands:\n")
+	for _, sc := range subcommands {
+		fmt.Fprintf(w, "  %-10s %s\n", sc.name, sc.summary)
 	}
-	return nil
+	fmt.Fprintf(w, "\nRun htproxy help <command> for the flags of a command.\n")
+	fmt.Fprintf(w, "Without a command htproxy serves, htproxy [flags] is htproxy serve [flags].\n")
 }
 
-func (c *cmd) run(ctx context.Context) error {
-	return c.sc.Run(ctx)
+// newFlagSet returns the flags of the command and of the global command.
+func (sc subcommand) newFlagSet(gc *command.GlobalCmd, cmd command.Cmd) *values.FlagSet {
+	fs := values.NewFlagSet("htproxy "+sc.name, flag.ContinueOnError)
+	gc.Flags(fs)
+	cmd.Flags(fs)
+	fs.Usage = func() {
+		w := fs.Output()
+		fmt.Fprintf(w, "usage: htproxy %s [flags] %s\n\n%s\n\nflags:\n", sc.name, sc.args, sc.help)
+		fs.PrintDefaults()
+	}
+	return fs
 }
 
-func run(args []string) error {
-	cmd := newCmd()
-	if err := cmd.fs.Parse(args[1:]); err != nil {
+func runSubcommand(ctx context.Context, sc subcommand, args []string) error {
+	gc := &command.GlobalCmd{}
+	cmd := sc.newCmd(gc)
+	fs := sc.newFlagSet(gc, cmd)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
 		return err
 	}
+	if ac, ok := cmd.(command.ArgsCmd); ok {
+		if err := ac.SetArgs(fs.Args()); err != nil {
+			return err
+		}
+	} else if fs.NArg() > 0 {
+		return fmt.Errorf("%w: %s", command.ErrUnexpectedArguments, strings.Join(fs.Args(), " "))
+	}
 
 	// Init the commands.
-	if err := cmd.init(); err != nil {
-		return fmt.Errorf("error calling cmd.init: %w", err)
+	if err := gc.Init(); err != nil {
+		return fmt.Errorf("error calling gc.Init: %w", err)
 	}
 	// We have a logger.
-	slog.SetDefault(cmd.gc.Logger)
-	slog.SetLogLoggerLevel(cmd.gc.LogLevel.Level())
-	cmd.sc.GlobalCmd = cmd.gc
+	slog.SetDefault(gc.Logger)
+	slog.SetLogLoggerLevel(gc.LogLevel.Level())
+	return cmd.Run(ctx)
+}
+
+func run(args []string) error {
+	args = args[1:]
+	name := "serve"
+	if len(args) > 0 {
+		switch a := args[0]; {
+		case a == "-h" || a == "-help" || a == "--help":
+			usage(os.Stdout)
+			return nil
+		case a == "help":
+			if len(args) == 1 {
+				usage(os.Stdout)
+				return nil
+			}
+			sc, ok := findSubcommand(args[1])
+			if !ok {
+				usage(os.Stderr)
+				return fmt.Errorf("unknown command %q", args[1])
+			}
+			fs := sc.newFlagSet(&command.GlobalCmd{}, sc.newCmd(&command.GlobalCmd{}))
+			fs.SetOutput(os.Stdout)
+			fs.Usage()
+			return nil
+		case !strings.HasPrefix(a, "-"):
+			name, args = a, args[1:]
+		}
+	}
+	sc, ok := findSubcommand(name)
+	if !ok {
+		usage(os.Stderr)
+		return fmt.Errorf("unknown command %q", name)
+	}
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
-	return cmd.run(ctx)
+	return runSubcommand(ctx, sc, args)
 }
 
 func main() {
 	err := run(os.Args)
 	if err != nil {
 		slog.Error("run returned error", slog.String("err", err.Error()))
+		os.Exit(1)
 	}
 }
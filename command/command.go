@@ -0,0 +1,25 @@
+package command
+
+import (
+	"context"
+	"errors"
+
+	"github.com/myhops/httptap/command/values"
+)
+
+// ErrUnexpectedArguments is returned for arguments after the flags
+// of a command that takes none.
+var ErrUnexpectedArguments = errors.New("unexpected arguments")
+
+// Cmd is a subcommand of htproxy. The flags of GlobalCmd are added to its
+// flag set and GlobalCmd is initialized before Run.
+type Cmd interface {
+	Flags(fs *values.FlagSet)
+	Run(ctx context.Context) error
+}
+
+// ArgsCmd is a command that takes arguments after the flags, like files.
+type ArgsCmd interface {
+	Cmd
+	SetArgs(args []string) error
+}
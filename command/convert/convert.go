@@ -0,0 +1,208 @@
+// Package convert implements the command that converts tap config files
+// between YAML and JSON.
+package convert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/myhops/httptap/command"
+	"github.com/myhops/httptap/command/validate"
+	"github.com/myhops/httptap/command/values"
+	"github.com/myhops/httptap/config"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrNoFile        = errors.New("no config file")
+	ErrUnknownFormat = errors.New("unknown format")
+)
+
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+type ConvertCmd struct {
+	GlobalCmd *command.GlobalCmd
+
+	// To is the format of the output, json or yaml. When empty JSON is
+	// converted to YAML and YAML to JSON.
+	To string
+	// Output is the file that is written, Out when empty.
+	Output string
+	// File is the config file.
+	File string
+
+	// Out receives the output and the diagnostics, stdout when nil.
+	Out io.Writer
+}
+
+func NewConvertCmd(global *command.GlobalCmd) *ConvertCmd {
+	return &ConvertCmd{GlobalCmd: global}
+}
+
+func (c *ConvertCmd) Flags(fs *values.FlagSet) {
+	fs.StringVar(&c.To, "to", "", "output format, json or yaml, defaults to the other format")
+	fs.StringVar(&c.Output, "o", "", "output file, defaults to stdout")
+}
+
+// SetArgs sets the config file.
+func (c *ConvertCmd) SetArgs(args []string) error {
+	switch len(args) {
+	case 0:
+		return ErrNoFile
+	case 1:
+		c.File = args[0]
+		return nil
+	}
+	return fmt.Errorf("%w: one file expected, got %d", command.ErrUnexpectedArguments, len(args))
+}
+
+// Run converts the file. The file must be a valid tap config, the order of
+// the fields is kept.
+func (c *ConvertCmd) Run(ctx context.Context) error {
+	out := c.Out
+	if out == nil {
+		out = os.Stdout
+	}
+	if c.File == "" {
+		return ErrNoFile
+	}
+	blob, err := os.ReadFile(c.File)
+	if err != nil {
+		return err
+	}
+	if cfg, _, diags := config.DecodeTapHandler(blob); cfg == nil {
+		for _, d := range diags {
+			fmt.Fprintf(out, "%s:%s\n", c.File, d)
+		}
+		return validate.ErrInvalidConfig
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(blob, &doc); err != nil {
+		return err
+	}
+
+	to := c.To
+	if to == "" {
+		to = FormatJSON
+		if isJSON(blob) {
+			to = FormatYAML
+		}
+	}
+	var res []byte
+	switch to {
+	case FormatJSON:
+		res, err = toJSON(&doc)
+	case FormatYAML:
+		res, err = toYAML(&doc)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownFormat, to)
+	}
+	if err != nil {
+		return err
+	}
+	if c.Output != "" {
+		return os.WriteFile(c.Output, res, 0o644)
+	}
+	_, err = out.Write(res)
+	return err
+}
+
+func isJSON(blob []byte) bool {
+	b := bytes.TrimSpace(blob)
+	return len(b) > 0 && (b[0] == '{' || b[0] == '[')
+}
+
+// toJSON writes the document as indented JSON.
+func toJSON(doc *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	if len(doc.Content) > 0 {
+		if err := writeJSON(&buf, doc.Content[0]); err != nil {
+			return nil, err
+		}
+	} else {
+		buf.WriteString("{}")
+	}
+	var res bytes.Buffer
+	if err := json.Indent(&res, buf.Bytes(), "", "  "); err != nil {
+		return nil, err
+	}
+	res.WriteByte('\n')
+	return res.Bytes(), nil
+}
+
+// writeJSON writes n in the order of the document, a map would sort the keys.
+func writeJSON(buf *bytes.Buffer, n *yaml.Node) error {
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	switch n.Kind {
+	case yaml.MappingNode:
+		buf.WriteByte('{')
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			key, _ := json.Marshal(n.Content[i].Value)
+			buf.Write(key)
+			buf.WriteByte(':')
+			if err := writeJSON(buf, n.Content[i+1]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case yaml.SequenceNode:
+		buf.WriteByte('[')
+		for i, item := range n.Content {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSON(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	default:
+		var v any
+		if err := n.Decode(&v); err != nil {
+			return err
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n.Line, err)
+		}
+		buf.Write(b)
+	}
+	return nil
+}
+
+// toYAML writes the document in block style.
+func toYAML(doc *yaml.Node) ([]byte, error) {
+	blockStyle(doc)
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// blockStyle removes the flow style and the quotes of JSON, the encoder
+// quotes the strings that need it.
+func blockStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, c := range n.Content {
+		blockStyle(c)
+	}
+}
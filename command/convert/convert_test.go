@@ -0,0 +1,99 @@
+package convert
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/myhops/httptap/command/validate"
+	"github.com/myhops/httptap/config"
+)
+
+const yamlConfig = `taps:
+  - name: orders
+    patterns: ["GET /orders/{id}"]
+    logTap: {}
+    mock:
+      status: 200
+      headers:
+        X-Version: "2"
+      template: |
+        {"id": "{{.PathValues.id}}"}
+    fault:
+      latency:
+        percentage: 12.5
+        delay: 100ms
+`
+
+func TestConvert(t *testing.T) {
+	dir := t.TempDir()
+	convert := func(file, to string) (string, error) {
+		var out bytes.Buffer
+		c := &ConvertCmd{File: file, To: to, Out: &out}
+		err := c.Run(context.Background())
+		return out.String(), err
+	}
+	write := func(name, content string) string {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+			t.Fatalf("write error: %s", err)
+		}
+		return file
+	}
+
+	// YAML to JSON keeps the order of the fields.
+	j, err := convert(write("taps.yaml", yamlConfig), "")
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	want := `{
+  "taps": [
+    {
+      "name": "orders",
+      "patterns": [
+        "GET /orders/{id}"
+      ],
+      "logTap": {},
+      "mock": {
+        "status": 200,
+        "headers": {
+          "X-Version": "2"
+        },
+        "template": "{\"id\": \"{{.PathValues.id}}\"}\n"
+      },
+      "fault": {
+        "latency": {
+          "percentage": 12.5,
+          "delay": "100ms"
+        }
+      }
+    }
+  ]
+}
+`
+	if j != want {
+		t.Errorf("got json\n%s", j)
+	}
+
+	// JSON back to YAML gives the same config.
+	y, err := convert(write("taps.json", j), "")
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	got, _, diags := config.DecodeTapHandler([]byte(y))
+	orig, _, _ := config.DecodeTapHandler([]byte(yamlConfig))
+	if len(diags) > 0 || !reflect.DeepEqual(got, orig) {
+		t.Errorf("got yaml\n%s", y)
+	}
+
+	if _, err := convert(write("bad.yaml", "taps:\n  - nme: orders\n"), ""); !errors.Is(err, validate.ErrInvalidConfig) {
+		t.Errorf("got error %v", err)
+	}
+	if _, err := convert(filepath.Join(dir, "taps.yaml"), "toml"); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("got error %v", err)
+	}
+}
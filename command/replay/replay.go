@@ -0,0 +1,289 @@
+// Package replay implements the command that sends the requests recorded
+// by the log tap again, to compare the responses with the recording.
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/myhops/httptap/command"
+	"github.com/myhops/httptap/command/values"
+	"github.com/myhops/httptap/tap"
+)
+
+var (
+	ErrNoRecords      = errors.New("no log tap records found")
+	ErrStatusMismatch = errors.New("responses differ from the recording")
+)
+
+// maxLine is the size of the longest record, the bodies are in the record.
+const maxLine = 16 << 20
+
+type ReplayCmd struct {
+	GlobalCmd *command.GlobalCmd
+
+	// Target is the base URL the requests are sent to, like the proxy.
+	Target *url.URL
+	// Tap replays only the records of the tap, all when empty.
+	Tap string
+	// Speed is the pace relative to the recording, 2 is twice as fast.
+	// 0 sends the requests without waiting.
+	Speed float64
+	// Files contain the log records, stdin when empty.
+	Files []string
+
+	// Client sends the requests, a client that does not follow redirects when nil.
+	Client *http.Client
+	// In is read when there are no files, stdin when nil.
+	In io.Reader
+	// Out receives the results, stdout when nil.
+	Out io.Writer
+}
+
+func NewReplayCmd(global *command.GlobalCmd) *ReplayCmd {
+	return &ReplayCmd{GlobalCmd: global}
+}
+
+func mustURL(u string) *url.URL {
+	uu, err := url.Parse(u)
+	if err != nil {
+		panic(fmt.Sprintf("mustURL error: %s", err.Error()))
+	}
+	return uu
+}
+
+func (c *ReplayCmd) Flags(fs *values.FlagSet) {
+	fs.URLVar(&c.Target, "target", mustURL("http://localhost:8080"), "base url the requests are sent to")
+	fs.StringVar(&c.Tap, "tap", "", "replay only the records of this tap")
+	fs.Float64Var(&c.Speed, "speed", 0, "pace relative to the recording, 0 sends the requests without waiting")
+}
+
+// SetArgs sets the files with the records.
+func (c *ReplayCmd) SetArgs(args []string) error {
+	c.Files = args
+	return nil
+}
+
+// record is a line written by the log tap with the json log format.
+type record struct {
+	Time            time.Time         `json:"time"`
+	Msg             string            `json:"msg"`
+	Method          string            `json:"method"`
+	URL             string            `json:"url"`
+	Status          string            `json:"status"`
+	TapName         string            `json:"tap_name"`
+	Host            string            `json:"host"`
+	RequestHeader   map[string]string `json:"request_header"`
+	RequestBodySize int64             `json:"request_body_size"`
+	RequestBodyJSON json.RawMessage   `json:"request_body_json"`
+}
+
+// statusCode returns the code of the recorded status, 0 when the exchange failed.
+func (r *record) statusCode() int {
+	code, _, _ := strings.Cut(r.Status, " ")
+	n, _ := strconv.Atoi(code)
+	return n
+}
+
+// hashedRe matches the values masked with the hash mode.
+var hashedRe = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// maskedHeader reports if the log tap recorded the masked value of a header,
+// like *** or sha256:..., instead of the value the client sent.
+func maskedHeader(value string) bool {
+	return strings.HasPrefix(value, "***") || hashedRe.MatchString(value)
+}
+
+// headerMasked reports if a recorded request header is masked.
+func (r *record) headerMasked() bool {
+	for _, v := range r.RequestHeader {
+		if maskedHeader(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipHeaders are not replayed, the client sets them or the proxy adds them again.
+var skipHeaders = []string{
+	"Connection", "Content-Length", "Keep-Alive", "Proxy-Connection",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+func skipHeader(key string) bool {
+	key = http.CanonicalHeaderKey(key)
+	for _, h := range skipHeaders {
+		if h == key {
+			return true
+		}
+	}
+	return strings.HasPrefix(key, "X-Forwarded-")
+}
+
+// request returns the recorded request, sent to the target.
+// The request body is only recorded with bodyJSON, masked headers are not sent.
+func (c *ReplayCmd) request(ctx context.Context, rec *record) (*http.Request, error) {
+	ru, err := url.Parse(rec.URL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing recorded url: %w", err)
+	}
+	u := *c.Target
+	u.Path = strings.TrimSuffix(u.Path, "/") + ru.Path
+	u.RawPath = ""
+	u.RawQuery = ru.RawQuery
+
+	var body io.Reader
+	if len(rec.RequestBodyJSON) > 0 {
+		body = bytes.NewReader(rec.RequestBodyJSON)
+	}
+	req, err := http.NewRequestWithContext(ctx, rec.Method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	// The Host header is not sent from Header.
+	if rec.Host != "" {
+		req.Host = rec.Host
+	}
+	for k, v := range rec.RequestHeader {
+		switch {
+		case http.CanonicalHeaderKey(k) == "Host":
+			req.Host = v
+		case !skipHeader(k) && !maskedHeader(v):
+			req.Header.Set(k, v)
+		}
+	}
+	return req, nil
+}
+
+// Run sends the recorded requests one by one and writes the status of every
+// response. It returns ErrStatusMismatch when a status differs from the
+// recorded one or a request fails.
+func (c *ReplayCmd) Run(ctx context.Context) error {
+	out := c.Out
+	if out == nil {
+		out = os.Stdout
+	}
+	client := c.Client
+	if client == nil {
+		// Compare a recorded redirect with the redirect, not with its target.
+		client = &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		}
+	}
+
+	var replayed, differ int
+	var first time.Time
+	start := time.Now()
+	replay := func(rec *record) error {
+		if c.Speed > 0 {
+			if first.IsZero() {
+				first = rec.Time
+			}
+			wait := time.Until(start.Add(time.Duration(float64(rec.Time.Sub(first)) / c.Speed)))
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+		replayed++
+		req, err := c.request(ctx, rec)
+		if err == nil {
+			var resp *http.Response
+			if resp, err = client.Do(req); err == nil {
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				line := fmt.Sprintf("%d %s %s", resp.StatusCode, req.Method, req.URL.RequestURI())
+				if want := rec.statusCode(); want != 0 && want != resp.StatusCode {
+					differ++
+					line += fmt.Sprintf(" (recorded %d)", want)
+				}
+				if rec.RequestBodySize > 0 && len(rec.RequestBodyJSON) == 0 {
+					line += " (body not recorded)"
+				}
+				if rec.headerMasked() {
+					line += " (header masked)"
+				}
+				fmt.Fprintln(out, line)
+				return nil
+			}
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		differ++
+		fmt.Fprintf(out, "error %s %s: %s\n", rec.Method, rec.URL, err)
+		return nil
+	}
+
+	files := c.Files
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	for _, file := range files {
+		if err := c.readRecords(file, replay); err != nil {
+			return err
+		}
+	}
+	if replayed == 0 {
+		return ErrNoRecords
+	}
+	fmt.Fprintf(out, "replayed %d requests, %d differ\n", replayed, differ)
+	if differ > 0 {
+		return fmt.Errorf("%w: %d of %d", ErrStatusMismatch, differ, replayed)
+	}
+	return nil
+}
+
+// readRecords calls f with the log tap records in file, - is stdin.
+// The other lines, like the log of the proxy, are skipped.
+func (c *ReplayCmd) readRecords(file string, f func(rec *record) error) error {
+	var r io.Reader
+	if file == "-" {
+		r = c.In
+		if r == nil {
+			r = os.Stdin
+		}
+	} else {
+		fh, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer fh.Close()
+		r = fh
+	}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), maxLine)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 || line[0] != '{' {
+			continue
+		}
+		var rec record
+		if err := json.Unmarshal(line, &rec); err != nil || rec.Msg != tap.LogTapMessage {
+			continue
+		}
+		if c.Tap != "" && rec.TapName != c.Tap {
+			continue
+		}
+		if err := f(&rec); err != nil {
+			return err
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("error reading %s: %w", file, err)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/myhops/httptap"
+	"github.com/myhops/httptap/tap"
+)
+
+func TestReplay(t *testing.T) {
+	// Record two exchanges with the log tap.
+	var records bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&records, nil))
+	recorded := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	p, err := httptap.New(recorded.URL, httptap.WithLogger(logger))
+	if err != nil {
+		t.Fatalf("error creating proxy: %s", err)
+	}
+	p.Tap([]string{"/orders/"}, tap.NewLogTap(logger, slog.LevelInfo),
+		httptap.WithTapName("orders"), httptap.WithRequestBody(true), httptap.WithRequestJSON(true))
+	p.Tap([]string{"/health"}, tap.NewLogTap(logger, slog.LevelInfo), httptap.WithTapName("health"))
+	ps := httptest.NewServer(p)
+	for _, req := range []struct{ method, path, body string }{
+		{http.MethodPost, "/orders/?dry=1", `{"id":42}`},
+		{http.MethodGet, "/orders/42", ""},
+		{http.MethodGet, "/health", ""},
+	} {
+		r, _ := http.NewRequest(req.method, ps.URL+req.path, strings.NewReader(req.body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-Tenant", "acme")
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("request error: %s", err)
+		}
+		resp.Body.Close()
+	}
+	ps.Close()
+	recorded.Close()
+
+	// Replay them against an upstream that fails the GET.
+	var (
+		mu   sync.Mutex
+		seen []string
+	)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		seen = append(seen, r.Method+" "+r.URL.RequestURI()+" "+r.Header.Get("X-Tenant")+" "+string(b))
+		mu.Unlock()
+		switch r.Method {
+		case http.MethodPost:
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer target.Close()
+	tu, _ := url.Parse(target.URL)
+
+	var out bytes.Buffer
+	c := &ReplayCmd{
+		Target: tu,
+		Tap:    "orders",
+		In:     strings.NewReader("not a record\n" + records.String()),
+		Out:    &out,
+	}
+	err = c.Run(context.Background())
+	if !errors.Is(err, ErrStatusMismatch) {
+		t.Errorf("got error %v", err)
+	}
+	want := "201 POST /orders/?dry=1\n500 GET /orders/42 (recorded 200)\nreplayed 2 requests, 1 differ\n"
+	if out.String() != want {
+		t.Errorf("got output\n%s", out.String())
+	}
+	if len(seen) != 2 || seen[0] != `POST /orders/?dry=1 acme {"id":42}` || seen[1] != "GET /orders/42 acme " {
+		t.Errorf("target got %q", seen)
+	}
+
+	c.In = strings.NewReader("")
+	if err := c.Run(context.Background()); !errors.Is(err, ErrNoRecords) {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestReplayRedirectAndHost(t *testing.T) {
+	var host string
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			host = r.Host
+			http.Redirect(w, r, "/new", http.StatusFound)
+		}
+	}))
+	defer target.Close()
+	tu, _ := url.Parse(target.URL)
+
+	var out bytes.Buffer
+	c := &ReplayCmd{
+		Target: tu,
+		In: strings.NewReader(`{"msg":"` + tap.LogTapMessage + `","method":"GET","url":"/old",` +
+			`"status":"302 Found","host":"orders.example.com"}` + "\n"),
+		Out: &out,
+	}
+	if err := c.Run(context.Background()); err != nil {
+		t.Errorf("got error %v, output\n%s", err, out.String())
+	}
+	if host != "orders.example.com" {
+		t.Errorf("target got host %q", host)
+	}
+}
+
+func TestReplayMaskedHeader(t *testing.T) {
+	var seen http.Header
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Clone()
+	}))
+	defer target.Close()
+	tu, _ := url.Parse(target.URL)
+
+	hash := "sha256:" + strings.Repeat("ab", 32)
+	var out bytes.Buffer
+	c := &ReplayCmd{
+		Target: tu,
+		In: strings.NewReader(`{"msg":"` + tap.LogTapMessage + `","method":"GET","url":"/orders","status":"200 OK",` +
+			`"request_header":{"Authorization":"***","X-Api-Key":"***1234","X-Session":"` + hash + `","X-Tenant":"acme"}}` + "\n"),
+		Out: &out,
+	}
+	if err := c.Run(context.Background()); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if want := "200 GET /orders (header masked)\nreplayed 1 requests, 0 differ\n"; out.String() != want {
+		t.Errorf("got output\n%s", out.String())
+	}
+	for _, h := range []string{"Authorization", "X-Api-Key", "X-Session"} {
+		if v := seen.Get(h); v != "" {
+			t.Errorf("masked header %s replayed as %q", h, v)
+		}
+	}
+	if seen.Get("X-Tenant") != "acme" {
+		t.Errorf("header not replayed: %v", seen)
+	}
+}
@@ -0,0 +1,233 @@
+package serve
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/myhops/httptap"
+	"github.com/myhops/httptap/config"
+	"github.com/myhops/httptap/metrics"
+)
+
+// adminHandler returns the handler of the admin listener.
+//
+//	GET  /metrics               the metrics in the Prometheus text format
+//	GET  /taps                  the taps with their patterns and options
+//	POST /taps/{name}/enable    serve the records to the tap
+//	POST /taps/{name}/disable   stop serving the records to the tap
+//	PUT  /taps/{name}/capture   {"request": true, "response": false}
+//	GET  /loglevel              {"level": "INFO"}
+//	PUT  /loglevel              {"level": "debug"}
+//
+// The changes require the admin token when it is set.
+func (c *ServeCmd) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", c.registry)
+	mux.HandleFunc("GET /taps", c.listTaps)
+	mux.HandleFunc("POST /taps/{name}/enable", c.authorize(c.enableTap(true)))
+	mux.HandleFunc("POST /taps/{name}/disable", c.authorize(c.enableTap(false)))
+	mux.HandleFunc("PUT /taps/{name}/capture", c.authorize(c.setCapture))
+	mux.HandleFunc("GET /loglevel", c.getLogLevel)
+	mux.HandleFunc("PUT /loglevel", c.authorize(c.setLogLevel))
+	return mux
+}
+
+// authorize checks the bearer token of the request when the admin token is set.
+func (c *ServeCmd) authorize(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if c.adminToken == "" {
+			next(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(c.adminToken)) != 1 {
+			c.adminLogger(r).Warn("admin change not authorized", slog.String("path", r.URL.Path))
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errors.New("not authorized"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// adminAddress returns the address of the admin listener,
+// an address without host listens on the loopback interface only.
+func adminAddress(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host != "" {
+		return addr
+	}
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+// adminToken returns the token the changes require, empty without TokenEnv.
+func adminToken(a *config.Admin) (string, error) {
+	if a.TokenEnv == "" {
+		return "", nil
+	}
+	token := os.Getenv(a.TokenEnv)
+	if token == "" {
+		return "", fmt.Errorf("%s is not set or empty", a.TokenEnv)
+	}
+	return token, nil
+}
+
+// startAdmin starts the admin listener when it is configured.
+// The returned server is nil without admin listener.
+func (c *ServeCmd) startAdmin(ctx context.Context) (*http.Server, error) {
+	if c.TapHandlerConfig == nil || c.TapHandlerConfig.Admin == nil {
+		return nil, nil
+	}
+	token, err := adminToken(c.TapHandlerConfig.Admin)
+	if err != nil {
+		return nil, fmt.Errorf("admin.tokenEnv: %w", err)
+	}
+	c.adminToken = token
+	c.registry = metrics.NewRegistry()
+	addr := adminAddress(c.TapHandlerConfig.Admin.ListenAddress)
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{
+		Handler:           c.adminHandler(),
+		BaseContext:       func(_ net.Listener) context.Context { return ctx },
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	c.GlobalCmd.Logger.Info("starting admin server", slog.String("address", l.Addr().String()))
+	go srv.Serve(l)
+	return srv, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// tapError writes the error of a tap change.
+func tapError(w http.ResponseWriter, err error) {
+	if errors.Is(err, httptap.ErrTapNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err)
+}
+
+// proxy returns the running proxy, it writes an error when there is none.
+// The changes apply to the running proxy, a reload starts with the config.
+func (c *ServeCmd) proxy(w http.ResponseWriter) *httptap.Proxy {
+	inst := c.current.Load()
+	if inst == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("proxy not running"))
+		return nil
+	}
+	return inst.proxy
+}
+
+// adminLogger returns the logger of the admin changes.
+func (c *ServeCmd) adminLogger(r *http.Request) *slog.Logger {
+	return c.GlobalCmd.Logger.With(
+		slog.String("step", "admin"),
+		slog.String("remote", r.RemoteAddr),
+	)
+}
+
+func (c *ServeCmd) listTaps(w http.ResponseWriter, r *http.Request) {
+	p := c.proxy(w)
+	if p == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, p.Taps())
+}
+
+func (c *ServeCmd) enableTap(enabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := c.proxy(w)
+		if p == nil {
+			return
+		}
+		name := r.PathValue("name")
+		c.adminLogger(r).Info("change tap", slog.String("tap", name), slog.Bool("enabled", enabled))
+		if err := p.SetTapEnabled(name, enabled); err != nil {
+			tapError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// captureRequest is the body of PUT /taps/{name}/capture, a missing field
+// leaves the setting as is.
+type captureRequest struct {
+	Request  *bool `json:"request"`
+	Response *bool `json:"response"`
+}
+
+func (c *ServeCmd) setCapture(w http.ResponseWriter, r *http.Request) {
+	p := c.proxy(w)
+	if p == nil {
+		return
+	}
+	var req captureRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	name := r.PathValue("name")
+	logger := c.adminLogger(r).With(slog.String("tap", name))
+	if req.Request != nil {
+		logger = logger.With(slog.Bool("request_body", *req.Request))
+	}
+	if req.Response != nil {
+		logger = logger.With(slog.Bool("response_body", *req.Response))
+	}
+	logger.Info("change body capture")
+	if err := p.SetTapBodyCapture(name, req.Request, req.Response); err != nil {
+		tapError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type logLevel struct {
+	Level string `json:"level"`
+}
+
+func (c *ServeCmd) getLogLevel(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, logLevel{Level: c.GlobalCmd.LogLevel.Level().String()})
+}
+
+func (c *ServeCmd) setLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req logLevel
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(req.Level)); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	// Log at the higher level, so the change is visible before and after.
+	old := c.GlobalCmd.LogLevel.Level()
+	c.adminLogger(r).Log(r.Context(), max(old, level), "change log level",
+		slog.String("from", old.String()),
+		slog.String("to", level.String()),
+	)
+	c.GlobalCmd.LogLevel.Set(level)
+	writeJSON(w, http.StatusOK, logLevel{Level: level.String()})
+}
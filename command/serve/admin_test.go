@@ -0,0 +1,139 @@
+package serve
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/myhops/httptap"
+	"github.com/myhops/httptap/command"
+	"github.com/myhops/httptap/metrics"
+)
+
+func TestAdmin(t *testing.T) {
+	var logs bytes.Buffer
+	level := &slog.LevelVar{}
+	level.Set(slog.LevelWarn)
+	c := &ServeCmd{
+		GlobalCmd: &command.GlobalCmd{
+			Logger:   slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: level})),
+			LogLevel: level,
+		},
+		registry: metrics.NewRegistry(),
+	}
+	admin := httptest.NewServer(c.adminHandler())
+	defer admin.Close()
+
+	do := func(method, path, body string) *http.Response {
+		req, _ := http.NewRequest(method, admin.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %s", method, path, err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	if resp := do(http.MethodGet, "/taps", ""); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("without proxy got %s", resp.Status)
+	}
+
+	p, err := httptap.New("http://localhost:1", httptap.WithLogger(c.GlobalCmd.Logger), httptap.WithMetrics(c.registry))
+	if err != nil {
+		t.Fatalf("error creating proxy: %s", err)
+	}
+	p.Tap([]string{"GET /"}, httptap.TapFunc(func(context.Context, *httptap.RequestResponse) {}),
+		httptap.WithTapName("log tap"))
+	c.current.Store(&instance{proxy: p})
+
+	if resp := do(http.MethodPost, "/taps/log%20tap/disable", ""); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("disable got %s", resp.Status)
+	}
+	if resp := do(http.MethodPut, "/taps/log%20tap/capture", `{"request": true}`); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("capture got %s", resp.Status)
+	}
+	if resp := do(http.MethodPost, "/taps/other/enable", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown tap got %s", resp.Status)
+	}
+
+	var taps []httptap.TapInfo
+	json.NewDecoder(do(http.MethodGet, "/taps", "").Body).Decode(&taps)
+	if len(taps) != 1 || taps[0].Enabled || !taps[0].RequestBody || taps[0].ResponseBody {
+		t.Errorf("got taps %+v", taps)
+	}
+
+	if resp := do(http.MethodPut, "/loglevel", `{"level": "loud"}`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("bad level got %s", resp.Status)
+	}
+	if resp := do(http.MethodPut, "/loglevel", `{"level": "debug"}`); resp.StatusCode != http.StatusOK {
+		t.Errorf("set level got %s", resp.Status)
+	}
+	if level.Level() != slog.LevelDebug {
+		t.Errorf("level is %s", level.Level())
+	}
+	// The change of the level is logged although it is below warn.
+	if !strings.Contains(logs.String(), `msg="change log level" step=admin`) ||
+		!strings.Contains(logs.String(), "from=WARN to=DEBUG") {
+		t.Errorf("change not logged: %s", logs.String())
+	}
+
+	if resp := do(http.MethodGet, "/metrics", ""); resp.Header.Get("Content-Type") != metrics.ContentType {
+		t.Errorf("metrics got %s", resp.Header.Get("Content-Type"))
+	}
+}
+
+func TestAdminToken(t *testing.T) {
+	level := &slog.LevelVar{}
+	c := &ServeCmd{
+		GlobalCmd: &command.GlobalCmd{
+			Logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+			LogLevel: level,
+		},
+		registry:   metrics.NewRegistry(),
+		adminToken: "secret",
+	}
+	admin := httptest.NewServer(c.adminHandler())
+	defer admin.Close()
+
+	for _, tt := range []struct {
+		method, path, auth string
+		want               int
+	}{
+		{http.MethodPut, "/loglevel", "", http.StatusUnauthorized},
+		{http.MethodPut, "/loglevel", "Bearer wrong", http.StatusUnauthorized},
+		{http.MethodPut, "/loglevel", "Bearer secret", http.StatusOK},
+		{http.MethodGet, "/loglevel", "", http.StatusOK},
+		{http.MethodPost, "/taps/log/disable", "", http.StatusUnauthorized},
+	} {
+		req, _ := http.NewRequest(tt.method, admin.URL+tt.path, strings.NewReader(`{"level": "debug"}`))
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %s", tt.method, tt.path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s %s with %q got %s, want %d", tt.method, tt.path, tt.auth, resp.Status, tt.want)
+		}
+	}
+}
+
+func TestAdminAddress(t *testing.T) {
+	for addr, want := range map[string]string{
+		":9090":          "127.0.0.1:9090",
+		"localhost:9090": "localhost:9090",
+		"0.0.0.0:9090":   "0.0.0.0:9090",
+	} {
+		if got := adminAddress(addr); got != want {
+			t.Errorf("adminAddress(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/myhops/httptap"
+	"github.com/myhops/httptap/command"
+	"github.com/myhops/httptap/config"
+	"github.com/myhops/httptap/tap"
+)
+
+// Check reports the problems in cfg that make serve fail. The proxy and the
+// taps are created like serve does, without listening or sending anything,
+// the errors of the options are the diagnostics of their values.
+// The diagnostics have a path but no position.
+func (c *ServeCmd) Check(cfg *config.TapHandler) []config.Diagnostic {
+	if cfg == nil {
+		return nil
+	}
+	ck := &checker{}
+	sc := &ServeCmd{
+		GlobalCmd: &command.GlobalCmd{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))},
+		Upstream:  c.Upstream,
+	}
+	upstream := "http://localhost"
+	if c.Upstream != nil {
+		upstream = c.Upstream.String()
+	}
+
+	if d := cfg.Dispatch; d != nil {
+		switch httptap.DropPolicy(d.Policy) {
+		case "", httptap.DropOldest, httptap.DropNewest, httptap.Block:
+		default:
+			ck.errorf("dispatch.policy", "unknown policy %q, expected %s, %s or %s",
+				d.Policy, httptap.DropOldest, httptap.DropNewest, httptap.Block)
+		}
+	}
+	if a := cfg.Admin; a != nil {
+		if _, err := adminToken(a); err != nil {
+			ck.errorf("admin.tokenEnv", "%s", err)
+		}
+	}
+	ck.checkMasks("header.mask", cfg.Header.Mask)
+	p, err := httptap.New(upstream, sc.getProxyOptions(cfg)...)
+	if err != nil {
+		ck.errorf("requestId", "%s", err)
+		p, _ = httptap.New(upstream, httptap.WithLogger(sc.GlobalCmd.Logger))
+	}
+	defer p.Flush(context.Background())
+
+	names := map[string]string{}
+	for i, tcfg := range cfg.Taps {
+		path := fmt.Sprintf("taps[%d]", i)
+		if tcfg == nil {
+			ck.errorf(path, "empty tap")
+			continue
+		}
+		if tcfg.Name == "" {
+			ck.warnf(path, "no name, the admin API cannot address the tap")
+		} else if prev, ok := names[tcfg.Name]; ok {
+			ck.warnf(path+".name", "%q is also the name of %s, the admin API changes both", tcfg.Name, prev)
+		} else {
+			names[tcfg.Name] = path
+		}
+		ck.checkTap(path, tcfg)
+
+		patternsOK := len(tcfg.Patterns) > 0
+		if !patternsOK {
+			ck.errorf(path+".patterns", "%s", ErrNoPatterns)
+		}
+		for j, pattern := range tcfg.Patterns {
+			if err := checkPattern(pattern); err != nil {
+				ck.errorf(fmt.Sprintf("%s.patterns[%d]", path, j), "%s", err)
+				patternsOK = false
+			}
+		}
+		if !patternsOK {
+			continue
+		}
+		// Add the tap to find conflicting patterns and the options that fail,
+		// also with the values that could not be turned into options.
+		opts, err := sc.getTapOptions(tcfg)
+		if err != nil {
+			ck.tapErrors(path, err)
+		}
+		nop := httptap.TapFunc(func(context.Context, *httptap.RequestResponse) {})
+		if err := p.AddTap(tcfg.Patterns, nop, opts...); err != nil {
+			ck.tapErrors(path, err)
+			// Add the patterns to find the conflicts with the next taps.
+			p.AddTap(tcfg.Patterns, nop)
+		}
+	}
+	return ck.diags
+}
+
+// checkTap checks the parts of the tap that are not checked by its options.
+func (ck *checker) checkTap(path string, tcfg *config.Tap) {
+	if tcfg.LogTap == nil && tcfg.OTelTap == nil && tcfg.Mock == nil {
+		if tcfg.TemplateTap != nil {
+			ck.warnf(path+".templateTap", "serve does not create template taps, the tap is not added")
+		} else {
+			ck.warnf(path, "no logTap, otelTap or mock, the tap is not added")
+		}
+	}
+	if o := tcfg.OTelTap; o != nil {
+		if o.Endpoint == "" {
+			ck.errorf(path+".otelTap.endpoint", "no endpoint")
+		} else if u, err := url.Parse(o.Endpoint); err != nil {
+			ck.errorf(path+".otelTap.endpoint", "%s", err)
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			ck.errorf(path+".otelTap.endpoint", "%q is not an http or https URL", o.Endpoint)
+		}
+	}
+	if t := tcfg.TemplateTap; t != nil {
+		if _, err := tap.NewTemplateTap(slog.New(slog.NewTextHandler(io.Discard, nil)), t.Template, "", ""); err != nil {
+			ck.errorf(path+".templateTap.template", "%s", err)
+		}
+		if t.LogFile != "" {
+			ck.checkDir(path+".templateTap.logFile", filepath.Dir(t.LogFile))
+		}
+	}
+	if b := tcfg.RequestIn; b != nil {
+		ck.checkPatch(path+".requestIn.bodyPatch", b.BodyPatch)
+		ck.checkDir(path+".requestIn.evidenceDir", b.EvidenceDir)
+		ck.checkDir(path+".requestIn.spillDir", b.SpillDir)
+	}
+	if b := tcfg.Response; b != nil {
+		ck.checkPatch(path+".response.bodyPatch", b.BodyPatch)
+		ck.checkDir(path+".response.spillDir", b.SpillDir)
+	}
+	if m := tcfg.RequestOut; m != nil {
+		ck.checkPatch(path+".requestOut.bodyPatch", m.BodyPatch)
+	}
+	if m := tcfg.ResponseOut; m != nil {
+		ck.checkPatch(path+".responseOut.bodyPatch", m.BodyPatch)
+	}
+	ck.checkMasks(path+".header.mask", tcfg.Header.Mask)
+}
+
+func (ck *checker) checkMasks(path string, masks []config.HeaderMask) {
+	for i, m := range masks {
+		switch httptap.HeaderMaskMode(m.Mode) {
+		case "", httptap.MaskDrop, httptap.MaskStars, httptap.MaskLast, httptap.MaskHash:
+		default:
+			ck.errorf(fmt.Sprintf("%s[%d].mode", path, i), "unknown mode %q, expected %s, %s, %s or %s",
+				m.Mode, httptap.MaskDrop, httptap.MaskStars, httptap.MaskLast, httptap.MaskHash)
+		}
+	}
+}
+
+// checkPatch checks the operations of a JSON patch, decoding the patch
+// does not check them.
+func (ck *checker) checkPatch(path string, ops []config.Operation) {
+	for i, op := range ops {
+		p := fmt.Sprintf("%s[%d]", path, i)
+		switch op.Op {
+		case "add", "remove", "replace", "test":
+		case "move", "copy":
+			if op.From == "" {
+				ck.errorf(p+".from", "%s needs from", op.Op)
+			}
+		default:
+			ck.errorf(p+".op", "unknown operation %q", op.Op)
+			continue
+		}
+		if op.Path != "" && !strings.HasPrefix(op.Path, "/") {
+			ck.errorf(p+".path", "%q is not a JSON pointer", op.Path)
+		}
+	}
+}
+
+// checkDir checks that dir, when set, is a directory.
+func (ck *checker) checkDir(path, dir string) {
+	if dir == "" {
+		return
+	}
+	fi, err := os.Stat(dir)
+	switch {
+	case err != nil:
+		ck.errorf(path, "%s", err)
+	case !fi.IsDir():
+		ck.errorf(path, "%s is not a directory", dir)
+	}
+}
+
+// optionPaths are the paths in the tap config of the options.
+var optionPaths = map[string]string{
+	"request body patch":  "requestIn.bodyPatch",
+	"response body patch": "response.bodyPatch",
+	"request mutation":    "requestOut",
+	"response mutation":   "responseOut",
+	"redaction":           "redact.rules",
+	"pii detectors":       "pii.detectors",
+	"mock":                "mock",
+	"faults":              "fault",
+	"sample rate":         "sample.rate",
+	"filter":              "filter",
+}
+
+// tapErrors adds the errors of the options of the tap at path, the errors
+// that are not of an option are of the patterns.
+func (ck *checker) tapErrors(path string, err error) {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range joined.Unwrap() {
+			ck.tapErrors(path, err)
+		}
+		return
+	}
+	var fe *fieldError
+	var oe *httptap.OptionError
+	switch {
+	case errors.As(err, &fe):
+		ck.errorf(path+"."+fe.path, "%s", fe.err)
+	case errors.As(err, &oe):
+		if op, ok := optionPaths[oe.Option]; ok {
+			ck.errorf(path+"."+op, "%s", oe.Err)
+		} else {
+			ck.errorf(path, "%s", oe)
+		}
+	default:
+		ck.errorf(path+".patterns", "%s", err)
+	}
+}
+
+// checkPattern checks the syntax of a ServeMux pattern.
+func checkPattern(pattern string) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("%v", v)
+		}
+	}()
+	http.NewServeMux().Handle(pattern, http.NotFoundHandler())
+	return nil
+}
+
+// checker collects the diagnostics.
+type checker struct {
+	diags []config.Diagnostic
+}
+
+func (ck *checker) add(sev config.Severity, path, format string, args ...any) {
+	ck.diags = append(ck.diags, config.Diagnostic{
+		Severity: sev,
+		Path:     path,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (ck *checker) errorf(path, format string, args ...any) {
+	ck.add(config.SeverityError, path, format, args...)
+}
+
+func (ck *checker) warnf(path, format string, args ...any) {
+	ck.add(config.SeverityWarning, path, format, args...)
+}
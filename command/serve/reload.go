@@ -0,0 +1,221 @@
+package serve
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/myhops/httptap"
+	"github.com/myhops/httptap/config"
+)
+
+// instance is a proxy with its taps. A reload creates a new instance,
+// the old one finishes its requests and stops.
+type instance struct {
+	proxy *httptap.Proxy
+	// shutdowns stop the taps that work in the background.
+	shutdowns []func(context.Context) error
+	// inflight is the number of requests the proxy is serving.
+	inflight atomic.Int64
+	// slow is set when taps emulate a slow network or inject latency,
+	// the write timeout of the server would cut their responses off.
+	slow bool
+}
+
+// newInstance creates the proxy and the taps of cfg. A config with errors,
+// the error diagnostics of Check, is rejected.
+func (c *ServeCmd) newInstance(cfg *config.TapHandler) (_ *instance, err error) {
+	if err := c.validate(cfg); err != nil {
+		return nil, err
+	}
+	p, err := httptap.New(c.Upstream.String(), c.getProxyOptions(cfg)...)
+	if err != nil {
+		return nil, err
+	}
+	inst := &instance{proxy: p, slow: slowTaps(cfg)}
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("invalid tap config: %v", v)
+		}
+		if err != nil {
+			// Stop the taps that are already created.
+			inst.shutdown(context.Background())
+		}
+	}()
+	if err := c.addTaps(inst, cfg); err != nil {
+		return nil, err
+	}
+	return inst, nil
+}
+
+// shutdown serves the queued records and stops the taps.
+func (i *instance) shutdown(ctx context.Context) error {
+	err := i.proxy.Flush(ctx)
+	for _, shutdown := range i.shutdowns {
+		err = errors.Join(err, shutdown(ctx))
+	}
+	return err
+}
+
+// serveHTTP serves the request with the current proxy.
+func (c *ServeCmd) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	var inst *instance
+	for {
+		inst = c.current.Load()
+		inst.inflight.Add(1)
+		// The proxy was not replaced before the request was counted,
+		// retire waits for it.
+		if c.current.Load() == inst {
+			break
+		}
+		inst.inflight.Add(-1)
+	}
+	defer inst.inflight.Add(-1)
+	if inst.slow {
+		// Clear the write deadline of the server.
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	}
+	inst.proxy.ServeHTTP(w, r)
+}
+
+// slowTaps reports if a tap of cfg emulates network conditions or injects latency.
+func slowTaps(cfg *config.TapHandler) bool {
+	if cfg == nil {
+		return false
+	}
+	for _, tcfg := range cfg.Taps {
+		if tcfg != nil && (tcfg.Network != nil || (tcfg.Fault != nil && tcfg.Fault.Latency != nil)) {
+			return true
+		}
+	}
+	return false
+}
+
+// retire waits until the replaced proxy has served its requests, then stops it.
+func (c *ServeCmd) retire(inst *instance) {
+	defer c.retiring.Done()
+	logger := c.GlobalCmd.Logger.With(slog.String("step", "retire"))
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for inst.inflight.Load() > 0 && drainCtx.Err() == nil {
+		select {
+		case <-drainCtx.Done():
+		case <-ticker.C:
+		}
+	}
+	if n := inst.inflight.Load(); n > 0 {
+		logger.Warn("old proxy still serving requests", slog.Int64("inflight", n))
+	}
+
+	ctx, cancel := context.WithTimeoutCause(context.Background(), 10*time.Second, ErrShutdownTimeout)
+	defer cancel()
+	if err := inst.shutdown(ctx); err != nil {
+		logger.Error("old proxy shutdown returned with error", slog.String("err", err.Error()))
+		return
+	}
+	logger.Info("old proxy stopped")
+}
+
+// reload loads the config file and replaces the proxy. A bad config is
+// logged and the current proxy keeps serving.
+func (c *ServeCmd) reload(file string) error {
+	logger := c.GlobalCmd.Logger.With(slog.String("step", "reload"), slog.String("file", file))
+	cfg, err := config.LoadTapHandler(file)
+	if err == nil {
+		var inst *instance
+		if inst, err = c.newInstance(cfg); err == nil {
+			old := c.current.Swap(inst)
+			c.retiring.Add(1)
+			go c.retire(old)
+			logger.Info("config reloaded", slog.Int("taps", len(cfg.Taps)))
+			return nil
+		}
+	}
+	logger.Error("config rejected, keeping the current one", slog.String("err", err.Error()))
+	return err
+}
+
+// fileVersion identifies the content of the config file.
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+	sum     [sha256.Size]byte
+}
+
+// changed reports if the file changed since v, and returns the new version.
+// The content is compared, so touching the file does not reload it.
+func (v fileVersion) changed(file string) (fileVersion, bool, error) {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return v, false, err
+	}
+	if fi.ModTime().Equal(v.modTime) && fi.Size() == v.size {
+		return v, false, nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return v, false, err
+	}
+	nv := fileVersion{modTime: fi.ModTime(), size: fi.Size(), sum: sha256.Sum256(b)}
+	return nv, !bytes.Equal(nv.sum[:], v.sum[:]), nil
+}
+
+// watch reloads the config file when it changes or on SIGHUP, until ctx is done.
+func (c *ServeCmd) watch(ctx context.Context) {
+	logger := c.GlobalCmd.Logger.With(slog.String("step", "watch"))
+	var file string
+	if c.TapHandlerConfig != nil {
+		file = c.TapHandlerConfig.File
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	var version fileVersion
+	if file != "" && c.WatchInterval > 0 {
+		version, _, _ = version.changed(file)
+		ticker := time.NewTicker(c.WatchInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+		logger.Info("watching config file", slog.String("file", file), slog.Duration("interval", c.WatchInterval))
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if file == "" {
+				logger.Warn("SIGHUP ignored, no config file")
+				continue
+			}
+			logger.Info("SIGHUP received")
+			version, _, _ = version.changed(file)
+			c.reload(file)
+		case <-tick:
+			nv, changed, err := version.changed(file)
+			if err != nil {
+				logger.Error("cannot check config file", slog.String("err", err.Error()))
+				continue
+			}
+			version = nv
+			if changed {
+				c.reload(file)
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package serve
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/myhops/httptap/command"
+	"github.com/myhops/httptap/config"
+)
+
+const reloadConfig = `
+taps:
+  - name: mock
+    patterns: ["GET /"]
+    filter: %s
+    mock:
+      body: %s
+    fault:
+      latency:
+        percentage: 100
+        delay: %s
+`
+
+// writeConfig replaces the config file at once, the watcher must not read
+// a file that is only partly written.
+func writeConfig(t *testing.T, file, data string) {
+	t.Helper()
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, []byte(data), 0o600); err != nil {
+		t.Fatalf("write error: %s", err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		t.Fatalf("rename error: %s", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "taps.yaml")
+	write := func(filter, body, delay string) {
+		writeConfig(t, file, fmt.Sprintf(reloadConfig, filter, body, delay))
+	}
+	// The first config answers slowly.
+	write("status == 200", "v1", "300ms")
+	cfg, err := config.LoadTapHandler(file)
+	if err != nil {
+		t.Fatalf("load error: %s", err)
+	}
+
+	c := &ServeCmd{
+		GlobalCmd:        &command.GlobalCmd{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))},
+		TapHandlerConfig: cfg,
+		Upstream:         mustURL("http://localhost:1"),
+		WatchInterval:    10 * time.Millisecond,
+	}
+	inst, err := c.newInstance(cfg)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	c.current.Store(inst)
+	ps := httptest.NewServer(http.HandlerFunc(c.serveHTTP))
+	defer ps.Close()
+
+	get := func() string {
+		resp, err := http.Get(ps.URL)
+		if err != nil {
+			t.Errorf("get error: %s", err)
+			return ""
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return string(b)
+	}
+	// eventually waits until the proxy answers with want.
+	eventually := func(want string) {
+		t.Helper()
+		for range 100 {
+			if get() == want {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("proxy does not answer %s", want)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	watched := make(chan struct{})
+	go func() {
+		c.watch(ctx)
+		close(watched)
+	}()
+
+	// A request that is in flight during the reload is served by the old proxy.
+	slow := make(chan string)
+	go func() { slow <- get() }()
+	time.Sleep(100 * time.Millisecond)
+	write("status == 200", "v2", "0s")
+	eventually("v2")
+	if got := <-slow; got != "v1" {
+		t.Errorf("in-flight request got %q", got)
+	}
+
+	// Bad configs are rejected, the current proxy keeps serving.
+	write("status >", "v3", "0s")
+	time.Sleep(50 * time.Millisecond)
+	if got := get(); got != "v2" {
+		t.Errorf("after bad filter got %q", got)
+	}
+	if err := c.reload(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Errorf("missing file not rejected")
+	}
+	writeConfig(t, file, "taps:\n  - name: bad\n    patterns: [\"GET\"]\n    mock:\n      body: v4\n")
+	time.Sleep(50 * time.Millisecond)
+	if got := get(); got != "v2" {
+		t.Errorf("after bad pattern got %q", got)
+	}
+
+	// A missing mock body file rejects the config instead of dropping the mock.
+	writeConfig(t, file, "taps:\n  - name: bad\n    patterns: [\"GET /\"]\n    mock:\n      bodyFile: /nonexistent\n")
+	time.Sleep(50 * time.Millisecond)
+	if got := get(); got != "v2" {
+		t.Errorf("after missing body file got %q", got)
+	}
+
+	// A misspelled key is not ignored, the tap would run without it.
+	writeConfig(t, file, "taps:\n  - name: bad\n    patterns: [\"GET /\"]\n    mock:\n      body: v4\n    redakt:\n      rules: []\n")
+	time.Sleep(50 * time.Millisecond)
+	if got := get(); got != "v2" {
+		t.Errorf("after misspelled key got %q", got)
+	}
+
+	write("status == 200", "v5", "0s")
+	eventually("v5")
+
+	cancel()
+	<-watched
+	c.retiring.Wait()
+}
+
+func TestWriteTimeoutWithLatency(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "taps.yaml")
+	if err := os.WriteFile(file, []byte(fmt.Sprintf(reloadConfig, "status == 200", "slow", "300ms")), 0o600); err != nil {
+		t.Fatalf("write error: %s", err)
+	}
+	cfg, err := config.LoadTapHandler(file)
+	if err != nil {
+		t.Fatalf("load error: %s", err)
+	}
+	c := &ServeCmd{
+		GlobalCmd: &command.GlobalCmd{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))},
+		Upstream:  mustURL("http://localhost:1"),
+	}
+	inst, err := c.newInstance(cfg)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	c.current.Store(inst)
+
+	// The latency is longer than the write timeout.
+	ps := httptest.NewUnstartedServer(http.HandlerFunc(c.serveHTTP))
+	ps.Config.WriteTimeout = 100 * time.Millisecond
+	ps.Start()
+	defer ps.Close()
+	resp, err := http.Get(ps.URL)
+	if err != nil {
+		t.Fatalf("get error: %s", err)
+	}
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(b) != "slow" {
+		t.Errorf("got %q, err %v", b, err)
+	}
+	inst.shutdown(context.Background())
+}
@@ -9,12 +9,16 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/myhops/httptap"
 	"github.com/myhops/httptap/command"
 	"github.com/myhops/httptap/command/values"
 	"github.com/myhops/httptap/config"
+	"github.com/myhops/httptap/metrics"
 	"github.com/myhops/httptap/tap"
 )
 
@@ -32,6 +36,23 @@ type ServeCmd struct {
 
 	Address  string
 	Upstream *url.URL
+
+	// WatchInterval is the interval of checking the config file for changes,
+	// 0 disables watching.
+	WatchInterval time.Duration
+	// WriteTimeout limits the time to write a response, 0 disables it.
+	// The responses of a config with network conditions or latency faults
+	// have no write timeout, they take as long as the emulation takes.
+	WriteTimeout time.Duration
+
+	// registry contains the metrics, nil without admin listener.
+	registry *metrics.Registry
+	// adminToken is the bearer token the admin changes require, empty without token.
+	adminToken string
+	// current is the proxy that serves the requests, it is replaced on reload.
+	current atomic.Pointer[instance]
+	// retiring counts the replaced proxies that are still serving.
+	retiring sync.WaitGroup
 }
 
 func NewServeCmd(global *command.GlobalCmd) *ServeCmd {
@@ -54,6 +75,8 @@ func (c *ServeCmd) Flags(fs *values.FlagSet) {
 	fs.URLVar(&c.Upstream, "upstream", mustURL("http://localhost:18080"), "upstream service")
 	fs.TapHandlerVar(&c.TapHandlerConfig, "tap-config-file", nil, "Tap handlers config file")
 	fs.StringVar(&c.Address, "address", ":8080", "listen address")
+	fs.DurationVar(&c.WatchInterval, "watch-interval", 2*time.Second, "interval of checking the tap config file for changes, 0 disables reloading on change")
+	fs.DurationVar(&c.WriteTimeout, "write-timeout", 10*time.Second, "time to write a response, 0 disables it, not applied with network conditions or latency faults")
 }
 
 func (c *ServeCmd) createLogTap() (httptap.Tap, error) {
@@ -61,43 +84,126 @@ func (c *ServeCmd) createLogTap() (httptap.Tap, error) {
 	return tt, nil
 }
 
-func (c *ServeCmd) createTap(tcfg *config.Tap) (httptap.Tap, error) {
-	var tt httptap.Tap
+func (c *ServeCmd) createOTelTap(cfg *config.OTelTap) (httptap.Tap, error) {
+	tt, err := tap.NewOTelTap(c.GlobalCmd.Logger, tap.OTelTapConfig{
+		Endpoint:      cfg.Endpoint,
+		ServiceName:   cfg.ServiceName,
+		Headers:       cfg.Headers,
+		BatchSize:     cfg.BatchSize,
+		FlushInterval: cfg.FlushInterval,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tt, nil
+}
+
+// shutdowner is a tap that works in the background and must be stopped.
+type shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// createTap returns the taps of the config, combined in one.
+// The taps that must be stopped are added to the instance.
+func (c *ServeCmd) createTap(inst *instance, tcfg *config.Tap) (httptap.Tap, error) {
+	var taps []httptap.Tap
 	if tcfg.LogTap != nil {
 		d, err := c.createLogTap()
 		if err != nil {
 			return nil, err
 		}
-		tt = d
+		taps = append(taps, d)
 	}
-	return tt, nil
+	if tcfg.OTelTap != nil {
+		d, err := c.createOTelTap(tcfg.OTelTap)
+		if err != nil {
+			return nil, err
+		}
+		taps = append(taps, d)
+	}
+	for _, t := range taps {
+		if sd, ok := t.(shutdowner); ok {
+			inst.shutdowns = append(inst.shutdowns, sd.Shutdown)
+		}
+	}
+	switch len(taps) {
+	case 0:
+		return nil, nil
+	case 1:
+		return taps[0], nil
+	}
+	return tap.NewMultiTap(taps), nil
 }
 
-func (c *ServeCmd) addTaps(p *httptap.Proxy) error {
+func (c *ServeCmd) addTaps(inst *instance, cfg *config.TapHandler) error {
 	logger := c.GlobalCmd.Logger.With(slog.String("step", "addTaps"))
-	if c.TapHandlerConfig == nil {
+	if cfg == nil {
 		logger.Info("no tap handler configured")
 		return ErrNoTapDefined
 	}
-	if len(c.TapHandlerConfig.Taps) == 0 {
+	if len(cfg.Taps) == 0 {
 		logger.Info("no taps found")
 	}
+	p := inst.proxy
 	// Add the taps
-	for _, tcfg := range c.TapHandlerConfig.Taps {
+	for _, tcfg := range cfg.Taps {
 		logger.Info("adding tap", slog.String("name", tcfg.Name))
-		t, err := c.createTap(tcfg)
-		if err != nil || t == nil {
+		t, err := c.createTap(inst, tcfg)
+		if err != nil {
 			return err
 		}
+		if t == nil && tcfg.Mock != nil {
+			// A mock without a tap.
+			t = httptap.TapFunc(func(context.Context, *httptap.RequestResponse) {})
+		}
+		if t == nil {
+			logger.Warn("tap has no logTap, otelTap or mock, not added", slog.String("name", tcfg.Name))
+			continue
+		}
 		logger.Info("adding tap to pattern", slog.Any("pattern", tcfg.Patterns))
-		p.Tap(tcfg.Patterns, t, c.getTapOptions(tcfg)...)
+		opts, err := c.getTapOptions(tcfg)
+		if err != nil {
+			return fmt.Errorf("tap %q: %w", tcfg.Name, err)
+		}
+		if err := p.AddTap(tcfg.Patterns, t, opts...); err != nil {
+			return fmt.Errorf("tap %q: %w", tcfg.Name, err)
+		}
 	}
 	return nil
 }
 
-func (c *ServeCmd) getTapOptions(tcfg *config.Tap) httptap.TapOptions {
+// validate checks the tap configuration like the validate command does,
+// the error diagnostics reject it.
+func (c *ServeCmd) validate(cfg *config.TapHandler) error {
+	var errs []error
+	for _, d := range c.Check(cfg) {
+		if d.Severity == config.SeverityError {
+			errs = append(errs, fmt.Errorf("%s: %s", d.Path, d.Message))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// fieldError is an error in the value of path in a tap config.
+type fieldError struct {
+	path string
+	err  error
+}
+
+func (e *fieldError) Error() string {
+	return e.path + ": " + e.err.Error()
+}
+
+func (e *fieldError) Unwrap() error {
+	return e.err
+}
+
+// getTapOptions returns the options of the tap, the error joins the
+// *fieldError of the values that cannot be turned into options.
+func (c *ServeCmd) getTapOptions(tcfg *config.Tap) (httptap.TapOptions, error) {
 	logger := c.GlobalCmd.Logger.With(slog.String("step", "getTapOptions"))
-	var opts httptap.TapOptions
+	opts := httptap.TapOptions{httptap.WithTapName(tcfg.Name)}
+	var errs []error
 	if o := tcfg.Header.Exclude; len(o) > 0 {
 		logger.Info("exclude headers", slog.Any("headers", o))
 		opts = append(opts, httptap.WithExcludeHeaders(o))
@@ -106,6 +212,10 @@ func (c *ServeCmd) getTapOptions(tcfg *config.Tap) httptap.TapOptions {
 		logger.Info("include headers", slog.Any("headers", o))
 		opts = append(opts, httptap.WithIncludeHeaders(o))
 	}
+	if o := tcfg.Header.Mask; len(o) > 0 {
+		logger.Info("mask headers", slog.Any("masks", o))
+		opts = append(opts, httptap.WithHeaderMasks(headerMasks(o)...))
+	}
 	mustMarshal := func(obj any) []byte {
 		var res []byte
 		res, err := json.Marshal(obj)
@@ -114,24 +224,240 @@ func (c *ServeCmd) getTapOptions(tcfg *config.Tap) httptap.TapOptions {
 		}
 		return res
 	}
+	if tcfg.RequestOut != nil {
+		logger.Info("adding request mutation")
+		if m, err := mutation(tcfg.RequestOut); err != nil {
+			errs = append(errs, &fieldError{"requestOut", err})
+		} else {
+			opts = append(opts, httptap.WithRequestMutation(m))
+		}
+	}
+	if tcfg.ResponseOut != nil {
+		logger.Info("adding response mutation")
+		if m, err := mutation(tcfg.ResponseOut); err != nil {
+			errs = append(errs, &fieldError{"responseOut", err})
+		} else {
+			opts = append(opts, httptap.WithResponseMutation(m))
+		}
+	}
 	if tcfg.RequestIn != nil {
 		logger.Info("setting request body in")
 		opts = append(opts, httptap.WithRequestBody(tcfg.RequestIn.Body))
 		opts = append(opts, httptap.WithRequestJSON(tcfg.RequestIn.BodyJSON))
+		opts = append(opts, httptap.WithRequestDecoded(tcfg.RequestIn.BodyDecoded))
+		opts = append(opts, httptap.WithRequestMultipart(tcfg.RequestIn.Multipart))
+		if d := tcfg.RequestIn.EvidenceDir; d != "" {
+			opts = append(opts, httptap.WithEvidenceDir(d))
+		}
 		if tcfg.RequestIn.BodyPatch != nil {
 			logger.Info("addding response body patch")
 			opts = append(opts, httptap.WithRequestBodyPatch(mustMarshal(tcfg.RequestIn.BodyPatch)))
 		}
-		
+		if n := tcfg.RequestIn.MaxBodyBytes; n > 0 {
+			opts = append(opts, httptap.WithMaxRequestBodyBytes(n))
+		}
+		if n := tcfg.RequestIn.SpillThreshold; n > 0 {
+			opts = append(opts, httptap.WithRequestBodySpill(n, tcfg.RequestIn.SpillDir))
+		}
+
+	}
+	if r := tcfg.Redact; r != nil {
+		logger.Info("adding redaction", slog.Int("rules", len(r.Rules)))
+		if r.KeyEnv != "" {
+			// An unset key would silently give tokens that can be reversed.
+			if key := os.Getenv(r.KeyEnv); key == "" {
+				errs = append(errs, &fieldError{"redact.keyEnv", fmt.Errorf("%s is not set or empty", r.KeyEnv)})
+			} else {
+				opts = append(opts, httptap.WithRedactionKey([]byte(key)))
+			}
+		}
+		rules := make([]httptap.RedactRule, 0, len(r.Rules))
+		for _, rule := range r.Rules {
+			rules = append(rules, httptap.RedactRule{
+				Path:   rule.Path,
+				Action: httptap.RedactAction(rule.Action),
+			})
+		}
+		opts = append(opts, httptap.WithRedaction(rules...))
+	}
+	if p := tcfg.PII; p != nil {
+		logger.Info("adding pii detectors", slog.Any("detectors", p.Detectors))
+		opts = append(opts, httptap.WithPIIDetectors(httptap.PIIAction(p.Action), p.Detectors...))
+	}
+	if m := tcfg.Mock; m != nil {
+		logger.Info("adding mock", slog.Int("status", m.Status))
+		if mm, err := mock(m); err != nil {
+			errs = append(errs, &fieldError{"mock.bodyFile", err})
+		} else {
+			opts = append(opts, httptap.WithMock(mm))
+		}
+	}
+	if f := tcfg.Fault; f != nil {
+		logger.Info("adding faults")
+		opts = append(opts, httptap.WithFaults(faults(f)))
+	}
+	if n := tcfg.Network; n != nil {
+		logger.Info("emulating network",
+			slog.Int64("upload", n.UploadBytesPerSecond),
+			slog.Int64("download", n.DownloadBytesPerSecond),
+		)
+		opts = append(opts, httptap.WithNetworkConditions(httptap.NetworkConditions{
+			UploadBytesPerSecond:   n.UploadBytesPerSecond,
+			DownloadBytesPerSecond: n.DownloadBytesPerSecond,
+			Jitter:                 n.Jitter,
+			ChunkSize:              n.ChunkSize,
+		}))
+	}
+	if f := tcfg.Filter; f != "" {
+		logger.Info("adding filter", slog.String("filter", f))
+		opts = append(opts, httptap.WithFilter(f))
+	}
+	if sc := tcfg.Sample; sc != nil {
+		logger.Info("sampling", slog.Float64("rate", sc.Rate))
+		opts = append(opts, httptap.WithSampleRate(sc.Rate))
+		if sc.KeepErrors || sc.KeepSlowerThan > 0 {
+			opts = append(opts, httptap.WithSampleKeep(sc.KeepErrors, sc.KeepSlowerThan))
+		}
 	}
 	if tcfg.Response != nil {
 		logger.Info("setting request body out")
 		opts = append(opts, httptap.WithResponseBody(tcfg.Response.Body))
 		opts = append(opts, httptap.WithResponseJSON(tcfg.Response.BodyJSON))
+		opts = append(opts, httptap.WithResponseDecoded(tcfg.Response.BodyDecoded))
 		if tcfg.Response.BodyPatch != nil {
 			logger.Info("addding response body patch")
 			opts = append(opts, httptap.WithResponseBodyPatch(mustMarshal(tcfg.Response.BodyPatch)))
 		}
+		if n := tcfg.Response.MaxBodyBytes; n > 0 {
+			opts = append(opts, httptap.WithMaxResponseBodyBytes(n))
+		}
+		if n := tcfg.Response.SpillThreshold; n > 0 {
+			opts = append(opts, httptap.WithResponseBodySpill(n, tcfg.Response.SpillDir))
+		}
+	}
+	return opts, errors.Join(errs...)
+}
+
+func mutation(m *config.Mutation) (httptap.Mutation, error) {
+	var res httptap.Mutation
+	var err error
+	if len(m.BodyPatch) > 0 {
+		if res.BodyPatch, err = json.Marshal(m.BodyPatch); err != nil {
+			return res, fmt.Errorf("error marshalling body patch: %w", err)
+		}
+	}
+	if m.BodyMergePatch != nil {
+		if res.BodyMergePatch, err = json.Marshal(m.BodyMergePatch); err != nil {
+			return res, fmt.Errorf("error marshalling body merge patch: %w", err)
+		}
+	}
+	if hm := m.Header; hm != nil {
+		res.AddHeader = http.Header{}
+		res.SetHeader = http.Header{}
+		for k, v := range hm.Add {
+			res.AddHeader.Add(k, v)
+		}
+		for k, v := range hm.Set {
+			res.SetHeader.Set(k, v)
+		}
+		res.RemoveHeader = hm.Remove
+	}
+	return res, nil
+}
+
+func mock(m *config.Mock) (httptap.Mock, error) {
+	res := httptap.Mock{
+		StatusCode: m.Status,
+		Body:       []byte(m.Body),
+		Template:   m.Template,
+	}
+	if m.BodyFile != "" {
+		b, err := os.ReadFile(m.BodyFile)
+		if err != nil {
+			return res, fmt.Errorf("error reading mock body: %w", err)
+		}
+		res.Body = b
+	}
+	if len(m.Headers) > 0 {
+		res.Header = http.Header{}
+		for k, v := range m.Headers {
+			res.Header.Set(k, v)
+		}
+	}
+	return res, nil
+}
+
+func faults(f *config.Fault) httptap.Faults {
+	var res httptap.Faults
+	if l := f.Latency; l != nil {
+		res.Latency = &httptap.LatencyFault{
+			Percentage:   l.Percentage,
+			Distribution: httptap.LatencyDistribution(l.Distribution),
+			Delay:        l.Delay,
+			Max:          l.Max,
+			StdDev:       l.StdDev,
+		}
+	}
+	if e := f.Error; e != nil {
+		res.Error = &httptap.ErrorFault{
+			Percentage:  e.Percentage,
+			StatusCode:  e.Status,
+			ContentType: e.ContentType,
+			Body:        []byte(e.Body),
+		}
+	}
+	if a := f.Abort; a != nil {
+		res.Abort = &httptap.AbortFault{Percentage: a.Percentage}
+	}
+	if t := f.Truncate; t != nil {
+		res.Truncate = &httptap.TruncateFault{Percentage: t.Percentage, Bytes: t.Bytes}
+	}
+	return res
+}
+
+func headerMasks(masks []config.HeaderMask) []httptap.HeaderMask {
+	res := make([]httptap.HeaderMask, 0, len(masks))
+	for _, m := range masks {
+		res = append(res, httptap.HeaderMask{
+			Pattern: m.Name,
+			Mode:    httptap.HeaderMaskMode(m.Mode),
+			Keep:    m.Keep,
+		})
+	}
+	return res
+}
+
+func (c *ServeCmd) getProxyOptions(cfg *config.TapHandler) httptap.ProxyOptions {
+	opts := httptap.ProxyOptions{
+		httptap.WithLogger(c.GlobalCmd.Logger),
+	}
+	if c.registry != nil {
+		opts = append(opts, httptap.WithMetrics(c.registry))
+	}
+	if cfg == nil {
+		return opts
+	}
+	hdr := cfg.Header
+	if len(hdr.Include) > 0 {
+		opts = append(opts, httptap.WithGlobalIncludeHeaders(hdr.Include))
+	}
+	if len(hdr.Exclude) > 0 {
+		opts = append(opts, httptap.WithGlobalExcludeHeaders(hdr.Exclude))
+	}
+	if len(hdr.Mask) > 0 {
+		opts = append(opts, httptap.WithGlobalHeaderMasks(headerMasks(hdr.Mask)...))
+	}
+	if e := cfg.ErrorResponse; e != nil {
+		opts = append(opts, httptap.WithErrorResponse(e.ContentType, []byte(e.Body)))
+	}
+	if id := cfg.RequestID; id != nil {
+		opts = append(opts, httptap.WithRequestID(id.Header, httptap.RequestIDFormat(id.Format)))
+	}
+	if cfg.TraceContext {
+		opts = append(opts, httptap.WithTraceContext())
+	}
+	if d := cfg.Dispatch; d != nil {
+		opts = append(opts, httptap.WithAsyncDispatch(d.QueueSize, d.Workers, httptap.DropPolicy(d.Policy)))
 	}
 	return opts
 }
@@ -139,26 +465,34 @@ func (c *ServeCmd) getTapOptions(tcfg *config.Tap) httptap.TapOptions {
 func (c *ServeCmd) Run(ctx context.Context) error {
 	logger := c.GlobalCmd.Logger
 	logger.Debug("debug enabled")
-	// Create the proxy.
-	p, err := httptap.New(c.Upstream.String(), httptap.WithLogger(c.GlobalCmd.Logger))
+	// Start the admin listener before the proxy, it creates the registry.
+	admin, err := c.startAdmin(ctx)
 	if err != nil {
 		return err
 	}
+	// Create the proxy with the taps, a config with errors is rejected.
+	inst, err := c.newInstance(c.TapHandlerConfig)
+	if err != nil {
+		if admin != nil {
+			admin.Close()
+		}
+		return err
+	}
+	c.current.Store(inst)
 
-	// Add the taps.
-	c.addTaps(p)
-
-	// Create the server.
+	// Create the server, it serves with the current proxy.
 	srv := &http.Server{
-		Handler:           p,
+		Handler:           http.HandlerFunc(c.serveHTTP),
 		Addr:              c.Address,
 		BaseContext:       func(_ net.Listener) context.Context { return ctx },
 		ReadHeaderTimeout: 5 * time.Second,
-		WriteTimeout:      10 * time.Second,
+		WriteTimeout:      c.WriteTimeout,
 	}
 	logger.Info("starting server", slog.String("address", c.Address))
 	go srv.ListenAndServe()
-	<-ctx.Done()
+
+	// Replace the proxy when the config file changes.
+	c.watch(ctx)
 	logger.Info("Ctx Done")
 
 	logger.Info("shutting down server")
@@ -167,6 +501,20 @@ func (c *ServeCmd) Run(ctx context.Context) error {
 	err = srv.Shutdown(shutdownCtx)
 	if err != nil {
 		logger.Error("shutdown return with error", slog.String("err", err.Error()))
+	}
+	// Serve the queued records, also when the shutdown failed.
+	if serr := c.current.Load().shutdown(shutdownCtx); serr != nil {
+		logger.Error("proxy shutdown returned with error", slog.String("err", serr.Error()))
+		err = errors.Join(err, serr)
+	}
+	c.retiring.Wait()
+	if admin != nil {
+		if aerr := admin.Shutdown(shutdownCtx); aerr != nil {
+			logger.Error("admin shutdown returned with error", slog.String("err", aerr.Error()))
+			err = errors.Join(err, aerr)
+		}
+	}
+	if err != nil {
 		return err
 	}
 	logger.Info("server shut down")
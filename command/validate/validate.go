@@ -0,0 +1,100 @@
+// Package validate implements the command that checks tap config files
+// before they are served.
+package validate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/myhops/httptap/command"
+	"github.com/myhops/httptap/command/serve"
+	"github.com/myhops/httptap/command/values"
+	"github.com/myhops/httptap/config"
+)
+
+var (
+	ErrInvalidConfig = errors.New("invalid tap config")
+	ErrNoFiles       = errors.New("no config files")
+)
+
+type ValidateCmd struct {
+	GlobalCmd *command.GlobalCmd
+
+	// Files are the tap config files.
+	Files []string
+	// Out receives the diagnostics, stdout when nil.
+	Out io.Writer
+}
+
+func NewValidateCmd(global *command.GlobalCmd) *ValidateCmd {
+	return &ValidateCmd{GlobalCmd: global}
+}
+
+func (c *ValidateCmd) Flags(fs *values.FlagSet) {}
+
+// SetArgs sets the files to validate.
+func (c *ValidateCmd) SetArgs(args []string) error {
+	c.Files = args
+	return nil
+}
+
+// Run checks the files and writes the diagnostics like file:line:column: severity: path: message.
+// It returns ErrInvalidConfig when a file has errors, warnings do not fail.
+func (c *ValidateCmd) Run(ctx context.Context) error {
+	if len(c.Files) == 0 {
+		return ErrNoFiles
+	}
+	out := c.Out
+	if out == nil {
+		out = os.Stdout
+	}
+	var errs int
+	for _, file := range c.Files {
+		diags := c.check(file)
+		for _, d := range diags {
+			fmt.Fprintf(out, "%s:%s\n", file, d)
+			if d.Severity == config.SeverityError {
+				errs++
+			}
+		}
+		if len(diags) == 0 {
+			fmt.Fprintf(out, "%s: ok\n", file)
+		}
+	}
+	if errs > 0 {
+		return fmt.Errorf("%w: %d errors", ErrInvalidConfig, errs)
+	}
+	return nil
+}
+
+// check decodes the file strictly and checks the config like serve would use it.
+func (c *ValidateCmd) check(file string) []config.Diagnostic {
+	blob, err := os.ReadFile(file)
+	if err != nil {
+		return []config.Diagnostic{{
+			Position: config.Position{Line: 1, Column: 1},
+			Severity: config.SeverityError,
+			Message:  err.Error(),
+		}}
+	}
+	cfg, positions, diags := config.DecodeTapHandler(blob)
+	if cfg == nil {
+		return diags
+	}
+	cfg.File = file
+	for _, d := range serve.NewServeCmd(c.GlobalCmd).Check(cfg) {
+		d.Position = positions.Locate(d.Path)
+		diags = append(diags, d)
+	}
+	sort.SliceStable(diags, func(i, j int) bool {
+		if diags[i].Line != diags[j].Line {
+			return diags[i].Line < diags[j].Line
+		}
+		return diags[i].Column < diags[j].Column
+	})
+	return diags
+}
@@ -0,0 +1,104 @@
+package validate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/myhops/httptap/command"
+)
+
+const badConfig = `dispatch:
+  policy: drop-all
+taps:
+  - name: orders
+    patterns: ["GET /orders/{id}"]
+    logTap: {}
+    requestIn:
+      bodyPatch:
+        - op: frob
+          path: /a
+      spillDir: /nonexistent
+    sample:
+      rate: 2
+  - name: orders
+    patterns: ["GET", "/items/{x}"]
+    logTap: {}
+  - name: conflict
+    patterns: ["GET /orders/{name}"]
+    mock:
+      bodyFile: /nonexistent/body.json
+  - name: template
+    patterns: ["/template"]
+    templateTap:
+      template: "{{.Data"
+`
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	bad := filepath.Join(dir, "bad.yaml")
+	good := filepath.Join(dir, "good.yaml")
+	typo := filepath.Join(dir, "typo.yaml")
+	for file, content := range map[string]string{
+		bad:  badConfig,
+		good: "taps:\n  - name: orders\n    patterns: [\"GET /orders/{id}\"]\n    logTap: {}\n",
+		typo: "taps:\n  - name: orders\n    pattern: [\"GET /orders/{id}\"]\n",
+	} {
+		if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+			t.Fatalf("write error: %s", err)
+		}
+	}
+
+	run := func(files ...string) (string, error) {
+		var out bytes.Buffer
+		c := &ValidateCmd{
+			GlobalCmd: &command.GlobalCmd{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))},
+			Files:     files,
+			Out:       &out,
+		}
+		err := c.Run(context.Background())
+		return out.String(), err
+	}
+
+	out, err := run(good)
+	if err != nil || out != good+": ok\n" {
+		t.Errorf("good config: %q, %v", out, err)
+	}
+
+	out, err = run(bad, typo)
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("got error %v", err)
+	}
+	want := []string{
+		bad + `:2:11: error: dispatch.policy: unknown policy "drop-all"`,
+		bad + `:9:15: error: taps[0].requestIn.bodyPatch[0].op: unknown operation "frob"`,
+		bad + `:11:17: error: taps[0].requestIn.spillDir: stat /nonexistent`,
+		bad + `:13:13: error: taps[0].sample.rate: 2 is not between 0 and 1`,
+		bad + `:14:11: warning: taps[1].name: "orders" is also the name of taps[0]`,
+		bad + `:15:16: error: taps[1].patterns[0]: parsing "GET"`,
+		bad + `:18:15: error: taps[2].patterns: pattern "GET /orders/{name}" conflicts with pattern "GET /orders/{id}": GET /orders/{name} matches the same requests`,
+		bad + `:20:17: error: taps[2].mock.bodyFile: error reading mock body`,
+		bad + `:24:7: warning: taps[3].templateTap: serve does not create template taps`,
+		bad + `:24:17: error: taps[3].templateTap.template: template: tap:1: unclosed action`,
+		typo + `:3:5: error: taps[0].pattern: unknown field "pattern"`,
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("got output\n%s", out)
+	}
+	for i, l := range lines {
+		if !strings.HasPrefix(l, want[i]) {
+			t.Errorf("got %q, want %q", l, want[i])
+		}
+	}
+
+	if _, err := run(); !errors.Is(err, ErrNoFiles) {
+		t.Errorf("got error %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+// Package version implements the command that prints the version of htproxy.
+package version
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+
+	"github.com/myhops/httptap/command"
+	"github.com/myhops/httptap/command/values"
+)
+
+var ErrNoBuildInfo = errors.New("no build info in the binary")
+
+type VersionCmd struct {
+	GlobalCmd *command.GlobalCmd
+
+	// Out receives the version, stdout when nil.
+	Out io.Writer
+}
+
+func NewVersionCmd(global *command.GlobalCmd) *VersionCmd {
+	return &VersionCmd{GlobalCmd: global}
+}
+
+func (c *VersionCmd) Flags(fs *values.FlagSet) {}
+
+// Run prints the module version, the Go version and the commit the binary is built from.
+func (c *VersionCmd) Run(ctx context.Context) error {
+	out := c.Out
+	if out == nil {
+		out = os.Stdout
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ErrNoBuildInfo
+	}
+	fmt.Fprintf(out, "htproxy %s\n", bi.Main.Version)
+	fmt.Fprintf(out, "go      %s\n", bi.GoVersion)
+	settings := map[string]string{}
+	for _, s := range bi.Settings {
+		settings[s.Key] = s.Value
+	}
+	if rev := settings["vcs.revision"]; rev != "" {
+		if settings["vcs.modified"] == "true" {
+			rev += " (modified)"
+		}
+		fmt.Fprintf(out, "commit  %s\n", rev)
+	}
+	if t := settings["vcs.time"]; t != "" {
+		fmt.Fprintf(out, "time    %s\n", t)
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package version
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestVersion(t *testing.T) {
+	var out bytes.Buffer
+	if err := (&VersionCmd{Out: &out}).Run(context.Background()); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	lines := strings.Split(out.String(), "\n")
+	if !strings.HasPrefix(lines[0], "htproxy ") || lines[1] != "go      "+runtime.Version() {
+		t.Errorf("got\n%s", out.String())
+	}
+}
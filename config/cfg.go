@@ -1,10 +1,10 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"os"
-
-	"gopkg.in/yaml.v3"
+	"time"
 )
 
 type TapHandler struct {
@@ -12,19 +12,74 @@ type TapHandler struct {
 	Logging       *Logger              `yaml:"logging"`
 	Upstream      string               `yaml:"upstream"`
 	Header        HeaderIncludeExclude `yaml:"header"`
+	Dispatch      *Dispatch            `yaml:"dispatch,omitempty"`
+	ErrorResponse *ErrorResponse       `yaml:"errorResponse,omitempty"`
+	RequestID     *RequestID           `yaml:"requestId,omitempty"`
+	// TraceContext continues the W3C trace context of the requests.
+	TraceContext bool `yaml:"traceContext,omitempty"`
+	// Admin is the listener of the metrics.
+	Admin *Admin `yaml:"admin,omitempty"`
 
 	Taps []*Tap `yaml:"taps"`
+
+	// File is the file the config is loaded from.
+	File string `yaml:"-"`
+}
+
+// ErrorResponse is the body of the 502 response that is returned
+// when the upstream cannot be reached.
+type ErrorResponse struct {
+	ContentType string `yaml:"contentType"`
+	Body        string `yaml:"body"`
+}
+
+// Admin configures the admin listener, it serves /metrics.
+type Admin struct {
+	// ListenAddress without host, like :9090, listens on the loopback interface.
+	ListenAddress string `yaml:"listenAddress"`
+	// TokenEnv is the environment variable that contains the bearer token
+	// the changes of the admin API require, optional.
+	TokenEnv string `yaml:"tokenEnv,omitempty"`
+}
+
+// RequestID configures the request ids.
+type RequestID struct {
+	// Header is the header of the id, X-Request-Id when empty.
+	Header string `yaml:"header,omitempty"`
+	// Format of the generated ids, uuidv7 or ulid.
+	Format string `yaml:"format,omitempty"`
+}
+
+// Dispatch configures the asynchronous dispatch of records to the taps.
+type Dispatch struct {
+	QueueSize int `yaml:"queueSize"`
+	Workers   int `yaml:"workers"`
+	// Policy is one of drop-oldest, drop-newest or block.
+	Policy string `yaml:"policy"`
 }
 
 type Operation struct {
 	Op    string `json:"op" yaml:"op"`
 	Path  string `json:"path" yaml:"path"`
-	Value string `json:"value,omitempty" yaml:"value"`
+	From  string `json:"from,omitempty" yaml:"from,omitempty"`
+	Value any    `json:"value,omitempty" yaml:"value"`
 }
 
+// HeaderIncludeExclude selects the headers passed to the taps.
+// The names can contain glob patterns, e.g. X-Internal-*.
 type HeaderIncludeExclude struct {
-	Exclude []string `yaml:"exclude"`
-	Include []string `yaml:"include"`
+	Exclude []string     `yaml:"exclude"`
+	Include []string     `yaml:"include"`
+	Mask    []HeaderMask `yaml:"mask,omitempty"`
+}
+
+// HeaderMask masks the values of the matching headers.
+// Mode is one of drop, mask, last or hash.
+type HeaderMask struct {
+	Name string `yaml:"name"`
+	Mode string `yaml:"mode"`
+	// Keep is the number of trailing characters kept in mode last.
+	Keep int `yaml:"keep,omitempty"`
 }
 
 type Logger struct {
@@ -36,6 +91,19 @@ type Body struct {
 	Body      bool        `yaml:"body"`
 	BodyJSON  bool        `yaml:"bodyJSON"`
 	BodyPatch []Operation `yaml:"bodyPatch"`
+	// BodyDecoded decodes the body with the decoder for its Content-Type,
+	// e.g. JSON, NDJSON, XML, YAML or forms.
+	BodyDecoded bool `yaml:"bodyDecoded,omitempty"`
+	// Multipart parses multipart/form-data request bodies, uploaded files are
+	// saved in EvidenceDir when it is set.
+	Multipart   bool   `yaml:"multipart,omitempty"`
+	EvidenceDir string `yaml:"evidenceDir,omitempty"`
+	// MaxBodyBytes limits the captured part of the body, 0 is unlimited.
+	MaxBodyBytes int64 `yaml:"maxBodyBytes,omitempty"`
+	// SpillThreshold is the size above which the captured body is written
+	// to a temp file in SpillDir, 0 keeps all bodies in memory.
+	SpillThreshold int64  `yaml:"spillThreshold,omitempty"`
+	SpillDir       string `yaml:"spillDir,omitempty"`
 }
 
 type Tap struct {
@@ -46,30 +114,167 @@ type Tap struct {
 	// Add more taps when they become available.
 	LogTap      *LogTap      `yaml:"logTap,omitempty"`
 	TemplateTap *TemplateTap `yaml:"templateTap,omitempty"`
+	OTelTap     *OTelTap     `yaml:"otelTap,omitempty"`
+
+	RequestIn *Body `yaml:"requestIn,omitempty"`
+	Response  *Body `yaml:"response,omitempty"`
+
+	// RequestOut changes the request sent to the upstream,
+	// ResponseOut changes the response returned to the client.
+	RequestOut  *Mutation `yaml:"requestOut,omitempty"`
+	ResponseOut *Mutation `yaml:"responseOut,omitempty"`
+
+	Redact *Redact `yaml:"redact,omitempty"`
+	PII    *PII    `yaml:"pii,omitempty"`
+
+	// Mock answers the requests without contacting the upstream.
+	Mock *Mock `yaml:"mock,omitempty"`
+	// Fault injects faults in a percentage of the requests.
+	Fault *Fault `yaml:"fault,omitempty"`
+	// Network emulates a slow link.
+	Network *Network `yaml:"network,omitempty"`
+	// Sample serves a fraction of the requests to the tap.
+	Sample *Sample `yaml:"sample,omitempty"`
+	// Filter is an expression that selects the records for the tap, like
+	// status >= 500 || duration > 2s.
+	Filter string `yaml:"filter,omitempty"`
+}
+
+// Sample keeps a fraction rate, 0 to 1, of the records.
+// Records of failed or slow requests can be kept anyway.
+type Sample struct {
+	Rate           float64       `yaml:"rate"`
+	KeepErrors     bool          `yaml:"keepErrors,omitempty"`
+	KeepSlowerThan time.Duration `yaml:"keepSlowerThan,omitempty"`
+}
+
+// Network limits the bandwidth in bytes per second, adds up to jitter
+// delay to every chunk and limits the chunks to chunkSize bytes.
+type Network struct {
+	UploadBytesPerSecond   int64         `yaml:"uploadBytesPerSecond,omitempty"`
+	DownloadBytesPerSecond int64         `yaml:"downloadBytesPerSecond,omitempty"`
+	Jitter                 time.Duration `yaml:"jitter,omitempty"`
+	ChunkSize              int           `yaml:"chunkSize,omitempty"`
+}
+
+// Fault configures the faults of a tap, percentages are 0 to 100.
+type Fault struct {
+	Latency  *LatencyFault  `yaml:"latency,omitempty"`
+	Error    *ErrorFault    `yaml:"error,omitempty"`
+	Abort    *AbortFault    `yaml:"abort,omitempty"`
+	Truncate *TruncateFault `yaml:"truncate,omitempty"`
+}
+
+type LatencyFault struct {
+	Percentage float64 `yaml:"percentage"`
+	// Distribution is fixed, uniform or normal.
+	Distribution string        `yaml:"distribution,omitempty"`
+	Delay        time.Duration `yaml:"delay"`
+	Max          time.Duration `yaml:"max,omitempty"`
+	StdDev       time.Duration `yaml:"stdDev,omitempty"`
+}
+
+type ErrorFault struct {
+	Percentage  float64 `yaml:"percentage"`
+	Status      int     `yaml:"status,omitempty"`
+	ContentType string  `yaml:"contentType,omitempty"`
+	Body        string  `yaml:"body,omitempty"`
+}
+
+type AbortFault struct {
+	Percentage float64 `yaml:"percentage"`
+}
+
+type TruncateFault struct {
+	Percentage float64 `yaml:"percentage"`
+	Bytes      int64   `yaml:"bytes"`
+}
+
+// Mock is the response returned for the patterns of the tap.
+// The body is Body, the content of BodyFile or the rendered Template.
+type Mock struct {
+	Status   int               `yaml:"status,omitempty"`
+	Headers  map[string]string `yaml:"headers,omitempty"`
+	Body     string            `yaml:"body,omitempty"`
+	BodyFile string            `yaml:"bodyFile,omitempty"`
+	Template string            `yaml:"template,omitempty"`
+}
 
-	RequestIn  *Body `yaml:"requestIn,omitempty"`
-	RequestOut *Body `yaml:"requestOut,omitempty"`
-	Response   *Body `yaml:"response,omitempty"`
+// PII scans the captured data for sensitive values.
+type PII struct {
+	// Detectors are email, creditcard, iban, bsn, phone, jwt and privatekey,
+	// all detectors when empty.
+	Detectors []string `yaml:"detectors,omitempty"`
+	// Action is mask or annotate.
+	Action string `yaml:"action"`
+}
+
+// Mutation changes the traffic that passes the proxy.
+// The body patches apply to JSON bodies only.
+type Mutation struct {
+	BodyPatch      []Operation     `yaml:"bodyPatch,omitempty"`
+	BodyMergePatch any             `yaml:"bodyMergePatch,omitempty"`
+	Header         *HeaderMutation `yaml:"header,omitempty"`
+}
+
+// HeaderMutation removes, sets and adds headers, in that order.
+type HeaderMutation struct {
+	Add    map[string]string `yaml:"add,omitempty"`
+	Set    map[string]string `yaml:"set,omitempty"`
+	Remove []string          `yaml:"remove,omitempty"`
+}
+
+// Redact redacts values in the JSON bodies before the taps see them.
+type Redact struct {
+	// KeyEnv is the environment variable that contains the key for action hmac.
+	KeyEnv string       `yaml:"keyEnv,omitempty"`
+	Rules  []RedactRule `yaml:"rules"`
+}
+
+// RedactRule selects values with a JSONPath like $..email or $.items[*].iban.
+// Action is one of mask, remove, hash or hmac.
+type RedactRule struct {
+	Path   string `yaml:"path"`
+	Action string `yaml:"action"`
 }
 
 type LogTap struct {
 	LogFile string `yaml:"logFile"`
 }
 
+// OTelTap exports the spans of the exchanges with OTLP/HTTP.
+type OTelTap struct {
+	// Endpoint is the base URL of the receiver, like http://localhost:4318.
+	Endpoint      string            `yaml:"endpoint"`
+	ServiceName   string            `yaml:"serviceName,omitempty"`
+	Headers       map[string]string `yaml:"headers,omitempty"`
+	BatchSize     int               `yaml:"batchSize,omitempty"`
+	FlushInterval time.Duration     `yaml:"flushInterval,omitempty"`
+}
+
 type TemplateTap struct {
 	Template string `yaml:"template"`
 	LogFile  string `yaml:"logFile"`
 }
 
+// LoadTapHandler loads the tap handler config file strictly, like validate
+// does, see DecodeTapHandler. A misspelled key is an error, it is not ignored.
 func LoadTapHandler(name string) (*TapHandler, error) {
 	blob, err := os.ReadFile(name)
 	if err != nil {
 		return nil, fmt.Errorf("error reading taphandler config file: %w", err)
 	}
 
-	var obj = &TapHandler{}
-	if err := yaml.Unmarshal(blob, obj); err != nil {
-		return nil, fmt.Errorf("error unmarshalling taphandler config file: %w", err)
+	obj, _, diags := DecodeTapHandler(blob)
+	var errs []error
+	for _, d := range diags {
+		if d.Severity == SeverityError {
+			errs = append(errs, fmt.Errorf("%s:%s", name, d))
+		}
+	}
+	if obj == nil || len(errs) > 0 {
+		return nil, fmt.Errorf("error decoding taphandler config file: %w", errors.Join(errs...))
 	}
+	obj.File = name
 	return obj, nil
 }
@@ -0,0 +1,262 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Severity tells if a diagnostic makes the config invalid.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Position is a line and column in a config file, both start at 1.
+type Position struct {
+	Line   int
+	Column int
+}
+
+// Diagnostic is a problem in a config file.
+type Diagnostic struct {
+	Position
+	Severity Severity
+	// Path is the path of the value, like taps[0].mock.bodyFile.
+	Path    string
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d:%d: %s: ", d.Line, d.Column, d.Severity)
+	if d.Path != "" {
+		sb.WriteString(d.Path)
+		sb.WriteString(": ")
+	}
+	sb.WriteString(d.Message)
+	return sb.String()
+}
+
+// Positions maps the paths of the values in a config file to their position.
+type Positions map[string]Position
+
+// Locate returns the position of path, or of its closest parent that is in the file.
+func (p Positions) Locate(path string) Position {
+	for path != "" {
+		if pos, ok := p[path]; ok {
+			return pos
+		}
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+	if pos, ok := p[""]; ok {
+		return pos
+	}
+	return Position{Line: 1, Column: 1}
+}
+
+// DecodeTapHandler decodes a tap handler config strictly. Unknown and repeated
+// fields and values of the wrong type are reported with their position,
+// deprecated fields with a warning. The config is nil when there are errors.
+func DecodeTapHandler(blob []byte) (*TapHandler, Positions, []Diagnostic) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(blob, &doc); err != nil {
+		return nil, nil, []Diagnostic{syntaxError(err)}
+	}
+	obj := &TapHandler{}
+	if len(doc.Content) == 0 {
+		return obj, Positions{}, nil
+	}
+	w := &walker{positions: Positions{}}
+	w.walk(doc.Content[0], reflect.TypeOf(obj), "")
+	for _, d := range w.diags {
+		if d.Severity == SeverityError {
+			return nil, w.positions, w.diags
+		}
+	}
+	if err := doc.Decode(obj); err != nil {
+		return nil, w.positions, []Diagnostic{w.diag(&doc, "", err.Error())}
+	}
+	return obj, w.positions, w.diags
+}
+
+// deprecatedFields are the fields an older version of a type accepted.
+// They are reported with a warning and ignored, so old configs keep loading.
+var deprecatedFields = map[reflect.Type]map[string]string{
+	// requestOut was a Body before it changed the request, it did not capture anything.
+	reflect.TypeOf(Mutation{}): {
+		"body":           "capture bodies with requestIn",
+		"bodyJSON":       "capture bodies with requestIn",
+		"bodyDecoded":    "capture bodies with requestIn",
+		"multipart":      "parse multipart bodies with requestIn",
+		"evidenceDir":    "save uploaded files with requestIn",
+		"maxBodyBytes":   "limit the captured bodies with requestIn",
+		"spillThreshold": "spill the captured bodies with requestIn",
+		"spillDir":       "spill the captured bodies with requestIn",
+	},
+}
+
+var lineRe = regexp.MustCompile(`^yaml: line (\d+): `)
+
+func syntaxError(err error) Diagnostic {
+	d := Diagnostic{Position: Position{Line: 1, Column: 1}, Severity: SeverityError, Message: err.Error()}
+	if m := lineRe.FindStringSubmatch(d.Message); m != nil {
+		d.Line, _ = strconv.Atoi(m[1])
+		d.Message = d.Message[len(m[0]):]
+	}
+	return d
+}
+
+// walker checks the nodes against the types of the config.
+type walker struct {
+	positions Positions
+	diags     []Diagnostic
+}
+
+func (w *walker) diag(n *yaml.Node, path, msg string) Diagnostic {
+	return Diagnostic{
+		Position: Position{Line: n.Line, Column: n.Column},
+		Severity: SeverityError,
+		Path:     path,
+		Message:  msg,
+	}
+}
+
+func (w *walker) errorf(n *yaml.Node, path, format string, args ...any) {
+	w.diags = append(w.diags, w.diag(n, path, fmt.Sprintf(format, args...)))
+}
+
+func (w *walker) warnf(n *yaml.Node, path, format string, args ...any) {
+	d := w.diag(n, path, fmt.Sprintf(format, args...))
+	d.Severity = SeverityWarning
+	w.diags = append(w.diags, d)
+}
+
+var typeErrorLineRe = regexp.MustCompile(`^line \d+: `)
+
+func (w *walker) walk(n *yaml.Node, t reflect.Type, path string) {
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	w.positions[path] = Position{Line: n.Line, Column: n.Column}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!null" {
+		return
+	}
+	switch {
+	case t.Kind() == reflect.Interface:
+		// Anything goes, like the values of patches.
+	case t.Kind() == reflect.Struct:
+		if n.Kind != yaml.MappingNode {
+			w.errorf(n, path, "expected a mapping, got %s", kind(n))
+			return
+		}
+		fields := yamlFields(t)
+		w.mapping(n, path, func(key *yaml.Node, value *yaml.Node, p string) {
+			f, ok := fields[key.Value]
+			if hint, deprecated := deprecatedFields[t][key.Value]; !ok && deprecated {
+				w.warnf(key, p, "deprecated field %q is ignored, %s", key.Value, hint)
+				return
+			}
+			if !ok {
+				w.errorf(key, p, "unknown field %q, expected one of %s", key.Value, fieldNames(fields))
+				return
+			}
+			w.walk(value, f.Type, p)
+		})
+	case t.Kind() == reflect.Map:
+		if n.Kind != yaml.MappingNode {
+			w.errorf(n, path, "expected a mapping, got %s", kind(n))
+			return
+		}
+		w.mapping(n, path, func(key *yaml.Node, value *yaml.Node, p string) {
+			w.walk(value, t.Elem(), p)
+		})
+	case t.Kind() == reflect.Slice:
+		if n.Kind != yaml.SequenceNode {
+			w.errorf(n, path, "expected a list, got %s", kind(n))
+			return
+		}
+		for i, item := range n.Content {
+			w.walk(item, t.Elem(), path+"["+strconv.Itoa(i)+"]")
+		}
+	default:
+		if err := n.Decode(reflect.New(t).Interface()); err != nil {
+			msg := err.Error()
+			if te, ok := err.(*yaml.TypeError); ok && len(te.Errors) > 0 {
+				msg = typeErrorLineRe.ReplaceAllString(te.Errors[0], "")
+			}
+			w.errorf(n, path, "%s", msg)
+		}
+	}
+}
+
+// mapping calls f with the pairs of n, repeated keys are reported.
+func (w *walker) mapping(n *yaml.Node, path string, f func(key, value *yaml.Node, path string)) {
+	seen := map[string]*yaml.Node{}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		p := key.Value
+		if path != "" {
+			p = path + "." + key.Value
+		}
+		if prev, ok := seen[key.Value]; ok {
+			w.errorf(key, p, "%q is already set at line %d", key.Value, prev.Line)
+			continue
+		}
+		seen[key.Value] = key
+		f(key, value, p)
+	}
+}
+
+// yamlFields returns the fields of struct t by their yaml name.
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	res := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = strings.ToLower(f.Name)
+		}
+		res[name] = f
+	}
+	return res
+}
+
+func fieldNames(fields map[string]reflect.StructField) string {
+	names := make([]string, 0, len(fields))
+	for n := range fields {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func kind(n *yaml.Node) string {
+	switch n.Kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a list"
+	}
+	return fmt.Sprintf("%q", n.Value)
+}
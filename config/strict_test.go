@@ -0,0 +1,89 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecodeTapHandler(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want []string
+	}{
+		{
+			name: "valid",
+			yaml: "taps:\n  - name: a\n    patterns: [\"GET /\"]\n    sample:\n      keepSlowerThan: 1s\n",
+		},
+		{
+			name: "unknown field",
+			yaml: "taps:\n  - name: a\n    patern: [\"GET /\"]\n",
+			want: []string{`3:5: error: taps[0].patern: unknown field "patern", expected one of fault,`},
+		},
+		{
+			name: "wrong types",
+			yaml: "taps:\n  - name: a\n    mock:\n      status: ok\n    fault:\n      latency:\n        delay: soon\n",
+			want: []string{
+				"4:15: error: taps[0].mock.status: cannot unmarshal !!str `ok` into int",
+				"7:16: error: taps[0].fault.latency.delay: cannot unmarshal !!str `soon` into time.Duration",
+			},
+		},
+		{
+			name: "list expected",
+			yaml: "taps:\n  name: a\n",
+			want: []string{"2:3: error: taps: expected a list, got a mapping"},
+		},
+		{
+			name: "repeated field",
+			yaml: "taps:\n  - name: a\n    name: b\n",
+			want: []string{`3:5: error: taps[0].name: "name" is already set at line 2`},
+		},
+		{
+			name: "deprecated field",
+			yaml: "taps:\n  - name: a\n    requestOut:\n      body: true\n      bodyPatch:\n        - {op: add, path: /a, value: 1}\n",
+			want: []string{`4:7: warning: taps[0].requestOut.body: deprecated field "body" is ignored, capture bodies with requestIn`},
+		},
+		{
+			name: "syntax error",
+			yaml: "taps:\n  - name: a\n    patterns: [\n",
+			want: []string{"3:1: error: did not find expected node content"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, _, diags := DecodeTapHandler([]byte(tt.yaml))
+			if len(diags) != len(tt.want) {
+				t.Fatalf("got diagnostics %v", diags)
+			}
+			for i, d := range diags {
+				if !strings.HasPrefix(d.String(), tt.want[i]) {
+					t.Errorf("got %q, want %q", d, tt.want[i])
+				}
+			}
+			valid := true
+			for _, w := range tt.want {
+				valid = valid && !strings.Contains(w, ": error: ")
+			}
+			if (cfg != nil) != valid {
+				t.Errorf("got config %v", cfg)
+			}
+		})
+	}
+}
+
+func TestPositionsLocate(t *testing.T) {
+	_, pos, diags := DecodeTapHandler([]byte("taps:\n  - name: a\n    mock:\n      status: 200\n"))
+	if len(diags) > 0 {
+		t.Fatalf("diagnostics %v", diags)
+	}
+	for path, want := range map[string]Position{
+		"taps[0].mock.status":   {Line: 4, Column: 15},
+		"taps[0].mock.bodyFile": {Line: 4, Column: 7},
+		"taps[0].patterns[1]":   {Line: 2, Column: 5},
+		"dispatch.policy":       {Line: 1, Column: 1},
+	} {
+		if got := pos.Locate(path); got != want {
+			t.Errorf("%s: got %v, want %v", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package httptap
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+// DropPolicy decides what happens to a record when the dispatch queue is full.
+type DropPolicy string
+
+const (
+	// DropOldest discards the oldest queued record.
+	DropOldest DropPolicy = "drop-oldest"
+	// DropNewest discards the record that is being queued.
+	DropNewest DropPolicy = "drop-newest"
+	// Block waits until the queue has room.
+	Block DropPolicy = "block"
+)
+
+const (
+	defaultQueueSize = 1024
+	defaultWorkers   = 4
+)
+
+// DispatchStats contains the counters of the asynchronous dispatcher.
+type DispatchStats struct {
+	Queued     uint64
+	Dispatched uint64
+	Dropped    uint64
+}
+
+type dispatchJob struct {
+	ctx context.Context
+	rt  *route
+	rr  *RequestResponse
+}
+
+// dispatcher calls the taps from a pool of workers.
+type dispatcher struct {
+	queue  chan dispatchJob
+	policy DropPolicy
+	logger *slog.Logger
+
+	// mu guards closed and the sends on queue.
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+
+	queued     atomic.Uint64
+	dispatched atomic.Uint64
+	dropped    atomic.Uint64
+	metrics    *proxyMetrics
+}
+
+func newDispatcher(queueSize, workers int, policy DropPolicy, logger *slog.Logger) *dispatcher {
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	switch policy {
+	case DropOldest, DropNewest, Block:
+	default:
+		policy = DropOldest
+	}
+	d := &dispatcher{
+		queue:  make(chan dispatchJob, queueSize),
+		policy: policy,
+		logger: logger.With(slog.String("step", "dispatch")),
+	}
+	d.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go d.work()
+	}
+	return d
+}
+
+func (d *dispatcher) work() {
+	defer d.wg.Done()
+	for j := range d.queue {
+		j.rt.serve(j.ctx, j.rr)
+		d.dispatched.Add(1)
+	}
+}
+
+// drop releases the buffers of a record that will never be served.
+func (d *dispatcher) drop(j dispatchJob) {
+	d.dropped.Add(1)
+	d.metrics.observeDropped()
+	j.rt.release(j.rr)
+	d.logger.Debug("record dropped", slog.String("policy", string(d.policy)))
+}
+
+// dispatch queues the record. The record, including its body buffers,
+// is owned by the dispatcher from here on.
+func (d *dispatcher) dispatch(ctx context.Context, rt *route, rr *RequestResponse) {
+	j := dispatchJob{ctx: ctx, rt: rt, rr: rr}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		d.drop(j)
+		return
+	}
+	d.queued.Add(1)
+	d.metrics.observeQueued()
+	switch d.policy {
+	case Block:
+		d.queue <- j
+	case DropNewest:
+		select {
+		case d.queue <- j:
+		default:
+			d.drop(j)
+		}
+	default:
+		for {
+			select {
+			case d.queue <- j:
+				return
+			default:
+			}
+			// Make room by discarding the oldest record.
+			select {
+			case old := <-d.queue:
+				d.drop(old)
+			default:
+			}
+		}
+	}
+}
+
+// flush stops accepting records and waits until the queue is drained.
+func (d *dispatcher) flush(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.queue)
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+func (d *dispatcher) stats() DispatchStats {
+	return DispatchStats{
+		Queued:     d.queued.Load(),
+		Dispatched: d.dispatched.Load(),
+		Dropped:    d.dropped.Load(),
+	}
+}
@@ -0,0 +1,162 @@
+package httptap
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// maxDecodedSize limits the size of decoded bodies when the capture is unlimited.
+const maxDecodedSize = 64 << 20
+
+// contentDecoder returns a reader that decodes r.
+type contentDecoder func(r io.Reader) (io.Reader, error)
+
+var contentDecoders = map[string]contentDecoder{
+	"gzip": func(r io.Reader) (io.Reader, error) {
+		return gzip.NewReader(r)
+	},
+	"x-gzip": func(r io.Reader) (io.Reader, error) {
+		return gzip.NewReader(r)
+	},
+	"deflate": decodeDeflate,
+	"br": func(r io.Reader) (io.Reader, error) {
+		return brotli.NewReader(r), nil
+	},
+	"zstd": decodeZstd,
+}
+
+// zstdDecoder is shared, DecodeAll is safe for concurrent use.
+var zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) {
+	return zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecodedSize))
+})
+
+func decodeZstd(r io.Reader) (io.Reader, error) {
+	d, err := zstdDecoder()
+	if err != nil {
+		return nil, err
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	res, err := d.DecodeAll(b, nil)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(res), nil
+}
+
+// decodeDeflate handles deflate with the zlib wrapper, as the RFC says,
+// and raw deflate, as some servers send.
+func decodeDeflate(r io.Reader) (io.Reader, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if zr, err := zlib.NewReader(bytes.NewReader(b)); err == nil {
+		return zr, nil
+	}
+	return flate.NewReader(bytes.NewReader(b)), nil
+}
+
+// parseContentEncoding returns the codings of the header in the order they were applied.
+// Identity codings are left out.
+func parseContentEncoding(header string) []string {
+	var res []string
+	for _, c := range strings.Split(header, ",") {
+		c = strings.ToLower(strings.TrimSpace(c))
+		if c == "" || c == "identity" {
+			continue
+		}
+		res = append(res, c)
+	}
+	return res
+}
+
+func contentEncoding(h http.Header) string {
+	return strings.Join(h.Values("Content-Encoding"), ",")
+}
+
+// decodeContent decodes b that is encoded with the codings of the Content-Encoding header.
+// At most limit bytes are decoded.
+func decodeContent(header string, b []byte, limit int64) ([]byte, error) {
+	codings := parseContentEncoding(header)
+	if len(codings) == 0 {
+		return b, nil
+	}
+	// The codings must be undone in reverse order.
+	var r io.Reader = bytes.NewReader(b)
+	for i := len(codings) - 1; i >= 0; i-- {
+		dec, ok := contentDecoders[codings[i]]
+		if !ok {
+			return nil, fmt.Errorf("unsupported content encoding: %s", codings[i])
+		}
+		var err error
+		if r, err = dec(r); err != nil {
+			return nil, fmt.Errorf("error decoding %s: %w", codings[i], err)
+		}
+	}
+	res, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %s: %w", header, err)
+	}
+	if int64(len(res)) > limit {
+		return nil, fmt.Errorf("decoded body exceeds %d bytes", limit)
+	}
+	return res, nil
+}
+
+// decodeBody replaces the captured encoded body in buf with the decoded body.
+// It returns the content encoding and the decoded size. A body that cannot
+// be decoded is kept as captured, the error tells why.
+func (h *Handler) decodeBody(header string, buf *bytes.Buffer, limits captureLimits) (string, int64, error) {
+	if buf == nil || len(parseContentEncoding(header)) == 0 {
+		return "", 0, nil
+	}
+	limit := limits.maxBytes
+	if limit <= 0 {
+		limit = maxDecodedSize
+	}
+	b, err := decodeContent(header, buf.Bytes(), limit)
+	if err != nil {
+		return "", 0, err
+	}
+	buf.Reset()
+	buf.Write(b)
+	return header, int64(len(b)), nil
+}
+
+// decodeBodies decodes the captured copies of the bodies, the forwarded bodies are not touched.
+// A corrupt or too large body comes from the client or the upstream, it is
+// reported in the record and not logged as an error of the proxy.
+func (h *Handler) decodeBodies(rr *RequestResponse) {
+	logger := h.log(rr).With(slog.String("step", "decodeBodies"))
+	var err error
+	if !rr.ReqBodyTruncated {
+		rr.ReqBodyEncoding, rr.ReqBodyDecodedSize, err = h.decodeBody(
+			contentEncoding(rr.ReqHeader), rr.ReqBody, h.reqLimits)
+		if err != nil {
+			logger.Debug("cannot decode request body", slog.String("err", err.Error()))
+			rr.ReqBodyDecodeError = err.Error()
+		}
+	}
+	if !rr.RespBodyTruncated {
+		rr.RespBodyEncoding, rr.RespBodyDecodedSize, err = h.decodeBody(
+			contentEncoding(rr.RespHeader), rr.RespBody, h.respLimits)
+		if err != nil {
+			logger.Debug("cannot decode response body", slog.String("err", err.Error()))
+			rr.RespBodyDecodeError = err.Error()
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package httptap
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func encode(t *testing.T, coding string, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch coding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "rawdeflate":
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	case "zstd":
+		var err error
+		if w, err = zstd.NewWriter(&buf); err != nil {
+			t.Fatalf("zstd writer: %s", err)
+		}
+	default:
+		t.Fatalf("unknown coding %s", coding)
+	}
+	w.Write(b)
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestDecodeContent(t *testing.T) {
+	body := []byte(`{"name":"compressed"}`)
+	cases := []struct {
+		name    string
+		header  string
+		in      []byte
+		limit   int64
+		wantErr bool
+	}{
+		{name: "identity", header: "identity", in: body},
+		{name: "gzip", header: "gzip", in: encode(t, "gzip", body)},
+		{name: "deflate", header: "deflate", in: encode(t, "deflate", body)},
+		{name: "raw deflate", header: "deflate", in: encode(t, "rawdeflate", body)},
+		{name: "br", header: "br", in: encode(t, "br", body)},
+		{name: "zstd", header: "zstd", in: encode(t, "zstd", body)},
+		{name: "gzip then br", header: "gzip, br", in: encode(t, "br", encode(t, "gzip", body))},
+		{name: "unknown", header: "compress", in: body, wantErr: true},
+		{name: "too large", header: "gzip", in: encode(t, "gzip", body), limit: 5, wantErr: true},
+	}
+	for _, cc := range cases {
+		t.Run(cc.name, func(t *testing.T) {
+			limit := cc.limit
+			if limit == 0 {
+				limit = maxDecodedSize
+			}
+			got, err := decodeContent(cc.header, cc.in, limit)
+			if cc.wantErr {
+				if err == nil {
+					t.Fatalf("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: %s", err)
+			}
+			if !bytes.Equal(got, body) {
+				t.Errorf("got %q, want %q", got, body)
+			}
+		})
+	}
+}
+
+func TestProxyDecodesCapturedBody(t *testing.T) {
+	body := []byte(`{"name":"compressed"}`)
+	encoded := encode(t, "gzip", body)
+
+	us := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(encoded)
+	}))
+	defer us.Close()
+
+	pr, err := New(us.URL, WithLogger(slog.Default()))
+	if err != nil {
+		t.Fatalf("error creating proxy: %s", err)
+	}
+	records := make(chan RequestResponse, 1)
+	pr.Tap([]string{"/"}, TapFunc(func(_ context.Context, rr *RequestResponse) {
+		records <- *rr
+	}), WithResponseBody(), WithResponseJSON())
+
+	ps := httptest.NewServer(pr)
+	defer ps.Close()
+
+	// Ask for gzip explicitly, so the client does not decode it.
+	req, _ := http.NewRequest(http.MethodGet, ps.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("get error: %s", err)
+	}
+	got, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !bytes.Equal(got, encoded) {
+		t.Errorf("client did not receive the encoded body")
+	}
+
+	rr := <-records
+	if rr.RespBodyEncoding != "gzip" {
+		t.Errorf("encoding: got %q", rr.RespBodyEncoding)
+	}
+	if rr.RespBodySize != int64(len(encoded)) || rr.RespBodyDecodedSize != int64(len(body)) {
+		t.Errorf("sizes: got %d/%d, want %d/%d",
+			rr.RespBodySize, rr.RespBodyDecodedSize, len(encoded), len(body))
+	}
+	m, ok := rr.RespBodyJSON.(map[string]any)
+	if !ok || m["name"] != "compressed" {
+		t.Errorf("json not decoded: %v", rr.RespBodyJSON)
+	}
+}
+
+func TestDecodeBodiesCorrupt(t *testing.T) {
+	h := &Handler{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	rr := &RequestResponse{
+		RespHeader: http.Header{"Content-Encoding": {"gzip"}},
+		RespBody:   bytes.NewBufferString("not gzip"),
+	}
+	h.decodeBodies(rr)
+	if rr.RespBodyDecodeError == "" || rr.RespBodyEncoding != "" {
+		t.Errorf("got error %q, encoding %q", rr.RespBodyDecodeError, rr.RespBodyEncoding)
+	}
+	if rr.RespBody.String() != "not gzip" {
+		t.Errorf("body changed: %q", rr.RespBody)
+	}
+	if rr.ReqBodyDecodeError != "" {
+		t.Errorf("request without body got error %q", rr.ReqBodyDecodeError)
+	}
+}
@@ -0,0 +1,92 @@
+package httptap
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// ErrorKind classifies the errors of the exchange with the upstream.
+type ErrorKind string
+
+const (
+	ErrorDial           ErrorKind = "dial"
+	ErrorTimeout        ErrorKind = "timeout"
+	ErrorTLS            ErrorKind = "tls"
+	ErrorClientCanceled ErrorKind = "client_canceled"
+	ErrorUpstreamReset  ErrorKind = "upstream_reset"
+	ErrorOther          ErrorKind = "other"
+)
+
+// ClassifyError returns the kind of err, or the empty string if err is nil.
+func ClassifyError(err error) ErrorKind {
+	if err == nil {
+		return ""
+	}
+	if errors.Is(err, context.Canceled) {
+		return ErrorClientCanceled
+	}
+	var ne net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout()) {
+		return ErrorTimeout
+	}
+	if isTLSError(err) {
+		return ErrorTLS
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return ErrorDial
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return ErrorDial
+	}
+	if errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF) {
+		return ErrorUpstreamReset
+	}
+	return ErrorOther
+}
+
+func isTLSError(err error) bool {
+	var (
+		recordErr    tls.RecordHeaderError
+		alertErr     tls.AlertError
+		verifyErr    *tls.CertificateVerificationError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+	)
+	switch {
+	case errors.As(err, &recordErr),
+		errors.As(err, &alertErr),
+		errors.As(err, &verifyErr),
+		errors.As(err, &authorityErr),
+		errors.As(err, &hostnameErr),
+		errors.As(err, &invalidErr):
+		return true
+	}
+	return strings.Contains(err.Error(), "tls: ")
+}
+
+// errorRecorder records the first read error of a body other than io.EOF.
+type errorRecorder struct {
+	io.Reader
+	rr *RequestResponse
+}
+
+func (e *errorRecorder) Read(p []byte) (int, error) {
+	n, err := e.Reader.Read(p)
+	if err != nil && err != io.EOF && e.rr.Error == nil {
+		e.rr.Error = err
+		e.rr.ErrorKind = ClassifyError(err)
+	}
+	return n, err
+}
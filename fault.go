@@ -0,0 +1,268 @@
+package httptap
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// FaultKind is the kind of an injected fault.
+type FaultKind string
+
+const (
+	FaultLatency  FaultKind = "latency"
+	FaultError    FaultKind = "error"
+	FaultAbort    FaultKind = "abort"
+	FaultTruncate FaultKind = "truncate"
+)
+
+// LatencyDistribution sets how the injected latency is chosen.
+type LatencyDistribution string
+
+const (
+	// LatencyFixed always adds Delay.
+	LatencyFixed LatencyDistribution = "fixed"
+	// LatencyUniform adds a delay between Delay and Max.
+	LatencyUniform LatencyDistribution = "uniform"
+	// LatencyNormal adds a delay around Delay with standard deviation StdDev.
+	LatencyNormal LatencyDistribution = "normal"
+)
+
+var errFaultTruncated = errors.New("response truncated by fault injection")
+
+// Faults are injected in a percentage of the requests of a tap.
+// Each fault is decided independently, an abort takes precedence over an error.
+type Faults struct {
+	Latency  *LatencyFault
+	Error    *ErrorFault
+	Abort    *AbortFault
+	Truncate *TruncateFault
+}
+
+// LatencyFault delays the request before it is sent to the upstream.
+type LatencyFault struct {
+	// Percentage of the requests, 0 to 100.
+	Percentage   float64
+	Distribution LatencyDistribution
+	Delay        time.Duration
+	Max          time.Duration
+	StdDev       time.Duration
+}
+
+// ErrorFault returns a synthetic error response instead of proxying the request.
+type ErrorFault struct {
+	Percentage  float64
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+// AbortFault closes the client connection without a response.
+type AbortFault struct {
+	Percentage float64
+}
+
+// TruncateFault closes the client connection after Bytes of the response body.
+type TruncateFault struct {
+	Percentage float64
+	Bytes      int64
+}
+
+// InjectedFault records a fault on the RequestResponse.
+type InjectedFault struct {
+	Kind       FaultKind     `json:"kind"`
+	Delay      time.Duration `json:"delay,omitempty"`
+	StatusCode int           `json:"status_code,omitempty"`
+	Bytes      int64         `json:"bytes,omitempty"`
+}
+
+func (f InjectedFault) String() string {
+	switch f.Kind {
+	case FaultLatency:
+		return fmt.Sprintf("%s:%s", f.Kind, f.Delay)
+	case FaultError:
+		return fmt.Sprintf("%s:%d", f.Kind, f.StatusCode)
+	case FaultTruncate:
+		return fmt.Sprintf("%s:%d", f.Kind, f.Bytes)
+	}
+	return string(f.Kind)
+}
+
+func validateFaults(f Faults) error {
+	var errs []error
+	check := func(kind FaultKind, pct float64) {
+		if pct < 0 || pct > 100 {
+			errs = append(errs, fmt.Errorf("%s fault: percentage %g not between 0 and 100", kind, pct))
+		}
+	}
+	if l := f.Latency; l != nil {
+		check(FaultLatency, l.Percentage)
+		switch l.Distribution {
+		case "", LatencyFixed, LatencyNormal:
+		case LatencyUniform:
+			if l.Max < l.Delay {
+				errs = append(errs, fmt.Errorf("latency fault: max %s is less than delay %s", l.Max, l.Delay))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("latency fault: unknown distribution %q", l.Distribution))
+		}
+	}
+	if e := f.Error; e != nil {
+		check(FaultError, e.Percentage)
+		if e.StatusCode != 0 && (e.StatusCode < 100 || e.StatusCode > 999) {
+			errs = append(errs, fmt.Errorf("error fault: bad status code %d", e.StatusCode))
+		}
+	}
+	if a := f.Abort; a != nil {
+		check(FaultAbort, a.Percentage)
+	}
+	if t := f.Truncate; t != nil {
+		check(FaultTruncate, t.Percentage)
+	}
+	return errors.Join(errs...)
+}
+
+// hit reports if a request gets a fault with percentage pct.
+func hit(pct float64) bool {
+	return pct > 0 && rand.Float64()*100 < pct
+}
+
+func (l *LatencyFault) delay() time.Duration {
+	d := l.Delay
+	switch l.Distribution {
+	case LatencyUniform:
+		if l.Max > l.Delay {
+			d += rand.N(l.Max - l.Delay)
+		}
+	case LatencyNormal:
+		d += time.Duration(rand.NormFloat64() * float64(l.StdDev))
+	}
+	return max(d, 0)
+}
+
+// injectFaults applies the faults before the request is proxied.
+// It returns true when the response is written.
+func (h *Handler) injectFaults(w http.ResponseWriter, r *http.Request, rr *RequestResponse) bool {
+	f := h.faults
+	if f == nil {
+		return false
+	}
+	logger := h.log(rr).With(slog.String("step", "injectFaults"))
+
+	if l := f.Latency; l != nil && hit(l.Percentage) {
+		d := l.delay()
+		rr.Faults = append(rr.Faults, InjectedFault{Kind: FaultLatency, Delay: d})
+		logger.Debug("injecting latency", slog.Duration("delay", d))
+		sleep(r.Context(), d)
+	}
+	switch {
+	case f.Abort != nil && hit(f.Abort.Percentage):
+		rr.Faults = append(rr.Faults, InjectedFault{Kind: FaultAbort})
+		logger.Debug("injecting abort")
+		h.recordRequest(rr, r)
+		rr.End = time.Now()
+		rr.Duration = rr.End.Sub(rr.Start)
+		// The server closes the connection, the proxy still serves the record.
+		panic(http.ErrAbortHandler)
+
+	case f.Error != nil && hit(f.Error.Percentage):
+		e := f.Error
+		status := e.StatusCode
+		if status == 0 {
+			status = http.StatusServiceUnavailable
+		}
+		rr.Faults = append(rr.Faults, InjectedFault{Kind: FaultError, StatusCode: status})
+		logger.Debug("injecting error", slog.Int("status", status))
+		h.recordRequest(rr, r)
+		if e.ContentType != "" {
+			w.Header().Set("Content-Type", e.ContentType)
+		}
+		rr.End = time.Now()
+		rr.Duration = rr.End.Sub(rr.Start)
+		rr.StatusCode = status
+		rr.Status = fmt.Sprintf("%d %s", status, http.StatusText(status))
+		rr.RespProto = r.Proto
+		rr.RespHeader = w.Header().Clone()
+		if len(e.Body) > 0 {
+			rr.respCapture = newBodyCapture(rr.captureResponse, rr.respLimits, logger)
+			rr.respCapture.Write(e.Body)
+		}
+		w.WriteHeader(status)
+		w.Write(e.Body)
+		return true
+	}
+
+	// The fault is recorded when the body is actually cut, see truncatedBody.
+	if t := f.Truncate; t != nil && hit(t.Percentage) {
+		rr.truncate = true
+	}
+	return false
+}
+
+// recordRequest records the incoming request when it is not proxied.
+func (h *Handler) recordRequest(rr *RequestResponse, r *http.Request) {
+	rr.Host = r.Host
+	rr.URL = r.URL
+	rr.ReqProto = r.Proto
+	rr.Method = r.Method
+	rr.ReqHeader = r.Header.Clone()
+	rr.ReqTrailer = r.Trailer.Clone()
+}
+
+// truncateResponse cuts the response body off when the truncate fault hit.
+func (h *Handler) truncateResponse(rr *RequestResponse, r *http.Response) {
+	if !rr.truncate || r.Body == nil || r.Body == http.NoBody {
+		return
+	}
+	n := h.faults.Truncate.Bytes
+	r.Body = &truncatedBody{ReadCloser: r.Body, rr: rr, bytes: n, n: n}
+}
+
+// truncatedBody returns errFaultTruncated after n bytes, so the
+// reverse proxy aborts the connection to the client. A body that is not
+// longer than n is passed completely and no fault is recorded.
+type truncatedBody struct {
+	io.ReadCloser
+	rr    *RequestResponse
+	bytes int64
+	n     int64
+	// err is returned after the n bytes.
+	err error
+}
+
+func (b *truncatedBody) Read(p []byte) (int, error) {
+	if b.n <= 0 {
+		return 0, b.cut()
+	}
+	if int64(len(p)) > b.n {
+		p = p[:b.n]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.n -= int64(n)
+	return n, err
+}
+
+// cut records the fault when the body continues after the n bytes,
+// the end of the body is returned otherwise.
+func (b *truncatedBody) cut() error {
+	if b.err != nil {
+		return b.err
+	}
+	var one [1]byte
+	for {
+		n, err := b.ReadCloser.Read(one[:])
+		if n > 0 {
+			b.rr.Faults = append(b.rr.Faults, InjectedFault{Kind: FaultTruncate, Bytes: b.bytes})
+			b.err = errFaultTruncated
+			return b.err
+		}
+		if err != nil {
+			b.err = err
+			return err
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package httptap
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+
+	"github.com/myhops/httptap/filter"
+)
+
+// FilterVariables are the variables of the RequestResponse that a filter can use.
+var FilterVariables = []string{
+	"status", "method", "host", "path", "url", "query",
+	"duration", "reqHeader", "respHeader", "reqBody", "respBody",
+	"reqBodySize", "respBodySize", "error", "errorKind", "mocked", "sampled",
+	"pattern", "pathValues", "requestID", "traceID",
+}
+
+// CompileFilter compiles a filter expression, see package filter.
+//
+//	status >= 500 || duration > 2s || reqHeader["X-Debug"] == "1"
+func CompileFilter(src string) (*filter.Expr, error) {
+	return filter.Compile(src, FilterVariables...)
+}
+
+type headerIndex http.Header
+
+func (h headerIndex) Index(key string) any {
+	if v := http.Header(h).Values(key); len(v) > 0 {
+		return v[0]
+	}
+	return nil
+}
+
+type queryIndex url.Values
+
+func (q queryIndex) Index(key string) any {
+	if v, ok := q[key]; ok && len(v) > 0 {
+		return v[0]
+	}
+	return nil
+}
+
+// filterVars returns the variables of rr. The bodies are decoded
+// when the filter uses them.
+func filterVars(rr *RequestResponse) map[string]any {
+	vars := map[string]any{
+		"status":       rr.StatusCode,
+		"method":       rr.Method,
+		"host":         rr.Host,
+		"duration":     rr.Duration,
+		"reqHeader":    headerIndex(rr.ReqHeader),
+		"respHeader":   headerIndex(rr.RespHeader),
+		"reqBodySize":  rr.ReqBodySize,
+		"respBodySize": rr.RespBodySize,
+		"errorKind":    string(rr.ErrorKind),
+		"mocked":       rr.Mocked,
+		"sampled":      rr.Sampled,
+		"pattern":      rr.Pattern,
+		"pathValues":   rr.PathValues,
+		"requestID":    rr.RequestID,
+		"reqBody": func() any {
+			return filterBody(rr.ReqHeader, rr.ReqBody, rr.ReqBodyTruncated)
+		},
+		"respBody": func() any {
+			return filterBody(rr.RespHeader, rr.RespBody, rr.RespBodyTruncated)
+		},
+	}
+	if rr.URL != nil {
+		vars["path"] = rr.URL.Path
+		vars["url"] = rr.URL.String()
+		vars["query"] = func() any { return queryIndex(rr.URL.Query()) }
+	}
+	if rr.Trace != nil {
+		vars["traceID"] = rr.Trace.TraceID
+	}
+	if rr.Error != nil {
+		vars["error"] = rr.Error.Error()
+	}
+	return vars
+}
+
+func filterBody(h http.Header, b *bytes.Buffer, truncated bool) any {
+	if b == nil || truncated {
+		return nil
+	}
+	obj, _, err := decodeBody(h, b.Bytes())
+	if err != nil {
+		return nil
+	}
+	return obj
+}
+
+// match reports if the record passes the filter of the tap.
+func (h *Handler) match(rr *RequestResponse) bool {
+	return h.filter == nil || h.filter.Match(filterVars(rr))
+}
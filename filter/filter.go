@@ -0,0 +1,555 @@
+// Package filter implements a small expression language to select records,
+// like
+//
+//	status >= 500 || duration > 2s || reqHeader["X-Debug"] == "1"
+//	method == "POST" && reqBody.order.total > 1000
+//	path =~ "^/api/v[0-9]+/users"
+//
+// Expressions combine comparisons (==, !=, <, <=, >, >=), regular expression
+// matches (=~) and the logical operators ||, && and ! with parentheses.
+// Literals are numbers, durations like 150ms or 1m30s, double quoted strings,
+// true, false and null.
+// Variables are provided at evaluation time; fields and elements of their
+// values are selected with .name, ["name"] and [index].
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Indexer is implemented by values that select their elements by key,
+// e.g. HTTP headers.
+type Indexer interface {
+	Index(key string) any
+}
+
+// Expr is a compiled expression.
+type Expr struct {
+	src  string
+	root node
+}
+
+// Compile parses src. When vars are given, other variable names are an error.
+func Compile(src string, vars ...string) (*Expr, error) {
+	p := &parser{lex: lexer{src: src}}
+	if len(vars) > 0 {
+		p.vars = map[string]bool{}
+		for _, v := range vars {
+			p.vars[v] = true
+		}
+	}
+	p.next()
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %s", p.tok)
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+// MustCompile is like Compile but panics on errors.
+func MustCompile(src string, vars ...string) *Expr {
+	e, err := Compile(src, vars...)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Match evaluates the expression with the variables. A variable can be a
+// func() any, that is called when the variable is used.
+// Missing variables, fields and elements are null.
+func (e *Expr) Match(vars map[string]any) bool {
+	return truthy(e.root.eval(vars))
+}
+
+// Tokens.
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokNumber
+	tokDuration
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+type lexer struct {
+	src string
+	pos int
+}
+
+var operators = []string{"||", "&&", "==", "!=", "<=", ">=", "=~", "<", ">", "!", "(", ")", "[", "]", "."}
+
+func isLetter(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) && strings.IndexByte(" \t\r\n", l.src[l.pos]) >= 0 {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+	c := l.src[l.pos]
+	switch {
+	case isLetter(c):
+		for l.pos < len(l.src) && (isLetter(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		return token{kind: tokIdent, text: l.src[start:l.pos], pos: start}, nil
+
+	case isDigit(c):
+		kind := tokNumber
+		for l.pos < len(l.src) {
+			c := l.src[l.pos]
+			switch {
+			case isDigit(c) || c == '.':
+			case isLetter(c) || strings.HasPrefix(l.src[l.pos:], "µ"):
+				// A unit makes it a duration, like 1m30s.
+				kind = tokDuration
+			default:
+				return token{kind: kind, text: l.src[start:l.pos], pos: start}, nil
+			}
+			l.pos++
+		}
+		return token{kind: kind, text: l.src[start:l.pos], pos: start}, nil
+
+	case c == '"':
+		l.pos++
+		for l.pos < len(l.src) && l.src[l.pos] != '"' {
+			if l.src[l.pos] == '\\' {
+				l.pos++
+			}
+			l.pos++
+		}
+		if l.pos >= len(l.src) {
+			return token{}, fmt.Errorf("filter: unterminated string at %d", start)
+		}
+		l.pos++
+		return token{kind: tokString, text: l.src[start:l.pos], pos: start}, nil
+	}
+	for _, op := range operators {
+		if strings.HasPrefix(l.src[l.pos:], op) {
+			l.pos += len(op)
+			return token{kind: tokOp, text: op, pos: start}, nil
+		}
+	}
+	return token{}, fmt.Errorf("filter: unexpected character %q at %d", c, start)
+}
+
+// Parser.
+
+type parser struct {
+	lex  lexer
+	tok  token
+	err  error
+	vars map[string]bool
+}
+
+func (p *parser) next() {
+	if p.err != nil {
+		return
+	}
+	p.tok, p.err = p.lex.next()
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	if p.err != nil {
+		return p.err
+	}
+	return fmt.Errorf("filter: %s at %d", fmt.Sprintf(format, args...), p.tok.pos)
+}
+
+func (p *parser) isOp(op string) bool {
+	return p.err == nil && p.tok.kind == tokOp && p.tok.text == op
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.isOp("||") {
+		p.next()
+		var right node
+		if right, err = p.parseAnd(); err == nil {
+			left = &orNode{left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	for err == nil && p.isOp("&&") {
+		p.next()
+		var right node
+		if right, err = p.parseNot(); err == nil {
+			left = &andNode{left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.isOp("!") {
+		p.next()
+		n, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{n}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokOp {
+		return left, p.err
+	}
+	op := p.tok.text
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		p.next()
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return &compareNode{op: op, left: left, right: right}, nil
+	case "=~":
+		p.next()
+		if p.tok.kind != tokString {
+			return nil, p.errorf("expected regular expression string, got %s", p.tok)
+		}
+		s, _ := strconv.Unquote(p.tok.text)
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, p.errorf("bad regular expression: %s", err)
+		}
+		p.next()
+		return &matchNode{left: left, re: re}, p.err
+	}
+	return left, p.err
+}
+
+func (p *parser) parseOperand() (node, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	t := p.tok
+	var n node
+	switch t.kind {
+	case tokNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.errorf("bad number %s", t)
+		}
+		n = literal{f}
+	case tokDuration:
+		d, err := time.ParseDuration(t.text)
+		if err != nil {
+			return nil, p.errorf("bad duration %s", t)
+		}
+		n = literal{d}
+	case tokString:
+		s, err := strconv.Unquote(t.text)
+		if err != nil {
+			return nil, p.errorf("bad string %s", t)
+		}
+		n = literal{s}
+	case tokIdent:
+		switch t.text {
+		case "true":
+			n = literal{true}
+		case "false":
+			n = literal{false}
+		case "null":
+			n = literal{nil}
+		default:
+			if p.vars != nil && !p.vars[t.text] {
+				return nil, p.errorf("unknown variable %s", t)
+			}
+			n = variable(t.text)
+		}
+	case tokOp:
+		if t.text != "(" {
+			return nil, p.errorf("unexpected %s", t)
+		}
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.isOp(")") {
+			return nil, p.errorf("expected \")\", got %s", p.tok)
+		}
+		n = inner
+	default:
+		return nil, p.errorf("unexpected %s", t)
+	}
+	p.next()
+	return p.parseSelectors(n)
+}
+
+// parseSelectors parses the .name and [key] after an operand.
+func (p *parser) parseSelectors(n node) (node, error) {
+	for p.err == nil {
+		switch {
+		case p.isOp("."):
+			p.next()
+			if p.tok.kind != tokIdent {
+				return nil, p.errorf("expected field name, got %s", p.tok)
+			}
+			n = &indexNode{n, p.tok.text}
+			p.next()
+		case p.isOp("["):
+			p.next()
+			var key any
+			switch p.tok.kind {
+			case tokString:
+				key, _ = strconv.Unquote(p.tok.text)
+			case tokNumber:
+				i, err := strconv.Atoi(p.tok.text)
+				if err != nil {
+					return nil, p.errorf("bad index %s", p.tok)
+				}
+				key = i
+			default:
+				return nil, p.errorf("expected string or index, got %s", p.tok)
+			}
+			p.next()
+			if !p.isOp("]") {
+				return nil, p.errorf("expected \"]\", got %s", p.tok)
+			}
+			n = &indexNode{n, key}
+			p.next()
+		default:
+			return n, nil
+		}
+	}
+	return nil, p.err
+}
+
+// Evaluation.
+
+type node interface {
+	eval(vars map[string]any) any
+}
+
+type literal struct{ v any }
+
+func (n literal) eval(map[string]any) any { return n.v }
+
+type variable string
+
+func (n variable) eval(vars map[string]any) any {
+	return value(vars[string(n)])
+}
+
+type indexNode struct {
+	n   node
+	key any
+}
+
+func (n *indexNode) eval(vars map[string]any) any {
+	switch v := n.n.eval(vars).(type) {
+	case Indexer:
+		if k, ok := n.key.(string); ok {
+			return value(v.Index(k))
+		}
+	case map[string]any:
+		if k, ok := n.key.(string); ok {
+			return value(v[k])
+		}
+	case map[string]string:
+		if k, ok := n.key.(string); ok {
+			return v[k]
+		}
+	case []any:
+		if i, ok := n.key.(int); ok && i >= 0 && i < len(v) {
+			return value(v[i])
+		}
+	}
+	return nil
+}
+
+type orNode struct{ left, right node }
+
+func (n *orNode) eval(vars map[string]any) any {
+	return truthy(n.left.eval(vars)) || truthy(n.right.eval(vars))
+}
+
+type andNode struct{ left, right node }
+
+func (n *andNode) eval(vars map[string]any) any {
+	return truthy(n.left.eval(vars)) && truthy(n.right.eval(vars))
+}
+
+type notNode struct{ n node }
+
+func (n *notNode) eval(vars map[string]any) any {
+	return !truthy(n.n.eval(vars))
+}
+
+type matchNode struct {
+	left node
+	re   *regexp.Regexp
+}
+
+func (n *matchNode) eval(vars map[string]any) any {
+	s, ok := n.left.eval(vars).(string)
+	return ok && n.re.MatchString(s)
+}
+
+type compareNode struct {
+	op          string
+	left, right node
+}
+
+func (n *compareNode) eval(vars map[string]any) any {
+	l, r := n.left.eval(vars), n.right.eval(vars)
+	c, ok := compare(l, r)
+	switch n.op {
+	case "==":
+		return ok && c == 0
+	case "!=":
+		return !ok || c != 0
+	case "<":
+		return ok && c < 0
+	case "<=":
+		return ok && c <= 0
+	case ">":
+		return ok && c > 0
+	case ">=":
+		return ok && c >= 0
+	}
+	return false
+}
+
+// value converts the numbers to float64 and calls the lazy values.
+func value(v any) any {
+	switch v := v.(type) {
+	case func() any:
+		return value(v())
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case uint:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return v.String()
+		}
+		return f
+	case error:
+		return v.Error()
+	}
+	return v
+}
+
+func number(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case time.Duration:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// compare returns -1, 0 or 1, ok is false when the values cannot be compared.
+// A string is compared with a number as a number.
+func compare(l, r any) (int, bool) {
+	ln, lok := number(l)
+	rn, rok := number(r)
+	if ls, ok := l.(string); ok && rok {
+		f, err := strconv.ParseFloat(ls, 64)
+		ln, lok = f, err == nil
+	}
+	if rs, ok := r.(string); ok && lok {
+		f, err := strconv.ParseFloat(rs, 64)
+		rn, rok = f, err == nil
+	}
+	if lok && rok {
+		switch {
+		case ln < rn:
+			return -1, true
+		case ln > rn:
+			return 1, true
+		}
+		return 0, true
+	}
+	switch l := l.(type) {
+	case string:
+		if r, ok := r.(string); ok {
+			return strings.Compare(l, r), true
+		}
+	case bool:
+		if r, ok := r.(bool); ok && l == r {
+			return 0, true
+		}
+	case nil:
+		if r == nil {
+			return 0, true
+		}
+	}
+	return 0, false
+}
+
+func truthy(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case float64:
+		return v != 0
+	case time.Duration:
+		return v != 0
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+package filter
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type header http.Header
+
+func (h header) Index(key string) any {
+	return http.Header(h).Get(key)
+}
+
+func TestMatch(t *testing.T) {
+	var body any
+	json.Unmarshal([]byte(`{"order":{"total":1500,"items":[{"sku":"a1"}]},"debug":true}`), &body)
+	called := false
+	vars := map[string]any{
+		"status":    502,
+		"method":    "POST",
+		"path":      "/api/v2/users/42",
+		"duration":  2500 * time.Millisecond,
+		"reqHeader": header{"X-Debug": {"1"}},
+		"reqBody":   func() any { called = true; return body },
+		"mocked":    false,
+	}
+	cases := []struct {
+		src  string
+		want bool
+	}{
+		{`status >= 500`, true},
+		{`status >= 500 && method == "GET"`, false},
+		{`status < 500 || duration > 2s`, true},
+		{`duration > 2m`, false},
+		{`duration >= 1m30s || duration >= 2.5s`, true},
+		{`reqHeader["X-Debug"] == "1"`, true},
+		{`reqHeader["x-debug"] == 1`, true},
+		{`reqHeader["X-Other"] == ""`, true},
+		{`reqHeader["X-Other"]`, false},
+		{`reqBody.order.total > 1000`, true},
+		{`reqBody["order"].items[0].sku == "a1"`, true},
+		{`reqBody.order.items[1].sku == "a1"`, false},
+		{`reqBody.debug && !mocked`, true},
+		{`!(status == 502)`, false},
+		{`path =~ "^/api/v[0-9]+/users/"`, true},
+		{`missing == null`, true},
+		{`missing.field != "x"`, true},
+		{`method == "POST" && (status == 200 || status == 502)`, true},
+		{`"abc" < "abd"`, true},
+		{`true != false`, true},
+	}
+	for _, cc := range cases {
+		e, err := Compile(cc.src)
+		if err != nil {
+			t.Errorf("%s: %s", cc.src, err)
+			continue
+		}
+		if got := e.Match(vars); got != cc.want {
+			t.Errorf("%s: got %t, want %t", cc.src, got, cc.want)
+		}
+	}
+	if !called {
+		t.Errorf("lazy variable not called")
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	cases := []string{
+		``,
+		`status >=`,
+		`status = 500`,
+		`(status == 500`,
+		`status == "500`,
+		`reqHeader[`,
+		`reqHeader[x]`,
+		`path =~ "("`,
+		`path =~ other`,
+		`duration > 2parsecs`,
+		`status == 500 500`,
+		`unknown == 1`,
+		`status # 1`,
+	}
+	for _, src := range cases {
+		if _, err := Compile(src, "status", "path", "duration", "reqHeader"); err == nil {
+			t.Errorf("%q: expected error", src)
+		}
+	}
+}
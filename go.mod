@@ -3,7 +3,9 @@ module github.com/myhops/httptap
 go 1.22.1
 
 require (
+	github.com/andybalholm/brotli v1.2.0
 	github.com/evanphx/json-patch v4.12.0+incompatible
+	github.com/klauspost/compress v1.18.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
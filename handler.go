@@ -3,28 +3,21 @@ package httptap
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
-	"mime"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"sync/atomic"
 	"time"
 
 	jsonpatch "github.com/evanphx/json-patch"
-	"github.com/myhops/httptap/bufpool"
+	"github.com/myhops/httptap/filter"
+	"github.com/myhops/httptap/pii"
 )
 
-// ctError reports content type errors
-type ctError string
-
-func (e ctError) Error() string {
-	return fmt.Sprintf("expected application/json, got %s", string(e))
-}
-
 func NewHandler(upstream *url.URL, p *Proxy, tap Tap, logger *slog.Logger, options ...tapOption) *Handler {
 	h := &Handler{
 		p:        p,
@@ -35,34 +28,105 @@ func NewHandler(upstream *url.URL, p *Proxy, tap Tap, logger *slog.Logger, optio
 	for _, o := range options {
 		o(h)
 	}
+	// Merge the global header lists, the tap masks take precedence.
+	if p != nil {
+		h.includeHeaders = append(h.includeHeaders, p.includeHeaders...)
+		h.excludeHeaders = append(h.excludeHeaders, p.excludeHeaders...)
+		h.headerMasks = append(h.headerMasks, p.headerMasks...)
+	}
+	h.headers = headerFilter{
+		include: h.includeHeaders,
+		exclude: h.excludeHeaders,
+		masks:   h.headerMasks,
+	}
 	return h
 }
 
+// OptionError is the error of a tap option that cannot be applied.
+type OptionError struct {
+	// Option names the option, like redaction.
+	Option string
+	Err    error
+}
+
+func (e *OptionError) Error() string {
+	return e.Option + ": " + e.Err.Error()
+}
+
+func (e *OptionError) Unwrap() error {
+	return e.Err
+}
+
+// optionError records an option that cannot be applied, see Err.
+func (h *Handler) optionError(option string, err error) {
+	h.errs = append(h.errs, &OptionError{Option: option, Err: err})
+}
+
+// Err returns the errors of the options that could not be applied,
+// they are joined *OptionError.
+// A handler with errors must not serve, a tap would run without e.g. its redaction.
+func (h *Handler) Err() error {
+	return errors.Join(h.errs...)
+}
+
 type Handler struct {
+	name     string
 	p        *Proxy
 	upstream *url.URL
 	tap      Tap
 	logger   *slog.Logger
-
-	withRequestBody  bool
-	withResponseBody bool
-	withRequestJSON  bool
-	withResponseJSON bool
+	rp       *httputil.ReverseProxy
+	// patterns are the patterns the handler is added to.
+	patterns []string
+	// errs are the errors of the options.
+	errs []error
+
+	// The body capture and disabled can be changed while the proxy runs.
+	withRequestBody  atomic.Bool
+	withResponseBody atomic.Bool
+	disabled         atomic.Bool
+
+	withRequestJSON     bool
+	withResponseJSON    bool
+	withRequestDecoded  bool
+	withResponseDecoded bool
+	withMultipart       bool
+	evidenceDir         string
 
 	includeHeaders []string
 	excludeHeaders []string
+	headerMasks    []HeaderMask
+	headers        headerFilter
+
+	reqLimits  captureLimits
+	respLimits captureLimits
 
 	reqBodyPatch  jsonpatch.Patch
 	respBodyPatch jsonpatch.Patch
+
+	reqMutation  *mutation
+	respMutation *mutation
+
+	redactor *redactor
+
+	pii       *pii.Scanner
+	piiAction PIIAction
+
+	mock    *mock
+	faults  *Faults
+	network *NetworkConditions
+
+	sampling *sampling
+	filter   *filter.Expr
 }
 
 func (h *Handler) copyRequest(rr *RequestResponse, pr *httputil.ProxyRequest) {
-	logger := h.logger.With(slog.String("step", "copyRequest"))
-	// Allocate a buffer for the outgoing request.
-	if h.withRequestBody && pr.Out.Body != nil {
-		rr.ReqBody = bufpool.Get()
+	logger := h.log(rr).With(slog.String("step", "copyRequest"))
+	// Capture the outgoing request, the size is counted also without capture.
+	if pr.Out.Body != nil && pr.Out.Body != http.NoBody {
+		rr.reqCapture = newBodyCapture(rr.captureRequest, rr.reqLimits, logger)
 		// Ensure closing the bodies.
-		pr.Out.Body = io.NopCloser(io.TeeReader(pr.Out.Body, rr.ReqBody))
+		pr.Out.Body = io.NopCloser(io.TeeReader(pr.Out.Body, rr.reqCapture))
 		logger.Debug("prepared to copy body")
 	}
 
@@ -76,11 +140,15 @@ func (h *Handler) copyRequest(rr *RequestResponse, pr *httputil.ProxyRequest) {
 }
 
 func (h *Handler) copyResponse(rr *RequestResponse, r *http.Response) {
-	logger := h.logger.With(slog.String("step", "copyResponse"))
+	logger := h.log(rr).With(slog.String("step", "copyResponse"))
+	// Record errors that occur while the body is copied to the client.
+	if r.Body != nil {
+		r.Body = io.NopCloser(&errorRecorder{Reader: r.Body, rr: rr})
+	}
 	// Save the response body.
-	if h.withResponseBody && r.Body != nil {
-		rr.RespBody = bufpool.Get()
-		r.Body = io.NopCloser(io.TeeReader(r.Body, rr.RespBody))
+	if r.Body != nil && r.Body != http.NoBody {
+		rr.respCapture = newBodyCapture(rr.captureResponse, rr.respLimits, logger)
+		r.Body = io.NopCloser(io.TeeReader(r.Body, rr.respCapture))
 		logger.Debug("prepared to copy body")
 	}
 
@@ -88,23 +156,39 @@ func (h *Handler) copyResponse(rr *RequestResponse, r *http.Response) {
 	rr.StatusCode = r.StatusCode
 	rr.Status = r.Status
 	rr.RespHeader = r.Header.Clone()
-	rr.ReqTrailer = r.Trailer.Clone()
+	rr.RespTrailer = r.Trailer.Clone()
 	rr.RespProto = r.Proto
 }
 
+// ServeHTTP proxies the request to the upstream and serves the record to the tap.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	newRoute("", h).ServeHTTP(w, r)
+}
+
+// serveHTTP proxies the request, answers it with the mock or injects the faults.
+func (h *Handler) serveHTTP(w http.ResponseWriter, r *http.Request, rr *RequestResponse) {
+	// Delay, fail or abort the request.
+	if h.injectFaults(w, r, rr) {
+		return
+	}
+
+	// Answer mocked routes without contacting the upstream.
+	if h.mock != nil {
+		h.serveMock(w, r, rr)
+		return
+	}
+	h.rp.ServeHTTP(w, r)
+}
+
 func (h *Handler) rewrite(pr *httputil.ProxyRequest) {
 	// Add the request context to the outgoing request.
 	rc := RequestContextValue(pr.In.Context())
 	pr.Out = pr.Out.WithContext(withRequestContext(pr.Out.Context(), rc))
 
-	// Add myself and the proxied request to the request context.
-	rc.Handler = h
+	rr := rc.RequestResponse
 
-	// Create the request response and add it to the request context
-	rr := &RequestResponse{
-		Start: time.Now(),
-	}
-	rc.RequestResponse = rr
+	// Time the phases of the exchange with the upstream.
+	pr.Out = h.traceRequest(rr, pr.Out)
 
 	// set upstream.
 	pr.SetURL(h.p.upstream)
@@ -113,9 +197,18 @@ func (h *Handler) rewrite(pr *httputil.ProxyRequest) {
 
 	pr.SetXForwarded()
 
+	// Pass the client span to the upstream.
+	h.propagateTrace(rr, pr.Out)
+
 	// Ensure bodies are closed.
 	rc.closers = append(rc.closers, pr.In.Body, pr.Out.Body)
 
+	// Change the request before it is recorded.
+	h.mutateRequest(rr, pr.Out)
+
+	// Emulate a slow link to the upstream.
+	h.throttleRequest(pr.Out)
+
 	// Record the data.
 	h.copyRequest(rr, pr)
 }
@@ -130,21 +223,59 @@ func (h *Handler) modifyResponse(r *http.Response) error {
 
 	// Ensure r.body is closed.
 	rc.closers = append(rc.closers, r.Body)
+	h.traceResponse(rr, r)
+
+	// Change the response before it is recorded.
+	if err := h.mutateResponse(rr, r); err != nil {
+		return err
+	}
+
+	// Cut the body off before it is recorded, the tap sees what the client got.
+	h.truncateResponse(rr, r)
+
+	// Emulate a slow link to the client.
+	h.throttleResponse(r)
 
 	// Record the data.
 	h.copyResponse(rr, r)
+	h.echoRequestID(rr, r)
 
 	return nil
 }
 
+// errorHandler records the failed exchange and writes the error response.
+func (h *Handler) errorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	rc := RequestContextValue(r.Context())
+	rr := rc.RequestResponse
+	rr.End = time.Now()
+	rr.Duration = rr.End.Sub(rr.Start)
+	rr.Error = err
+	rr.ErrorKind = ClassifyError(err)
+
+	h.log(rr).Error("upstream error",
+		slog.String("err", err.Error()),
+		slog.String("kind", string(rr.ErrorKind)),
+	)
+
+	status := http.StatusBadGateway
+	if ct := h.p.errorContentType; ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	rr.StatusCode = status
+	rr.Status = fmt.Sprintf("%d %s", status, http.StatusText(status))
+	rr.RespHeader = w.Header().Clone()
+	w.WriteHeader(status)
+	w.Write(h.p.errorBody)
+}
+
 func (h *Handler) patchBodies(rr *RequestResponse) {
-	logger := h.logger.With(slog.String("step", "patchBodies"))
-	if h.reqBodyPatch != nil {
+	logger := h.log(rr).With(slog.String("step", "patchBodies"))
+	if h.reqBodyPatch != nil && rr.ReqBody != nil && !rr.ReqBodyTruncated {
 		logger.Info("patching request")
 		b, err := h.reqBodyPatch.Apply(rr.ReqBody.Bytes())
 		if err != nil {
 			// Log it
-			h.logger.Error("req body patch failed", slog.String("err", err.Error()))
+			logger.Error("req body patch failed", slog.String("err", err.Error()))
 			goto NextPatch
 		}
 		rr.ReqBody.Reset()
@@ -152,11 +283,11 @@ func (h *Handler) patchBodies(rr *RequestResponse) {
 	}
 NextPatch:
 
-	if h.respBodyPatch != nil {
+	if h.respBodyPatch != nil && rr.RespBody != nil && !rr.RespBodyTruncated {
 		logger.Info("patching response")
 		b, err := h.respBodyPatch.Apply(rr.RespBody.Bytes())
 		if err != nil {
-			h.logger.Error("resp body patch failed", slog.String("err", err.Error()))
+			logger.Error("resp body patch failed", slog.String("err", err.Error()))
 			return
 		}
 		rr.RespBody.Reset()
@@ -165,48 +296,95 @@ NextPatch:
 }
 
 func (h *Handler) Serve(ctx context.Context, rr *RequestResponse) error {
-	// Unmarshal json bodies.
+	rr.TapName = h.name
+	h.finishCapture(rr)
+	h.decodeBodies(rr)
+	// Digest the uploaded files before the body is changed.
+	h.parseRequestMultipart(rr)
+
+	// Skip the records that do not pass the filter.
+	if !h.match(rr) {
+		h.release(rr)
+		return nil
+	}
+
+	// Patch and redact the bodies before they are unmarshalled.
 	h.patchBodies(rr)
+	h.redactBodies(rr)
+	h.scanPII(rr)
 	h.unmarshalBodies(rr)
 
+	// Filter the headers before the tap sees them.
+	h.filterHeaders(rr)
+
 	// Call the tap.
-	h.tap.Serve(ctx, rr)
+	h.serveTap(ctx, rr)
 
-	// Return the buffers.
-	bufpool.Put(rr.ReqBody)
-	bufpool.Put(rr.RespBody)
+	h.release(rr)
 	return nil
 }
 
-func (h *Handler) unmarshalBodies(rr *RequestResponse) {
-	if h.withRequestJSON && h.isJson(rr.ReqHeader) == nil {
-		h.unmarshalJSON(rr.ReqBody, &rr.ReqBodyJSON)
+// finishCapture moves the captured bodies and the timing to the request response.
+func (h *Handler) finishCapture(rr *RequestResponse) {
+	if rr.tracer != nil {
+		rr.Timing = rr.tracer.result()
+	}
+	if c := rr.reqCapture; c != nil {
+		rr.ReqBody, rr.ReqBodyFile = c.finish()
+		rr.ReqBodySize = c.size
+		rr.ReqBodyTruncated = c.truncated
 	}
-	if h.withResponseJSON && h.isJson(rr.RespHeader) == nil {
-		h.unmarshalJSON(rr.RespBody, &rr.RespBodyJSON)
+	if c := rr.respCapture; c != nil {
+		rr.RespBody, rr.RespBodyFile = c.finish()
+		rr.RespBodySize = c.size
+		rr.RespBodyTruncated = c.truncated
 	}
 }
 
-func (t *Handler) unmarshalJSON(b *bytes.Buffer, obj *any) error {
-	r := bytes.NewReader(b.Bytes())
-	if err := json.NewDecoder(r).Decode(obj); err != nil {
-		return err
+// release returns the body buffers to the pool and removes the spill files.
+func (h *Handler) release(rr *RequestResponse) {
+	if c := rr.reqCapture; c != nil {
+		c.release()
 	}
-	return nil
+	if c := rr.respCapture; c != nil {
+		c.release()
+	}
+	rr.ReqBody = nil
+	rr.RespBody = nil
+}
+
+func (h *Handler) filterHeaders(rr *RequestResponse) {
+	h.headers.apply(rr.ReqHeader)
+	h.headers.apply(rr.ReqTrailer)
+	h.headers.apply(rr.RespHeader)
+	h.headers.apply(rr.RespTrailer)
 }
 
-func (t *Handler) isJson(h http.Header) error {
-	cth := h.Get("Content-Type")
-	if cth == "" {
-		return errors.New("no Content-Type header")
+func (h *Handler) unmarshalBodies(rr *RequestResponse) {
+	logger := h.log(rr).With(slog.String("step", "unmarshalBodies"))
+	if (h.withRequestJSON || h.withRequestDecoded) && rr.ReqBody != nil && !rr.ReqBodyTruncated {
+		rr.ReqBodyJSON, rr.ReqBodyDecoded = h.unmarshalBody(logger, rr.ReqHeader, rr.ReqBody,
+			h.withRequestJSON, h.withRequestDecoded)
 	}
-	ct, _, err := mime.ParseMediaType(cth)
+	if (h.withResponseJSON || h.withResponseDecoded) && rr.RespBody != nil && !rr.RespBodyTruncated {
+		rr.RespBodyJSON, rr.RespBodyDecoded = h.unmarshalBody(logger, rr.RespHeader, rr.RespBody,
+			h.withResponseJSON, h.withResponseDecoded)
+	}
+}
+
+// unmarshalBody decodes the body with the registered decoder for its Content-Type.
+// The JSON result is only set for JSON media types.
+func (h *Handler) unmarshalBody(logger *slog.Logger, hdr http.Header, b *bytes.Buffer, withJSON, withDecoded bool) (jsonObj any, decoded any) {
+	obj, mt, err := decodeBody(hdr, b.Bytes())
 	if err != nil {
-		return fmt.Errorf("error parsing Content-Type: %w", err)
+		logger.Debug("body not decoded", slog.String("err", err.Error()))
+		return nil, nil
 	}
-	if ct != "application/json" {
-		return ctError(ct)
+	if withJSON && mt == mediaTypeJSON {
+		jsonObj = obj
 	}
-	return nil
+	if withDecoded {
+		decoded = obj
+	}
+	return jsonObj, decoded
 }
-
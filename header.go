@@ -0,0 +1,126 @@
+package httptap
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// HeaderMaskMode selects how the value of a captured header is masked.
+type HeaderMaskMode string
+
+const (
+	// MaskDrop removes the header from the captured data.
+	MaskDrop HeaderMaskMode = "drop"
+	// MaskStars replaces the value with ***.
+	MaskStars HeaderMaskMode = "mask"
+	// MaskLast replaces the value with *** followed by the last Keep characters.
+	MaskLast HeaderMaskMode = "last"
+	// MaskHash replaces the value with its SHA-256 hash.
+	MaskHash HeaderMaskMode = "hash"
+)
+
+const maskedValue = "***"
+
+// HeaderMask masks the values of the headers that match Pattern.
+// Pattern can contain glob characters, e.g. X-Internal-*.
+type HeaderMask struct {
+	Pattern string
+	Mode    HeaderMaskMode
+	// Keep is the number of trailing characters kept by MaskLast.
+	Keep int
+}
+
+func (m HeaderMask) apply(value string) string {
+	switch m.Mode {
+	case MaskLast:
+		r := []rune(value)
+		if m.Keep <= 0 || len(r) <= m.Keep {
+			return maskedValue
+		}
+		return maskedValue + string(r[len(r)-m.Keep:])
+	case MaskHash:
+		sum := sha256.Sum256([]byte(value))
+		return "sha256:" + hex.EncodeToString(sum[:])
+	default:
+		return maskedValue
+	}
+}
+
+// headerFilter decides which captured headers the tap gets to see.
+type headerFilter struct {
+	include []string
+	exclude []string
+	masks   []HeaderMask
+}
+
+// matchHeader reports if key matches the glob pattern, ignoring case.
+func matchHeader(pattern, key string) bool {
+	ok, err := path.Match(strings.ToLower(pattern), strings.ToLower(key))
+	return err == nil && ok
+}
+
+func matchAnyHeader(patterns []string, key string) bool {
+	for _, p := range patterns {
+		if matchHeader(p, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// keep reports if the header passes the include and exclude lists.
+// Exclude always wins, an empty include list includes everything.
+func (f *headerFilter) keep(key string) bool {
+	if matchAnyHeader(f.exclude, key) {
+		return false
+	}
+	return len(f.include) == 0 || matchAnyHeader(f.include, key)
+}
+
+func (f *headerFilter) mask(key string) (HeaderMask, bool) {
+	for _, m := range f.masks {
+		if matchHeader(m.Pattern, key) {
+			return m, true
+		}
+	}
+	return HeaderMask{}, false
+}
+
+func (f *headerFilter) empty() bool {
+	return len(f.include) == 0 && len(f.exclude) == 0 && len(f.masks) == 0
+}
+
+// apply filters and masks h in place.
+func (f *headerFilter) apply(h http.Header) {
+	if f.empty() {
+		return
+	}
+	for k, v := range h {
+		if !f.keep(k) {
+			delete(h, k)
+			continue
+		}
+		m, ok := f.mask(k)
+		if !ok {
+			continue
+		}
+		if m.Mode == MaskDrop {
+			delete(h, k)
+			continue
+		}
+		for i := range v {
+			v[i] = m.apply(v[i])
+		}
+	}
+}
+
+func canonicalHeaders(header []string) []string {
+	var res = make([]string, 0, len(header))
+	for _, h := range header {
+		res = append(res, http.CanonicalHeaderKey(h))
+	}
+	return res
+}
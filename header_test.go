@@ -0,0 +1,100 @@
+package httptap
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHeaderFilter(t *testing.T) {
+	newHeader := func() http.Header {
+		return http.Header{
+			"Authorization":    {"Bearer secret-token"},
+			"X-Api-Key":        {"key-123456"},
+			"X-Internal-Trace": {"abc"},
+			"X-Internal-Id":    {"42"},
+			"Content-Type":     {"application/json"},
+			"Cookie":           {"session=1"},
+		}
+	}
+	cases := []struct {
+		name   string
+		filter headerFilter
+		want   map[string]string
+	}{
+		{
+			name:   "empty",
+			filter: headerFilter{},
+			want: map[string]string{
+				"Authorization":    "Bearer secret-token",
+				"X-Api-Key":        "key-123456",
+				"X-Internal-Trace": "abc",
+				"X-Internal-Id":    "42",
+				"Content-Type":     "application/json",
+				"Cookie":           "session=1",
+			},
+		},
+		{
+			name: "exclude glob",
+			filter: headerFilter{
+				exclude: canonicalHeaders([]string{"x-internal-*", "authorization"}),
+			},
+			want: map[string]string{
+				"X-Api-Key":    "key-123456",
+				"Content-Type": "application/json",
+				"Cookie":       "session=1",
+			},
+		},
+		{
+			name: "include and exclude",
+			filter: headerFilter{
+				include: canonicalHeaders([]string{"X-*"}),
+				exclude: canonicalHeaders([]string{"X-Internal-Id"}),
+			},
+			want: map[string]string{
+				"X-Api-Key":        "key-123456",
+				"X-Internal-Trace": "abc",
+			},
+		},
+		{
+			name: "masks",
+			filter: headerFilter{
+				include: canonicalHeaders([]string{"Authorization", "X-Api-Key", "Cookie"}),
+				masks: []HeaderMask{
+					{Pattern: "Authorization", Mode: MaskStars},
+					{Pattern: "X-Api-*", Mode: MaskLast, Keep: 4},
+					{Pattern: "Cookie", Mode: MaskDrop},
+				},
+			},
+			want: map[string]string{
+				"Authorization": "***",
+				"X-Api-Key":     "***3456",
+			},
+		},
+	}
+	for _, cc := range cases {
+		t.Run(cc.name, func(t *testing.T) {
+			h := newHeader()
+			cc.filter.apply(h)
+			if len(h) != len(cc.want) {
+				t.Fatalf("got %d headers, want %d: %v", len(h), len(cc.want), h)
+			}
+			for k, v := range cc.want {
+				if got := h.Get(k); got != v {
+					t.Errorf("header %s: got %q, want %q", k, got, v)
+				}
+			}
+		})
+	}
+}
+
+func TestHeaderMaskHash(t *testing.T) {
+	m := HeaderMask{Mode: MaskHash}
+	a, b := m.apply("secret"), m.apply("secret")
+	if a != b {
+		t.Errorf("hash not stable: %s != %s", a, b)
+	}
+	if !strings.HasPrefix(a, "sha256:") || strings.Contains(a, "secret") {
+		t.Errorf("bad hash: %s", a)
+	}
+}
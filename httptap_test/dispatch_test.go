@@ -0,0 +1,93 @@
+package httptap_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/myhops/httptap"
+)
+
+func TestAsyncDispatch(t *testing.T) {
+	cases := []struct {
+		name        string
+		policy      httptap.DropPolicy
+		wantDropped bool
+	}{
+		{name: "drop newest", policy: httptap.DropNewest, wantDropped: true},
+		{name: "drop oldest", policy: httptap.DropOldest, wantDropped: true},
+	}
+	for _, cc := range cases {
+		t.Run(cc.name, func(t *testing.T) {
+			logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+			us := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("hello"))
+			}))
+			defer us.Close()
+
+			pr, err := httptap.New(us.URL,
+				httptap.WithLogger(logger),
+				httptap.WithAsyncDispatch(1, 1, cc.policy),
+			)
+			if err != nil {
+				t.Fatalf("error creating proxy: %s", err)
+			}
+
+			// The tap blocks until released.
+			release := make(chan struct{})
+			var served atomic.Int64
+			pr.Tap([]string{"GET /"}, httptap.TapFunc(func(_ context.Context, rr *httptap.RequestResponse) {
+				<-release
+				served.Add(1)
+			}), httptap.WithResponseBody())
+
+			ps := httptest.NewServer(pr)
+			defer ps.Close()
+
+			const requests = 5
+			for i := 0; i < requests; i++ {
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					resp, err := http.Get(ps.URL)
+					if err != nil {
+						t.Errorf("get error: %s", err)
+						return
+					}
+					io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+				}()
+				// The slow tap must not delay the client.
+				select {
+				case <-done:
+				case <-time.After(2 * time.Second):
+					t.Fatalf("request %d blocked by tap", i)
+				}
+			}
+			close(release)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := pr.Flush(ctx); err != nil {
+				t.Fatalf("flush error: %s", err)
+			}
+			stats := pr.DispatchStats()
+			if stats.Queued != requests {
+				t.Errorf("queued: got %d, want %d", stats.Queued, requests)
+			}
+			if cc.wantDropped && stats.Dropped == 0 {
+				t.Errorf("expected dropped records")
+			}
+			if got := uint64(served.Load()); got != stats.Dispatched || got+stats.Dropped != requests {
+				t.Errorf("served %d, stats %+v", got, stats)
+			}
+		})
+	}
+}
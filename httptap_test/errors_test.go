@@ -0,0 +1,83 @@
+package httptap_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/myhops/httptap"
+)
+
+func TestUpstreamErrors(t *testing.T) {
+	const errorBody = `{"error":"bad gateway"}`
+
+	// An upstream that is gone.
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	// An upstream that drops the connection.
+	reset := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer reset.Close()
+
+	cases := []struct {
+		name     string
+		upstream string
+		wantKind httptap.ErrorKind
+	}{
+		{name: "dial", upstream: closed.URL, wantKind: httptap.ErrorDial},
+		{name: "reset", upstream: reset.URL, wantKind: httptap.ErrorUpstreamReset},
+	}
+	for _, cc := range cases {
+		t.Run(cc.name, func(t *testing.T) {
+			logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+			pr, err := httptap.New(cc.upstream,
+				httptap.WithLogger(logger),
+				httptap.WithErrorResponse("application/json", []byte(errorBody)),
+			)
+			if err != nil {
+				t.Fatalf("error creating proxy: %s", err)
+			}
+
+			records := make(chan *httptap.RequestResponse, 1)
+			pr.Tap([]string{"/"}, httptap.TapFunc(func(_ context.Context, rr *httptap.RequestResponse) {
+				records <- rr
+			}), httptap.WithRequestBody(), httptap.WithResponseBody(), httptap.WithResponseJSON())
+
+			ps := httptest.NewServer(pr)
+			defer ps.Close()
+
+			resp, err := http.Get(ps.URL + "/path")
+			if err != nil {
+				t.Fatalf("get error: %s", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusBadGateway {
+				t.Errorf("status: got %d, want %d", resp.StatusCode, http.StatusBadGateway)
+			}
+			if string(body) != errorBody {
+				t.Errorf("body: got %q, want %q", body, errorBody)
+			}
+
+			rr := <-records
+			if rr.Error == nil {
+				t.Fatalf("expected error on record")
+			}
+			if rr.ErrorKind != cc.wantKind {
+				t.Errorf("kind: got %s, want %s (%s)", rr.ErrorKind, cc.wantKind, rr.Error)
+			}
+			if rr.StatusCode != http.StatusBadGateway || rr.End.IsZero() {
+				t.Errorf("incomplete record: status %d, end %s", rr.StatusCode, rr.End)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package httptap_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/myhops/httptap"
+)
+
+func TestFaults(t *testing.T) {
+	body := strings.Repeat("x", 1000)
+	us := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer us.Close()
+
+	cases := []struct {
+		name     string
+		faults   httptap.Faults
+		wantKind httptap.FaultKind
+		check    func(t *testing.T, resp *http.Response, err error, d time.Duration)
+	}{
+		{
+			name: "latency",
+			faults: httptap.Faults{Latency: &httptap.LatencyFault{
+				Percentage: 100, Distribution: httptap.LatencyFixed, Delay: 50 * time.Millisecond,
+			}},
+			wantKind: httptap.FaultLatency,
+			check: func(t *testing.T, resp *http.Response, err error, d time.Duration) {
+				if err != nil || d < 50*time.Millisecond {
+					t.Errorf("got err %v after %s", err, d)
+				}
+			},
+		},
+		{
+			name: "error",
+			faults: httptap.Faults{Error: &httptap.ErrorFault{
+				Percentage: 100, StatusCode: http.StatusTooManyRequests, Body: []byte("slow down"),
+			}},
+			wantKind: httptap.FaultError,
+			check: func(t *testing.T, resp *http.Response, err error, _ time.Duration) {
+				if err != nil || resp.StatusCode != http.StatusTooManyRequests {
+					t.Fatalf("got err %v", err)
+				}
+				if b, _ := io.ReadAll(resp.Body); string(b) != "slow down" {
+					t.Errorf("got body %q", b)
+				}
+			},
+		},
+		{
+			name:     "abort",
+			faults:   httptap.Faults{Abort: &httptap.AbortFault{Percentage: 100}},
+			wantKind: httptap.FaultAbort,
+			check: func(t *testing.T, resp *http.Response, err error, _ time.Duration) {
+				if err == nil {
+					t.Errorf("expected error, got status %d", resp.StatusCode)
+				}
+			},
+		},
+		{
+			name:     "truncate",
+			faults:   httptap.Faults{Truncate: &httptap.TruncateFault{Percentage: 100, Bytes: 100}},
+			wantKind: httptap.FaultTruncate,
+			check: func(t *testing.T, resp *http.Response, err error, _ time.Duration) {
+				if err != nil {
+					t.Fatalf("got err %v", err)
+				}
+				b, err := io.ReadAll(resp.Body)
+				if err == nil || len(b) != 100 {
+					t.Errorf("got %d bytes, err %v", len(b), err)
+				}
+			},
+		},
+		{
+			name:   "truncate longer than body",
+			faults: httptap.Faults{Truncate: &httptap.TruncateFault{Percentage: 100, Bytes: 2000}},
+			check:  checkComplete(body),
+		},
+		{
+			name:   "truncate exact body",
+			faults: httptap.Faults{Truncate: &httptap.TruncateFault{Percentage: 100, Bytes: 1000}},
+			check:  checkComplete(body),
+		},
+		{
+			name: "truncate after error",
+			faults: httptap.Faults{
+				Error:    &httptap.ErrorFault{Percentage: 100, StatusCode: http.StatusTooManyRequests},
+				Truncate: &httptap.TruncateFault{Percentage: 100, Bytes: 1},
+			},
+			wantKind: httptap.FaultError,
+			check: func(t *testing.T, resp *http.Response, err error, _ time.Duration) {
+				if err != nil || resp.StatusCode != http.StatusTooManyRequests {
+					t.Fatalf("got err %v", err)
+				}
+			},
+		},
+	}
+	for _, cc := range cases {
+		t.Run(cc.name, func(t *testing.T) {
+			logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+			pr, err := httptap.New(us.URL, httptap.WithLogger(logger))
+			if err != nil {
+				t.Fatalf("error creating proxy: %s", err)
+			}
+			records := make(chan *httptap.RequestResponse, 1)
+			pr.Tap([]string{"/"}, httptap.TapFunc(func(_ context.Context, rr *httptap.RequestResponse) {
+				records <- rr
+			}), httptap.WithResponseBody(), httptap.WithFaults(cc.faults))
+
+			ps := httptest.NewServer(pr)
+			defer ps.Close()
+
+			start := time.Now()
+			resp, err := http.Get(ps.URL + "/path")
+			cc.check(t, resp, err, time.Since(start))
+			if resp != nil {
+				resp.Body.Close()
+			}
+
+			rr := <-records
+			switch {
+			case cc.wantKind == "":
+				if len(rr.Faults) != 0 {
+					t.Errorf("got faults %v, none happened", rr.Faults)
+				}
+			case len(rr.Faults) != 1 || rr.Faults[0].Kind != cc.wantKind:
+				t.Errorf("got faults %v", rr.Faults)
+			}
+			if rr.Method != http.MethodGet {
+				t.Errorf("request not recorded")
+			}
+		})
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	pr, _ := httptap.New(us.URL, httptap.WithLogger(logger))
+	pr.Tap([]string{"/"}, httptap.TapFunc(func(context.Context, *httptap.RequestResponse) {}),
+		httptap.WithFaults(httptap.Faults{Abort: &httptap.AbortFault{Percentage: 0}}))
+	ps := httptest.NewServer(pr)
+	defer ps.Close()
+	resp, err := http.Get(ps.URL)
+	if err != nil {
+		t.Fatalf("zero percent aborted: %s", err)
+	}
+	resp.Body.Close()
+}
+
+// checkComplete checks that the client got the complete body.
+func checkComplete(body string) func(t *testing.T, resp *http.Response, err error, _ time.Duration) {
+	return func(t *testing.T, resp *http.Response, err error, _ time.Duration) {
+		if err != nil {
+			t.Fatalf("got err %v", err)
+		}
+		b, err := io.ReadAll(resp.Body)
+		if err != nil || string(b) != body {
+			t.Errorf("got %d bytes, err %v", len(b), err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package httptap_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/myhops/httptap"
+)
+
+func TestFilter(t *testing.T) {
+	us := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer us.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	pr, err := httptap.New(us.URL, httptap.WithLogger(logger))
+	if err != nil {
+		t.Fatalf("error creating proxy: %s", err)
+	}
+	records := make(chan *httptap.RequestResponse, 10)
+	pr.Tap([]string{"/"}, httptap.TapFunc(func(_ context.Context, rr *httptap.RequestResponse) {
+		records <- rr
+	}),
+		httptap.WithRequestBody(),
+		httptap.WithFilter(`status >= 500 || reqHeader["X-Debug"] == "1" || reqBody.total > 1000`),
+	)
+	ps := httptest.NewServer(pr)
+
+	post := func(path, body string, header http.Header) {
+		req, _ := http.NewRequest(http.MethodPost, ps.URL+path, bytes.NewReader([]byte(body)))
+		req.Header = header
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("post error: %s", err)
+		}
+		resp.Body.Close()
+	}
+	post("/ok", `{"total":10}`, http.Header{})
+	post("/fail", `{"total":10}`, http.Header{})
+	post("/debug", `{"total":10}`, http.Header{"X-Debug": {"1"}})
+	post("/large", `{"total":5000}`, http.Header{})
+	ps.Close()
+	close(records)
+
+	var got []string
+	for rr := range records {
+		got = append(got, rr.URL.Path)
+	}
+	if len(got) != 3 || got[0] != "/fail" || got[1] != "/debug" || got[2] != "/large" {
+		t.Errorf("got records for %v", got)
+	}
+
+	if _, err := httptap.CompileFilter(`statuscode >= 500`); err == nil {
+		t.Errorf("expected error for unknown variable")
+	}
+}
@@ -0,0 +1,128 @@
+package httptap_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/myhops/httptap"
+	"github.com/myhops/httptap/metrics"
+)
+
+func TestMetrics(t *testing.T) {
+	us := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte("response"))
+	}))
+	defer us.Close()
+
+	reg := metrics.NewRegistry()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	pr, err := httptap.New(us.URL, httptap.WithLogger(logger), httptap.WithMetrics(reg))
+	if err != nil {
+		t.Fatalf("error creating proxy: %s", err)
+	}
+	var served atomic.Int32
+	pr.Tap([]string{"POST /orders"}, httptap.TapFunc(func(context.Context, *httptap.RequestResponse) {
+		panic("broken tap")
+	}), httptap.WithTapName("broken"))
+	pr.Tap([]string{"POST /orders"}, httptap.TapFunc(func(context.Context, *httptap.RequestResponse) {
+		served.Add(1)
+	}), httptap.WithTapName("counter"), httptap.WithPIIDetectors(httptap.PIIAnnotate, "email"))
+
+	// The upstream of this tap is not reachable.
+	down, err := httptap.New("http://127.0.0.1:1", httptap.WithLogger(logger), httptap.WithMetrics(reg))
+	if err != nil {
+		t.Fatalf("error creating proxy: %s", err)
+	}
+	down.Tap([]string{"GET /down"}, httptap.TapFunc(func(context.Context, *httptap.RequestResponse) {}))
+
+	ps := httptest.NewServer(pr)
+	ds := httptest.NewServer(down)
+
+	for range 2 {
+		resp, err := http.Post(ps.URL+"/orders?email=a@example.com", "text/plain", strings.NewReader("request"))
+		if err != nil {
+			t.Fatalf("post error: %s", err)
+		}
+		resp.Body.Close()
+	}
+	// A made up method is not a label value of its own.
+	req, _ := http.NewRequest("FROB", ps.URL+"/orders", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("frob error: %s", err)
+	}
+	resp.Body.Close()
+	resp, err = http.Get(ds.URL + "/down")
+	if err != nil {
+		t.Fatalf("get error: %s", err)
+	}
+	resp.Body.Close()
+	ps.Close()
+	ds.Close()
+
+	if n := served.Load(); n != 2 {
+		t.Errorf("the panic stopped the other tap, served %d", n)
+	}
+
+	var b strings.Builder
+	reg.WriteTo(&b)
+	out := b.String()
+	for _, want := range []string{
+		`httptap_requests_total{pattern="POST /orders",method="POST",status_class="2xx"} 2`,
+		`httptap_requests_total{pattern="GET /down",method="GET",status_class="5xx"} 1`,
+		`httptap_requests_total{pattern="/",method="other",status_class="2xx"} 1`,
+		`httptap_request_body_bytes_bucket{pattern="POST /orders",le="64"} 2`,
+		`httptap_response_body_bytes_sum{pattern="POST /orders"} 16`,
+		`httptap_request_duration_seconds_count{pattern="POST /orders"} 2`,
+		`httptap_upstream_errors_total{pattern="GET /down",kind="dial"} 1`,
+		`httptap_tap_panics_total{tap="broken"} 2`,
+		`httptap_tap_duration_seconds_count{tap="counter"} 2`,
+		`httptap_pii_findings_total{detector="email"} 2`,
+		`# TYPE httptap_bufpool_hits_total counter`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %s", want)
+		}
+	}
+	if t.Failed() {
+		t.Log(out)
+	}
+}
+
+// A reload creates a new proxy with the same registry, the counters continue.
+func TestMetricsAcrossProxies(t *testing.T) {
+	us := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer us.Close()
+
+	reg := metrics.NewRegistry()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for range 2 {
+		pr, err := httptap.New(us.URL, httptap.WithLogger(logger), httptap.WithMetrics(reg),
+			httptap.WithAsyncDispatch(10, 1, httptap.DropOldest))
+		if err != nil {
+			t.Fatalf("error creating proxy: %s", err)
+		}
+		pr.Tap([]string{"/"}, httptap.TapFunc(func(context.Context, *httptap.RequestResponse) {}))
+		ps := httptest.NewServer(pr)
+		resp, err := http.Get(ps.URL)
+		if err != nil {
+			t.Fatalf("get error: %s", err)
+		}
+		resp.Body.Close()
+		ps.Close()
+		pr.Flush(context.Background())
+	}
+
+	var b strings.Builder
+	reg.WriteTo(&b)
+	if want := "httptap_dispatch_queued_total 2\n"; !strings.Contains(b.String(), want) {
+		t.Errorf("missing %s in\n%s", want, b.String())
+	}
+}
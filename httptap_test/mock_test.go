@@ -0,0 +1,93 @@
+package httptap_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/myhops/httptap"
+)
+
+func TestMock(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	var upstreamCalls atomic.Int32
+	us := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+		w.Write([]byte("upstream"))
+	}))
+	defer us.Close()
+
+	pr, err := httptap.New(us.URL, httptap.WithLogger(logger))
+	if err != nil {
+		t.Fatalf("error creating proxy: %s", err)
+	}
+	records := make(chan *httptap.RequestResponse, 2)
+	pr.Tap([]string{"POST /users/{id}"}, httptap.TapFunc(func(_ context.Context, rr *httptap.RequestResponse) {
+		records <- rr
+	}),
+		httptap.WithRequestBody(),
+		httptap.WithResponseBody(),
+		httptap.WithResponseJSON(),
+		httptap.WithMock(httptap.Mock{
+			StatusCode: http.StatusCreated,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Template:   `{"id":"{{.PathValue "id"}}","name":{{json .JSON.name}},"q":"{{.Query.Get "q"}}"}`,
+		}),
+	)
+	pr.Tap([]string{"/"}, httptap.TapFunc(func(_ context.Context, rr *httptap.RequestResponse) {
+		records <- rr
+	}))
+
+	ps := httptest.NewServer(pr)
+	defer ps.Close()
+
+	resp, err := http.Post(ps.URL+"/users/42?q=x", "application/json", bytes.NewReader([]byte(`{"name":"peter"}`)))
+	if err != nil {
+		t.Fatalf("post error: %s", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("got status %d", resp.StatusCode)
+	}
+	var obj map[string]any
+	if err := json.Unmarshal(body, &obj); err != nil {
+		t.Fatalf("bad JSON %q: %s", body, err)
+	}
+	if obj["id"] != "42" || obj["name"] != "peter" || obj["q"] != "x" {
+		t.Errorf("got body %v", obj)
+	}
+	if n := upstreamCalls.Load(); n != 0 {
+		t.Errorf("upstream called %d times", n)
+	}
+
+	rr := <-records
+	if !rr.Mocked || rr.StatusCode != http.StatusCreated || rr.Method != http.MethodPost {
+		t.Errorf("got record mocked=%t status=%d method=%s", rr.Mocked, rr.StatusCode, rr.Method)
+	}
+	if rr.ReqBodySize != int64(len(`{"name":"peter"}`)) || rr.RespBodySize != int64(len(body)) {
+		t.Errorf("got sizes %d %d", rr.ReqBodySize, rr.RespBodySize)
+	}
+	if m, ok := rr.RespBodyJSON.(map[string]any); !ok || m["id"] != "42" {
+		t.Errorf("got response json %v", rr.RespBodyJSON)
+	}
+
+	// Other routes are proxied.
+	resp, err = http.Get(ps.URL + "/other")
+	if err != nil {
+		t.Fatalf("get error: %s", err)
+	}
+	resp.Body.Close()
+	if rr := <-records; rr.Mocked || upstreamCalls.Load() != 1 {
+		t.Errorf("request not proxied")
+	}
+}
@@ -0,0 +1,114 @@
+package httptap_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/myhops/httptap"
+)
+
+func TestMutation(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	type received struct {
+		body          map[string]any
+		contentLength int64
+		header        http.Header
+	}
+	upstreamGot := make(chan received, 1)
+
+	// The upstream returns a gzipped JSON response.
+	us := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		var obj map[string]any
+		json.Unmarshal(b, &obj)
+		upstreamGot <- received{body: obj, contentLength: r.ContentLength, header: r.Header.Clone()}
+		if int64(len(b)) != r.ContentLength {
+			t.Errorf("upstream: content length %d, body %d", r.ContentLength, len(b))
+		}
+
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write([]byte(`{"status":"ok","internal":{"debug":true},"count":1}`))
+		zw.Close()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("X-Upstream", "secret")
+		w.Write(buf.Bytes())
+	}))
+	defer us.Close()
+
+	pr, err := httptap.New(us.URL, httptap.WithLogger(logger))
+	if err != nil {
+		t.Fatalf("error creating proxy: %s", err)
+	}
+	records := make(chan *httptap.RequestResponse, 1)
+	pr.Tap([]string{"POST /"}, httptap.TapFunc(func(_ context.Context, rr *httptap.RequestResponse) {
+		records <- rr
+	}),
+		httptap.WithRequestBody(),
+		httptap.WithRequestJSON(),
+		httptap.WithRequestMutation(httptap.Mutation{
+			BodyPatch:    []byte(`[{"op":"add","path":"/injected","value":"a longer value than before"}]`),
+			SetHeader:    http.Header{"X-Test": {"mutated"}},
+			RemoveHeader: []string{"X-Remove"},
+		}),
+		httptap.WithResponseMutation(httptap.Mutation{
+			BodyMergePatch: []byte(`{"internal":null,"count":2}`),
+			RemoveHeader:   []string{"X-Upstream"},
+		}),
+	)
+
+	ps := httptest.NewServer(pr)
+	defer ps.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, ps.URL, bytes.NewReader([]byte(`{"name":"client"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Remove", "gone")
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("post error: %s", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// The upstream got the mutated request.
+	got := <-upstreamGot
+	if got.body["injected"] != "a longer value than before" || got.body["name"] != "client" {
+		t.Errorf("upstream got body %v", got.body)
+	}
+	if got.header.Get("X-Test") != "mutated" || got.header.Get("X-Remove") != "" {
+		t.Errorf("upstream got headers %v", got.header)
+	}
+
+	// The client got the mutated, decoded response.
+	if resp.Header.Get("Content-Encoding") != "" || resp.Header.Get("X-Upstream") != "" {
+		t.Errorf("client got headers %v", resp.Header)
+	}
+	if cl := resp.Header.Get("Content-Length"); cl != strconv.Itoa(len(body)) {
+		t.Errorf("content length %s, body %d", cl, len(body))
+	}
+	var obj map[string]any
+	if err := json.Unmarshal(body, &obj); err != nil {
+		t.Fatalf("client got bad JSON %q: %s", body, err)
+	}
+	if _, ok := obj["internal"]; ok || obj["count"] != 2.0 || obj["status"] != "ok" {
+		t.Errorf("client got body %v", obj)
+	}
+
+	// The tap sees the request as it was sent.
+	rr := <-records
+	if m, ok := rr.ReqBodyJSON.(map[string]any); !ok || m["injected"] == nil {
+		t.Errorf("tap got request %v", rr.ReqBodyJSON)
+	}
+}
@@ -0,0 +1,75 @@
+package httptap_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/myhops/httptap"
+)
+
+func TestNetworkConditions(t *testing.T) {
+	const size = 4000
+	us := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		w.Write(b)
+	}))
+	defer us.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	pr, err := httptap.New(us.URL, httptap.WithLogger(logger))
+	if err != nil {
+		t.Fatalf("error creating proxy: %s", err)
+	}
+	records := make(chan *httptap.RequestResponse, 1)
+	pr.Tap([]string{"/"}, httptap.TapFunc(func(_ context.Context, rr *httptap.RequestResponse) {
+		records <- rr
+	}), httptap.WithResponseBody(), httptap.WithNetworkConditions(httptap.NetworkConditions{
+		UploadBytesPerSecond:   20000,
+		DownloadBytesPerSecond: 20000,
+		ChunkSize:              500,
+	}))
+	ps := httptest.NewServer(pr)
+	defer ps.Close()
+
+	// Count the reads of the client, the response is flushed per chunk.
+	start := time.Now()
+	resp, err := http.Post(ps.URL, "text/plain", bytes.NewReader(bytes.Repeat([]byte("x"), size)))
+	if err != nil {
+		t.Fatalf("post error: %s", err)
+	}
+	defer resp.Body.Close()
+	reads := 0
+	total := 0
+	buf := make([]byte, size)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			reads++
+			total += n
+		}
+		if err != nil {
+			break
+		}
+	}
+	d := time.Since(start)
+
+	if total != size {
+		t.Errorf("got %d bytes, want %d", total, size)
+	}
+	// 4000 bytes up and down at 20000 bytes per second take 400ms.
+	if d < 350*time.Millisecond {
+		t.Errorf("exchange took %s, expected about 400ms", d)
+	}
+	if reads < 2 {
+		t.Errorf("response read in %d chunks", reads)
+	}
+	if rr := <-records; rr.RespBodySize != size {
+		t.Errorf("recorded %d bytes", rr.RespBodySize)
+	}
+}
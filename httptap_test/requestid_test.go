@@ -0,0 +1,109 @@
+package httptap_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/myhops/httptap"
+)
+
+func TestRequestID(t *testing.T) {
+	upstreamIDs := make(chan string, 1)
+	us := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Correlation-Id")
+		upstreamIDs <- id
+		// Echo the id like many services do.
+		w.Header().Set("X-Correlation-Id", id)
+	}))
+	defer us.Close()
+
+	tests := []struct {
+		name     string
+		format   httptap.RequestIDFormat
+		incoming string
+		want     *regexp.Regexp
+	}{
+		{name: "incoming", incoming: "abc-123", want: regexp.MustCompile(`^abc-123$`)},
+		{name: "uuidv7", format: httptap.RequestIDUUIDv7,
+			want: regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)},
+		{name: "ulid", format: httptap.RequestIDULID,
+			want: regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+			pr, err := httptap.New(us.URL, httptap.WithLogger(logger),
+				httptap.WithRequestID("x-correlation-id", tt.format))
+			if err != nil {
+				t.Fatalf("error creating proxy: %s", err)
+			}
+			records := make(chan *httptap.RequestResponse, 1)
+			pr.Tap([]string{"/"}, httptap.TapFunc(func(ctx context.Context, rr *httptap.RequestResponse) {
+				httptap.RequestContextValue(ctx).Logger.Info("tap called")
+				records <- rr
+			}))
+			ps := httptest.NewServer(pr)
+			defer ps.Close()
+
+			req, _ := http.NewRequest(http.MethodGet, ps.URL, nil)
+			if tt.incoming != "" {
+				req.Header.Set("X-Correlation-Id", tt.incoming)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("get error: %s", err)
+			}
+			resp.Body.Close()
+
+			id := <-upstreamIDs
+			if !tt.want.MatchString(id) {
+				t.Errorf("upstream got id %q", id)
+			}
+			if got := resp.Header.Values("X-Correlation-Id"); len(got) != 1 || got[0] != id {
+				t.Errorf("client got ids %v, want %q", got, id)
+			}
+			rr := <-records
+			if rr.RequestID != id || rr.RespHeader.Get("X-Correlation-Id") != id {
+				t.Errorf("record has id %q", rr.RequestID)
+			}
+			for _, line := range strings.Split(logs.String(), "\n") {
+				if strings.Contains(line, "tap called") && !strings.Contains(line, "request_id="+id) {
+					t.Errorf("log line without request id: %s", line)
+				}
+			}
+		})
+	}
+
+	if _, err := httptap.New(us.URL, httptap.WithRequestID("", "uuidv4")); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+}
+
+func TestRequestIDInMock(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	pr, err := httptap.New("http://localhost:1", httptap.WithLogger(logger), httptap.WithRequestID("", ""))
+	if err != nil {
+		t.Fatalf("error creating proxy: %s", err)
+	}
+	pr.Tap([]string{"/"}, httptap.TapFunc(func(context.Context, *httptap.RequestResponse) {}),
+		httptap.WithMock(httptap.Mock{StatusCode: http.StatusOK, Body: []byte("mocked")}))
+	ps := httptest.NewServer(pr)
+	defer ps.Close()
+
+	resp, err := http.Get(ps.URL)
+	if err != nil {
+		t.Fatalf("get error: %s", err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get("X-Request-Id") == "" {
+		t.Errorf("mock response has no request id")
+	}
+}
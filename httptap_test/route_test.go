@@ -0,0 +1,169 @@
+package httptap_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/myhops/httptap"
+)
+
+func TestTapsOnSamePattern(t *testing.T) {
+	const respBody = `{"name":"response","secret":"s3cr3t"}`
+	us := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(respBody))
+	}))
+	defer us.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	pr, err := httptap.New(us.URL, httptap.WithLogger(logger))
+	if err != nil {
+		t.Fatalf("error creating proxy: %s", err)
+	}
+
+	type record struct {
+		tap      string
+		reqBody  string
+		respBody string
+		respJSON any
+		rr       *httptap.RequestResponse
+	}
+	records := make(chan record, 10)
+	tapFunc := func(name string) httptap.Tap {
+		return httptap.TapFunc(func(_ context.Context, rr *httptap.RequestResponse) {
+			rec := record{tap: name, respJSON: rr.RespBodyJSON, rr: rr}
+			if rr.ReqBody != nil {
+				rec.reqBody = rr.ReqBody.String()
+			}
+			if rr.RespBody != nil {
+				rec.respBody = rr.RespBody.String()
+			}
+			records <- rec
+		})
+	}
+
+	// Two taps on the same pattern with their own options.
+	pr.Tap([]string{"POST /"}, tapFunc("full"),
+		httptap.WithRequestBody(),
+		httptap.WithResponseBody(),
+		httptap.WithResponseJSON(),
+	)
+	pr.Tap([]string{"POST /"}, tapFunc("limited"),
+		httptap.WithRequestBody(),
+		httptap.WithMaxRequestBodyBytes(4),
+		httptap.WithRedaction(httptap.RedactRule{Path: "$.secret", Action: httptap.RedactMask}),
+		httptap.WithResponseBody(),
+		httptap.WithExcludeHeaders([]string{"Content-Type"}),
+	)
+	pr.Tap([]string{"POST /"}, tapFunc("none"))
+
+	ps := httptest.NewServer(pr)
+	resp, err := http.Post(ps.URL, "text/plain", bytes.NewReader([]byte("request body")))
+	if err != nil {
+		t.Fatalf("post error: %s", err)
+	}
+	resp.Body.Close()
+	ps.Close()
+	close(records)
+
+	var got []record
+	for r := range records {
+		got = append(got, r)
+	}
+	if len(got) != 3 || got[0].tap != "full" || got[1].tap != "limited" || got[2].tap != "none" {
+		t.Fatalf("got %d records", len(got))
+	}
+
+	full, limited, none := got[0], got[1], got[2]
+	if full.reqBody != "request body" || full.respBody != respBody || full.respJSON == nil {
+		t.Errorf("full: got %q %q %v", full.reqBody, full.respBody, full.respJSON)
+	}
+	if full.rr.RespHeader.Get("Content-Type") == "" {
+		t.Errorf("full: header removed by other tap")
+	}
+	// The redaction withholds the text/plain body, the size is still recorded.
+	if limited.reqBody != "" || !limited.rr.ReqBodyTruncated || limited.rr.ReqBodySize != 12 {
+		t.Errorf("limited: got %q truncated %t", limited.reqBody, limited.rr.ReqBodyTruncated)
+	}
+	if bytes.Contains([]byte(limited.respBody), []byte("s3cr3t")) || limited.respJSON != nil {
+		t.Errorf("limited: got %q", limited.respBody)
+	}
+	if limited.rr.RespHeader.Get("Content-Type") != "" {
+		t.Errorf("limited: header not removed")
+	}
+	if none.reqBody != "" || none.respBody != "" || none.rr.RespBodySize != int64(len(respBody)) {
+		t.Errorf("none: got bodies")
+	}
+}
+
+func TestPatternAndPathValues(t *testing.T) {
+	us := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer us.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	pr, err := httptap.New(us.URL, httptap.WithLogger(logger))
+	if err != nil {
+		t.Fatalf("error creating proxy: %s", err)
+	}
+	records := make(chan *httptap.RequestResponse, 1)
+	pr.Tap([]string{"GET /orders/{id}/items/{rest...}"}, httptap.TapFunc(func(_ context.Context, rr *httptap.RequestResponse) {
+		records <- rr
+	}), httptap.WithTapName("orders"))
+
+	ps := httptest.NewServer(pr)
+	defer ps.Close()
+	resp, err := http.Get(ps.URL + "/orders/42/items/a/b")
+	if err != nil {
+		t.Fatalf("get error: %s", err)
+	}
+	resp.Body.Close()
+
+	rr := <-records
+	if rr.Pattern != "GET /orders/{id}/items/{rest...}" || rr.TapName != "orders" {
+		t.Errorf("got pattern %q, tap %q", rr.Pattern, rr.TapName)
+	}
+	if rr.PathValues["id"] != "42" || rr.PathValues["rest"] != "a/b" || len(rr.PathValues) != 2 {
+		t.Errorf("got path values %v", rr.PathValues)
+	}
+}
+
+func TestAddTapErrors(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	pr, err := httptap.New("http://localhost", httptap.WithLogger(logger))
+	if err != nil {
+		t.Fatalf("error creating proxy: %s", err)
+	}
+	nop := httptap.TapFunc(func(context.Context, *httptap.RequestResponse) {})
+
+	// A tap must not run without the redaction it is configured with.
+	err = pr.AddTap([]string{"/"}, nop,
+		httptap.WithRedaction(httptap.RedactRule{Path: "secret", Action: httptap.RedactMask}))
+	var oe *httptap.OptionError
+	if !errors.As(err, &oe) || oe.Option != "redaction" {
+		t.Errorf("bad rule: got %v, want a redaction error", err)
+	}
+	err = pr.AddTap([]string{"/"}, nop, httptap.WithPIIDetectors(httptap.PIIMask, "shoesize"))
+	if !errors.As(err, &oe) || oe.Option != "pii detectors" {
+		t.Errorf("unknown detector: got %v, want a pii detectors error", err)
+	}
+	err = pr.AddTap([]string{"/"}, nop, httptap.WithRedactionKey(nil))
+	if !errors.As(err, &oe) || oe.Option != "redaction key" {
+		t.Errorf("empty key: got %v, want a redaction key error", err)
+	}
+
+	if err := pr.AddTap([]string{"GET /a/{x}"}, nop); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := pr.AddTap([]string{"GET /a/{x}"}, nop); err != nil {
+		t.Errorf("same pattern: unexpected error: %s", err)
+	}
+	if err := pr.AddTap([]string{"GET /a/{y}"}, nop); err == nil {
+		t.Errorf("conflicting pattern: expected error")
+	}
+}
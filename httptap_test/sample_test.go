@@ -0,0 +1,152 @@
+package httptap_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/myhops/httptap"
+)
+
+func TestSampling(t *testing.T) {
+	us := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/fail":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/slow":
+			time.Sleep(60 * time.Millisecond)
+		}
+		w.Write([]byte("response"))
+	}))
+	defer us.Close()
+
+	// The bodies are released after the tap returns.
+	type record struct {
+		*httptap.RequestResponse
+		hasBody bool
+	}
+	newProxy := func(t *testing.T, options ...httptap.TapOptions) (*httptest.Server, chan record) {
+		logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+		pr, err := httptap.New(us.URL, httptap.WithLogger(logger))
+		if err != nil {
+			t.Fatalf("error creating proxy: %s", err)
+		}
+		records := make(chan record, 100)
+		opts := httptap.TapOptions{httptap.WithRequestBody(), httptap.WithResponseBody()}
+		for _, o := range options {
+			opts = append(opts, o...)
+		}
+		pr.Tap([]string{"/"}, httptap.TapFunc(func(_ context.Context, rr *httptap.RequestResponse) {
+			records <- record{rr, rr.ReqBody != nil && rr.RespBody != nil}
+		}), opts...)
+		return httptest.NewServer(pr), records
+	}
+	do := func(t *testing.T, url string, header http.Header) {
+		req, _ := http.NewRequest(http.MethodPost, url, bytes.NewReader([]byte("request")))
+		req.Header = header
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("post error: %s", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	t.Run("trace", func(t *testing.T) {
+		ps, records := newProxy(t, httptap.TapOptions{httptap.WithSampleRate(0.5)})
+
+		// Two hops of each trace.
+		for i := range 40 {
+			traceID := fmt.Sprintf("%032x", i)
+			header := http.Header{"Traceparent": {"00-" + traceID + "-00f067aa0ba902b7-01"}}
+			do(t, ps.URL, header)
+			do(t, ps.URL, header)
+		}
+		// Close waits for the handlers.
+		ps.Close()
+		close(records)
+
+		perTrace := map[string]int{}
+		for rr := range records {
+			if !rr.Sampled || !rr.hasBody {
+				t.Errorf("sampled record without body")
+			}
+			perTrace[rr.ReqHeader.Get("Traceparent")]++
+		}
+		for tp, n := range perTrace {
+			if n != 2 {
+				t.Errorf("trace %s: kept %d of 2 hops", tp, n)
+			}
+		}
+		if len(perTrace) == 0 || len(perTrace) == 40 {
+			t.Errorf("kept %d of 40 traces", len(perTrace))
+		}
+	})
+
+	t.Run("request id", func(t *testing.T) {
+		logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+		pr, err := httptap.New(us.URL, httptap.WithLogger(logger),
+			httptap.WithRequestID("X-Correlation-Id", ""))
+		if err != nil {
+			t.Fatalf("error creating proxy: %s", err)
+		}
+		records := make(chan string, 100)
+		pr.Tap([]string{"/"}, httptap.TapFunc(func(_ context.Context, rr *httptap.RequestResponse) {
+			records <- rr.RequestID
+		}), httptap.WithSampleRate(0.5))
+		ps := httptest.NewServer(pr)
+
+		// Two hops of each request, keyed on the configured header.
+		for i := range 40 {
+			header := http.Header{"X-Correlation-Id": {fmt.Sprintf("req-%d", i)}}
+			do(t, ps.URL, header)
+			do(t, ps.URL, header)
+		}
+		ps.Close()
+		close(records)
+
+		perID := map[string]int{}
+		for id := range records {
+			perID[id]++
+		}
+		for id, n := range perID {
+			if n != 2 {
+				t.Errorf("request %s: kept %d of 2 hops", id, n)
+			}
+		}
+		if len(perID) == 0 || len(perID) == 40 {
+			t.Errorf("kept %d of 40 requests", len(perID))
+		}
+	})
+
+	t.Run("keep", func(t *testing.T) {
+		ps, records := newProxy(t, httptap.TapOptions{
+			httptap.WithSampleRate(0),
+			httptap.WithSampleKeep(true, 50*time.Millisecond),
+		})
+
+		do(t, ps.URL+"/ok", nil)
+		do(t, ps.URL+"/fail", nil)
+		do(t, ps.URL+"/slow", nil)
+		ps.Close()
+
+		for _, want := range []string{"/fail", "/slow"} {
+			rr := <-records
+			if rr.URL.Path != want || rr.Sampled {
+				t.Errorf("got %s sampled %t, want %s", rr.URL.Path, rr.Sampled, want)
+			}
+			if rr.hasBody || rr.RespBodySize != int64(len("response")) {
+				t.Errorf("unsampled record has bodies")
+			}
+		}
+		if len(records) != 0 {
+			t.Errorf("got %d records too many", len(records))
+		}
+	})
+}
@@ -0,0 +1,99 @@
+package httptap_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/myhops/httptap"
+)
+
+func TestTapControl(t *testing.T) {
+	us := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("response"))
+	}))
+	defer us.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	pr, err := httptap.New(us.URL, httptap.WithLogger(logger))
+	if err != nil {
+		t.Fatalf("error creating proxy: %s", err)
+	}
+	type record struct {
+		tap      string
+		respBody string
+	}
+	records := make(chan record, 10)
+	tapFunc := func(name string) httptap.Tap {
+		return httptap.TapFunc(func(_ context.Context, rr *httptap.RequestResponse) {
+			rec := record{tap: name}
+			if rr.RespBody != nil {
+				rec.respBody = rr.RespBody.String()
+			}
+			records <- rec
+		})
+	}
+	pr.Tap([]string{"GET /", "POST /"}, tapFunc("first"), httptap.WithTapName("first"))
+	pr.Tap([]string{"GET /"}, tapFunc("second"), httptap.WithTapName("second"),
+		httptap.WithFilter(`status == 200`), httptap.WithSampleRate(0.5))
+
+	taps := pr.Taps()
+	if len(taps) != 2 || taps[0].Name != "first" || len(taps[0].Patterns) != 2 || !taps[0].Enabled ||
+		taps[0].RequestBody || taps[1].Filter != "status == 200" || taps[1].SampleRate != 0.5 {
+		t.Fatalf("got taps %+v", taps)
+	}
+
+	ps := httptest.NewServer(pr)
+	defer ps.Close()
+	get := func() []record {
+		resp, err := http.Get(ps.URL)
+		if err != nil {
+			t.Fatalf("get error: %s", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		var res []record
+		for {
+			select {
+			case r := <-records:
+				res = append(res, r)
+			default:
+				return res
+			}
+		}
+	}
+
+	// The disabled first tap still proxies the request.
+	if err := pr.SetTapEnabled("second", false); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if err := pr.SetTapEnabled("first", false); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if got := get(); len(got) != 0 {
+		t.Errorf("disabled taps got records %v", got)
+	}
+
+	yes := true
+	pr.SetTapEnabled("first", true)
+	if err := pr.SetTapBodyCapture("first", nil, &yes); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	got := get()
+	if len(got) != 1 || got[0].tap != "first" || got[0].respBody != "response" {
+		t.Errorf("got records %v", got)
+	}
+	if ti := pr.Taps()[0]; ti.RequestBody || !ti.ResponseBody {
+		t.Errorf("got capture %t %t", ti.RequestBody, ti.ResponseBody)
+	}
+
+	if err := pr.SetTapEnabled("unknown", true); !errors.Is(err, httptap.ErrTapNotFound) ||
+		!strings.Contains(err.Error(), "unknown") {
+		t.Errorf("got error %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package httptap_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/myhops/httptap"
+)
+
+func TestTiming(t *testing.T) {
+	const delay = 30 * time.Millisecond
+	us := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		w.Write([]byte("first part"))
+		w.(http.Flusher).Flush()
+		time.Sleep(delay)
+		w.Write([]byte("second part"))
+	}))
+	defer us.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	pr, err := httptap.New(us.URL, httptap.WithLogger(logger))
+	if err != nil {
+		t.Fatalf("error creating proxy: %s", err)
+	}
+	records := make(chan *httptap.RequestResponse, 2)
+	pr.Tap([]string{"/"}, httptap.TapFunc(func(_ context.Context, rr *httptap.RequestResponse) {
+		records <- rr
+	}))
+	ps := httptest.NewServer(pr)
+	defer ps.Close()
+
+	for i := range 2 {
+		resp, err := http.Get(ps.URL)
+		if err != nil {
+			t.Fatalf("get error: %s", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		tm := (<-records).Timing
+		if tm == nil {
+			t.Fatalf("no timing")
+		}
+		if tm.Wait < delay || tm.TimeToFirstByte < tm.Wait {
+			t.Errorf("wait %s, time to first byte %s", tm.Wait, tm.TimeToFirstByte)
+		}
+		if tm.BodyTransfer < delay {
+			t.Errorf("body transfer %s", tm.BodyTransfer)
+		}
+		// The second request reuses the connection to the upstream.
+		if reused := i == 1; tm.ConnReused != reused || (tm.Connect == 0) == !reused {
+			t.Errorf("request %d: reused %t, connect %s", i, tm.ConnReused, tm.Connect)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+// Package jsonpath implements a subset of JSONPath to select values in
+// documents decoded from JSON.
+//
+// Supported are the root $, child members .name and ['name'], the wildcard
+// .* and [*], array indexes [n] (negative counts from the end) and
+// recursive descent ..name, ..* and ..[n].
+package jsonpath
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type stepKind int
+
+const (
+	stepChild stepKind = iota
+	stepWildcard
+	stepIndex
+)
+
+type step struct {
+	kind      stepKind
+	name      string
+	index     int
+	recursive bool
+}
+
+// Path is a compiled JSONPath.
+type Path struct {
+	src   string
+	steps []step
+}
+
+// String returns the source of the path.
+func (p *Path) String() string {
+	return p.src
+}
+
+// Compile parses a JSONPath.
+func Compile(src string) (*Path, error) {
+	s := strings.TrimSpace(src)
+	if !strings.HasPrefix(s, "$") {
+		return nil, fmt.Errorf("jsonpath %q: must start with $", src)
+	}
+	s = s[1:]
+	p := &Path{src: src}
+	for s != "" {
+		recursive := false
+		switch {
+		case strings.HasPrefix(s, ".."):
+			recursive = true
+			s = s[2:]
+		case s[0] == '.':
+			s = s[1:]
+		case s[0] == '[':
+		default:
+			return nil, fmt.Errorf("jsonpath %q: unexpected %q", src, s)
+		}
+		var st step
+		var err error
+		if strings.HasPrefix(s, "[") {
+			st, s, err = parseBracket(s)
+			if err != nil {
+				return nil, fmt.Errorf("jsonpath %q: %w", src, err)
+			}
+		} else {
+			st, s, err = parseName(s)
+			if err != nil {
+				return nil, fmt.Errorf("jsonpath %q: %w", src, err)
+			}
+		}
+		st.recursive = recursive
+		p.steps = append(p.steps, st)
+	}
+	if len(p.steps) == 0 {
+		return nil, fmt.Errorf("jsonpath %q: selects the root", src)
+	}
+	return p, nil
+}
+
+// MustCompile is like Compile but panics on errors.
+func MustCompile(src string) *Path {
+	p, err := Compile(src)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func parseName(s string) (step, string, error) {
+	i := strings.IndexAny(s, ".[")
+	if i < 0 {
+		i = len(s)
+	}
+	name := s[:i]
+	switch name {
+	case "":
+		return step{}, "", fmt.Errorf("missing member name")
+	case "*":
+		return step{kind: stepWildcard}, s[i:], nil
+	}
+	return step{kind: stepChild, name: name}, s[i:], nil
+}
+
+func parseBracket(s string) (step, string, error) {
+	end := strings.IndexByte(s, ']')
+	if end < 0 {
+		return step{}, "", fmt.Errorf("missing ]")
+	}
+	sel := strings.TrimSpace(s[1:end])
+	rest := s[end+1:]
+	switch {
+	case sel == "*":
+		return step{kind: stepWildcard}, rest, nil
+	case len(sel) >= 2 && (sel[0] == '\'' || sel[0] == '"') && sel[len(sel)-1] == sel[0]:
+		return step{kind: stepChild, name: sel[1 : len(sel)-1]}, rest, nil
+	}
+	n, err := strconv.Atoi(sel)
+	if err != nil {
+		return step{}, "", fmt.Errorf("bad selector [%s]", sel)
+	}
+	return step{kind: stepIndex, index: n}, rest, nil
+}
+
+// Func is called for each selected value. It returns the replacement
+// of the value, or remove true to remove the value from its parent.
+type Func func(v any) (replacement any, remove bool)
+
+// Apply calls fn for the values in doc selected by the path and returns the
+// updated document. Maps are updated in place, slices are replaced when
+// elements are removed.
+func (p *Path) Apply(doc any, fn Func) any {
+	res, _ := apply(doc, p.steps, fn)
+	return res
+}
+
+func apply(v any, steps []step, fn Func) (any, bool) {
+	if len(steps) == 0 {
+		return fn(v)
+	}
+	st := steps[0]
+	v = applyStep(v, st, steps[1:], fn)
+	if st.recursive {
+		v = descend(v, steps, fn)
+	}
+	return v, false
+}
+
+// descend applies the recursive steps to the children of v.
+func descend(v any, steps []step, fn Func) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, c := range t {
+			t[k], _ = apply(c, steps, fn)
+		}
+	case []any:
+		for i, c := range t {
+			t[i], _ = apply(c, steps, fn)
+		}
+	}
+	return v
+}
+
+// applyStep applies st to v and the remaining steps to the selected children.
+func applyStep(v any, st step, rest []step, fn Func) any {
+	switch t := v.(type) {
+	case map[string]any:
+		switch st.kind {
+		case stepChild:
+			if c, ok := t[st.name]; ok {
+				applyMember(t, st.name, c, rest, fn)
+			}
+		case stepWildcard:
+			for k, c := range t {
+				applyMember(t, k, c, rest, fn)
+			}
+		}
+		return t
+	case []any:
+		switch st.kind {
+		case stepIndex:
+			i := st.index
+			if i < 0 {
+				i += len(t)
+			}
+			if i < 0 || i >= len(t) {
+				return t
+			}
+			nv, remove := apply(t[i], rest, fn)
+			if remove {
+				return append(t[:i:i], t[i+1:]...)
+			}
+			t[i] = nv
+		case stepWildcard:
+			res := t[:0:0]
+			for _, c := range t {
+				nv, remove := apply(c, rest, fn)
+				if !remove {
+					res = append(res, nv)
+				}
+			}
+			return res
+		}
+		return t
+	}
+	return v
+}
+
+func applyMember(m map[string]any, k string, c any, rest []step, fn Func) {
+	nv, remove := apply(c, rest, fn)
+	if remove {
+		delete(m, k)
+		return
+	}
+	m[k] = nv
+}
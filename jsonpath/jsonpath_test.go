@@ -0,0 +1,94 @@
+package jsonpath
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	good := []string{
+		"$.a",
+		"$.a.b",
+		"$..email",
+		"$.items[*].iban",
+		"$['a b'][0]",
+		"$.list[-1]",
+		"$..*",
+	}
+	for _, src := range good {
+		if _, err := Compile(src); err != nil {
+			t.Errorf("%s: unexpected error: %s", src, err)
+		}
+	}
+	bad := []string{"", "a.b", "$", "$.", "$[x]", "$[0", "$a"}
+	for _, src := range bad {
+		if _, err := Compile(src); err == nil {
+			t.Errorf("%s: expected error", src)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	const doc = `{
+		"email": "a@example.com",
+		"user": {"email": "b@example.com", "name": "b"},
+		"items": [
+			{"iban": "NL91ABNA0417164300", "amount": 1},
+			{"amount": 2},
+			{"iban": "DE89370400440532013000", "amount": 3}
+		]
+	}`
+	mask := func(v any) (any, bool) { return "***", false }
+	remove := func(v any) (any, bool) { return nil, true }
+
+	cases := []struct {
+		name string
+		path string
+		fn   Func
+		want string
+	}{
+		{
+			name: "recursive mask",
+			path: "$..email",
+			fn:   mask,
+			want: `{"email":"***","user":{"email":"***","name":"b"},"items":[{"iban":"NL91ABNA0417164300","amount":1},{"amount":2},{"iban":"DE89370400440532013000","amount":3}]}`,
+		},
+		{
+			name: "wildcard with missing members",
+			path: "$.items[*].iban",
+			fn:   mask,
+			want: `{"email":"a@example.com","user":{"email":"b@example.com","name":"b"},"items":[{"iban":"***","amount":1},{"amount":2},{"iban":"***","amount":3}]}`,
+		},
+		{
+			name: "remove index",
+			path: "$.items[1]",
+			fn:   remove,
+			want: `{"email":"a@example.com","user":{"email":"b@example.com","name":"b"},"items":[{"iban":"NL91ABNA0417164300","amount":1},{"iban":"DE89370400440532013000","amount":3}]}`,
+		},
+		{
+			name: "remove member",
+			path: "$.user['name']",
+			fn:   remove,
+			want: `{"email":"a@example.com","user":{"email":"b@example.com"},"items":[{"iban":"NL91ABNA0417164300","amount":1},{"amount":2},{"iban":"DE89370400440532013000","amount":3}]}`,
+		},
+		{
+			name: "missing path",
+			path: "$.nothing.here",
+			fn:   remove,
+			want: doc,
+		},
+	}
+	for _, cc := range cases {
+		t.Run(cc.name, func(t *testing.T) {
+			var in, want any
+			json.Unmarshal([]byte(doc), &in)
+			json.Unmarshal([]byte(cc.want), &want)
+			got := MustCompile(cc.path).Apply(in, cc.fn)
+			if !reflect.DeepEqual(got, want) {
+				b, _ := json.Marshal(got)
+				t.Errorf("got %s", b)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package httptap
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/myhops/httptap/bufpool"
+	"github.com/myhops/httptap/metrics"
+	"github.com/myhops/httptap/pii"
+)
+
+// proxyMetrics are the metrics of the proxy and its taps.
+// The methods do nothing on a nil *proxyMetrics.
+type proxyMetrics struct {
+	requests       *metrics.CounterVec
+	duration       *metrics.HistogramVec
+	reqBodySize    *metrics.HistogramVec
+	respBodySize   *metrics.HistogramVec
+	upstreamErrors *metrics.CounterVec
+	tapDuration    *metrics.HistogramVec
+	tapPanics      *metrics.CounterVec
+	piiFindings    *metrics.CounterVec
+	// The dispatch counters are in the registry, a new proxy continues them.
+	dispatchQueued  *metrics.Counter
+	dispatchDropped *metrics.Counter
+}
+
+func newProxyMetrics(reg *metrics.Registry) *proxyMetrics {
+	m := &proxyMetrics{
+		requests: reg.Counter("httptap_requests_total",
+			"Requests by pattern, method and status class.", "pattern", "method", "status_class"),
+		duration: reg.Histogram("httptap_request_duration_seconds",
+			"Time until the response header is received.", metrics.DurationBuckets, "pattern"),
+		reqBodySize: reg.Histogram("httptap_request_body_bytes",
+			"Size of the request bodies.", metrics.SizeBuckets, "pattern"),
+		respBodySize: reg.Histogram("httptap_response_body_bytes",
+			"Size of the response bodies.", metrics.SizeBuckets, "pattern"),
+		upstreamErrors: reg.Counter("httptap_upstream_errors_total",
+			"Failed exchanges with the upstream by error kind.", "pattern", "kind"),
+		tapDuration: reg.Histogram("httptap_tap_duration_seconds",
+			"Time a tap takes to serve a record.", metrics.DurationBuckets, "tap"),
+		tapPanics: reg.Counter("httptap_tap_panics_total",
+			"Panics recovered from the taps.", "tap"),
+		piiFindings: reg.Counter("httptap_pii_findings_total",
+			"Sensitive values found by the PII detectors.", "detector"),
+		dispatchQueued: reg.Counter("httptap_dispatch_queued_total",
+			"Records queued for the taps.").With(),
+		dispatchDropped: reg.Counter("httptap_dispatch_dropped_total",
+			"Records dropped because the queue was full.").With(),
+	}
+
+	// The pool is shared by all proxies, its counters do not restart with a new proxy.
+	bufStats := func(f func(s bufpool.Stats) uint64) func() float64 {
+		return func() float64 { return float64(f(bufpool.Default.Stats())) }
+	}
+	reg.CounterFunc("httptap_bufpool_gets_total", "Buffers taken from the pool.",
+		bufStats(func(s bufpool.Stats) uint64 { return s.Gets }))
+	reg.CounterFunc("httptap_bufpool_hits_total", "Buffers taken from the pool that were reused.",
+		bufStats(func(s bufpool.Stats) uint64 { return s.Hits }))
+	reg.CounterFunc("httptap_bufpool_puts_total", "Buffers returned to the pool.",
+		bufStats(func(s bufpool.Stats) uint64 { return s.Puts }))
+	reg.CounterFunc("httptap_bufpool_discards_total", "Buffers too large to return to the pool.",
+		bufStats(func(s bufpool.Stats) uint64 { return s.Discards }))
+	return m
+}
+
+// statusClass returns 2xx for 200, none when no response was written.
+func statusClass(status int) string {
+	if status <= 0 {
+		return "none"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// methodLabel returns the method for the method label, other for the methods
+// that are not standard, so clients cannot add series at will.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "other"
+}
+
+// observe records the exchange, also when the record is not served to the taps.
+func (m *proxyMetrics) observe(rt *route, rr *RequestResponse) {
+	if m == nil {
+		return
+	}
+	m.requests.With(rt.pattern, methodLabel(rr.Method), statusClass(rr.StatusCode)).Inc()
+	if rr.Duration > 0 {
+		m.duration.With(rt.pattern).Observe(rr.Duration.Seconds())
+	}
+	if c := rr.reqCapture; c != nil {
+		m.reqBodySize.With(rt.pattern).Observe(float64(c.size))
+	}
+	if c := rr.respCapture; c != nil {
+		m.respBodySize.With(rt.pattern).Observe(float64(c.size))
+	}
+	if rr.Error != nil {
+		m.upstreamErrors.With(rt.pattern, string(rr.ErrorKind)).Inc()
+	}
+}
+
+func (m *proxyMetrics) observeTap(tap string, d time.Duration, panicked bool) {
+	if m == nil {
+		return
+	}
+	m.tapDuration.With(tap).Observe(d.Seconds())
+	if panicked {
+		m.tapPanics.With(tap).Inc()
+	}
+}
+
+func (m *proxyMetrics) observeQueued() {
+	if m == nil {
+		return
+	}
+	m.dispatchQueued.Inc()
+}
+
+func (m *proxyMetrics) observeDropped() {
+	if m == nil {
+		return
+	}
+	m.dispatchDropped.Inc()
+}
+
+func (m *proxyMetrics) observePII(findings []pii.Finding) {
+	if m == nil {
+		return
+	}
+	for _, f := range findings {
+		m.piiFindings.With(f.Detector).Add(float64(f.Count))
+	}
+}
+
+// metrics returns the metrics of the proxy of the handler, nil without metrics.
+func (h *Handler) metrics() *proxyMetrics {
+	if h.p == nil {
+		return nil
+	}
+	return h.p.metrics
+}
+
+// serveTap calls the tap. A panic in the tap is logged, the record is lost
+// but the proxy and the other taps continue.
+func (h *Handler) serveTap(ctx context.Context, rr *RequestResponse) {
+	start := time.Now()
+	defer func() {
+		v := recover()
+		if v != nil {
+			h.log(rr).Error("tap panicked", slog.String("tap", h.name), slog.Any("panic", v))
+		}
+		h.metrics().observeTap(h.name, time.Since(start), v != nil)
+	}()
+	h.tap.Serve(ctx, rr)
+}
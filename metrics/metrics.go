@@ -0,0 +1,358 @@
+// Package metrics implements counters and histograms with labels and writes
+// them in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentType is the content type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var (
+	// DurationBuckets are the buckets of durations in seconds.
+	DurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	// SizeBuckets are the buckets of sizes in bytes, 64B to 16MiB.
+	SizeBuckets = ExponentialBuckets(64, 4, 10)
+)
+
+// ExponentialBuckets returns count buckets, the first is start,
+// every next bucket is factor times the previous.
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	res := make([]float64, count)
+	for i := range res {
+		res[i] = start
+		start *= factor
+	}
+	return res
+}
+
+type collector interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+// Registry contains the metrics that are written together.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// register adds c, or returns the metric with the same name. A new proxy,
+// e.g. after a reload, continues the metrics of the old one.
+func (r *Registry) register(c collector) collector {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, cc := range r.collectors {
+		if cc.name() != c.name() {
+			continue
+		}
+		if fmt.Sprintf("%T", cc) != fmt.Sprintf("%T", c) {
+			panic(fmt.Sprintf("metrics: %s registered with another type", c.name()))
+		}
+		if _, ok := c.(*funcMetric); ok {
+			// Read the new function.
+			r.collectors[i] = c
+			return c
+		}
+		return cc
+	}
+	r.collectors = append(r.collectors, c)
+	return c
+}
+
+// WriteTo writes the metrics sorted by name.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	cs := slices.Clone(r.collectors)
+	r.mu.Unlock()
+	sort.Slice(cs, func(i, j int) bool { return cs[i].name() < cs[j].name() })
+
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, c := range cs {
+		c.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP writes the metrics.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	r.WriteTo(w)
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// desc is the name, help and label names of a metric.
+type desc struct {
+	n      string
+	help   string
+	labels []string
+}
+
+func (d *desc) name() string { return d.n }
+
+func (d *desc) header(w *bufio.Writer, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.n, escapeHelp(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.n, typ)
+}
+
+// vec holds the children of a metric by their label values.
+type vec[T any] struct {
+	desc
+	mu       sync.RWMutex
+	children map[string]*child[T]
+	newT     func() *T
+}
+
+type child[T any] struct {
+	values []string
+	m      *T
+}
+
+func (v *vec[T]) with(values []string) *T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, got %d values", v.n, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.RLock()
+	c, ok := v.children[key]
+	v.mu.RUnlock()
+	if ok {
+		return c.m
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if c, ok := v.children[key]; ok {
+		return c.m
+	}
+	c = &child[T]{values: slices.Clone(values), m: v.newT()}
+	v.children[key] = c
+	return c.m
+}
+
+// sorted returns the children sorted by label values.
+func (v *vec[T]) sorted() []*child[T] {
+	v.mu.RLock()
+	res := make([]*child[T], 0, len(v.children))
+	for _, c := range v.children {
+		res = append(res, c)
+	}
+	v.mu.RUnlock()
+	sort.Slice(res, func(i, j int) bool {
+		return slices.Compare(res[i].values, res[j].values) < 0
+	})
+	return res
+}
+
+// Counter is a value that only goes up.
+type Counter struct {
+	bits atomic.Uint64
+}
+
+func (c *Counter) Inc() { c.Add(1) }
+
+// Add adds v, v must not be negative.
+func (c *Counter) Add(v float64) {
+	addFloat(&c.bits, v)
+}
+
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+// CounterVec is a counter with labels.
+type CounterVec struct {
+	vec[Counter]
+}
+
+// Counter registers a counter with the label names.
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{vec[Counter]{
+		desc:     desc{n: name, help: help, labels: labels},
+		children: map[string]*child[Counter]{},
+		newT:     func() *Counter { return &Counter{} },
+	}}
+	return r.register(c).(*CounterVec)
+}
+
+// With returns the counter of the label values.
+func (c *CounterVec) With(values ...string) *Counter {
+	return c.with(values)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.header(w, "counter")
+	for _, ch := range c.sorted() {
+		writeSample(w, c.n, c.labels, ch.values, "", "", ch.m.Value())
+	}
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	upper  []float64
+	counts []atomic.Uint64
+	count  atomic.Uint64
+	sum    atomic.Uint64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{upper: buckets, counts: make([]atomic.Uint64, len(buckets))}
+}
+
+func (h *Histogram) Observe(v float64) {
+	if i := sort.SearchFloat64s(h.upper, v); i < len(h.upper) {
+		h.counts[i].Add(1)
+	}
+	addFloat(&h.sum, v)
+	h.count.Add(1)
+}
+
+// HistogramVec is a histogram with labels.
+type HistogramVec struct {
+	vec[Histogram]
+	buckets []float64
+}
+
+// Histogram registers a histogram with the upper bounds of the buckets,
+// sorted ascending, and the label names.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+	h := &HistogramVec{
+		vec: vec[Histogram]{
+			desc:     desc{n: name, help: help, labels: labels},
+			children: map[string]*child[Histogram]{},
+			newT:     func() *Histogram { return newHistogram(buckets) },
+		},
+		buckets: buckets,
+	}
+	return r.register(h).(*HistogramVec)
+}
+
+// With returns the histogram of the label values.
+func (h *HistogramVec) With(values ...string) *Histogram {
+	return h.with(values)
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.header(w, "histogram")
+	for _, ch := range h.sorted() {
+		m := ch.m
+		// Read the count first, the buckets may be ahead of it but not behind.
+		count := m.count.Load()
+		var cum uint64
+		for i, le := range m.upper {
+			cum += m.counts[i].Load()
+			writeSample(w, h.n+"_bucket", h.labels, ch.values, "le", formatFloat(le), float64(min(cum, count)))
+		}
+		writeSample(w, h.n+"_bucket", h.labels, ch.values, "le", "+Inf", float64(count))
+		writeSample(w, h.n+"_sum", h.labels, ch.values, "", "", math.Float64frombits(m.sum.Load()))
+		writeSample(w, h.n+"_count", h.labels, ch.values, "", "", float64(count))
+	}
+}
+
+// funcMetric is a counter or gauge that is read when the metrics are written.
+type funcMetric struct {
+	desc
+	typ string
+	f   func() float64
+}
+
+// CounterFunc registers a counter that is read from f.
+func (r *Registry) CounterFunc(name, help string, f func() float64) {
+	r.register(&funcMetric{desc: desc{n: name, help: help}, typ: "counter", f: f})
+}
+
+// GaugeFunc registers a gauge that is read from f.
+func (r *Registry) GaugeFunc(name, help string, f func() float64) {
+	r.register(&funcMetric{desc: desc{n: name, help: help}, typ: "gauge", f: f})
+}
+
+func (m *funcMetric) write(w *bufio.Writer) {
+	m.header(w, m.typ)
+	writeSample(w, m.n, nil, nil, "", "", m.f())
+}
+
+func addFloat(bits *atomic.Uint64, v float64) {
+	for {
+		old := bits.Load()
+		if bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraLabel, extraValue string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraLabel != "" {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(l)
+			w.WriteString(`="`)
+			w.WriteString(escapeLabel(values[i]))
+			w.WriteByte('"')
+		}
+		if extraLabel != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extraLabel)
+			w.WriteString(`="`)
+			w.WriteString(extraValue)
+			w.WriteByte('"')
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	reg := NewRegistry()
+	c := reg.Counter("requests_total", "Requests.", "method", "path")
+	c.With("GET", `/a"b`).Inc()
+	c.With("GET", `/a"b`).Add(2)
+	c.With("DELETE", "/").Inc()
+	h := reg.Histogram("duration_seconds", "Duration\nin seconds.", []float64{1, 0.1}, "path")
+	h.With("/").Observe(0.05)
+	h.With("/").Observe(0.1)
+	h.With("/").Observe(3)
+	reg.GaugeFunc("up", "Up.", func() float64 { return 1 })
+
+	// A second registration continues the same counter.
+	if reg.Counter("requests_total", "Requests.", "method", "path") != c {
+		t.Errorf("counter registered twice")
+	}
+
+	var b strings.Builder
+	if _, err := reg.WriteTo(&b); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	want := `# HELP duration_seconds Duration\nin seconds.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{path="/",le="0.1"} 2
+duration_seconds_bucket{path="/",le="1"} 2
+duration_seconds_bucket{path="/",le="+Inf"} 3
+duration_seconds_sum{path="/"} 3.15
+duration_seconds_count{path="/"} 3
+# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{method="DELETE",path="/"} 1
+requests_total{method="GET",path="/a\"b"} 3
+# HELP up Up.
+# TYPE up gauge
+up 1
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
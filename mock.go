@@ -0,0 +1,155 @@
+package httptap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+// Mock is a response that the tap returns without contacting the upstream.
+type Mock struct {
+	// StatusCode defaults to 200.
+	StatusCode int
+	Header     http.Header
+	// Body is returned as is when Template is empty.
+	Body []byte
+	// Template is a text/template that renders the body, see MockData.
+	Template string
+}
+
+// MockData is passed to the template of a mock.
+//
+//	{"id": "{{.PathValue "id"}}", "name": {{json .JSON.name}}}
+type MockData struct {
+	Method string
+	URL    *url.URL
+	Header http.Header
+	Query  url.Values
+	// Body is the request body.
+	Body string
+	// JSON is the request body unmarshalled, nil when it is not JSON.
+	JSON any
+
+	r *http.Request
+}
+
+// PathValue returns the value of the wildcard name in the pattern that matched.
+func (d MockData) PathValue(name string) string {
+	return d.r.PathValue(name)
+}
+
+var mockFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+type mock struct {
+	status int
+	header http.Header
+	body   []byte
+	tpl    *template.Template
+}
+
+func newMock(m Mock) (*mock, error) {
+	res := &mock{
+		status: m.StatusCode,
+		header: m.Header,
+		body:   m.Body,
+	}
+	if res.status == 0 {
+		res.status = http.StatusOK
+	}
+	if m.Template != "" {
+		tpl, err := template.New("mock").Funcs(mockFuncs).Parse(m.Template)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing mock template: %w", err)
+		}
+		res.tpl = tpl
+	}
+	return res, nil
+}
+
+// render returns the body of the response.
+func (m *mock) render(r *http.Request, body []byte) ([]byte, error) {
+	if m.tpl == nil {
+		return m.body, nil
+	}
+	data := MockData{
+		Method: r.Method,
+		URL:    r.URL,
+		Header: r.Header,
+		Query:  r.URL.Query(),
+		Body:   string(body),
+		r:      r,
+	}
+	if obj, mt, err := decodeBody(r.Header, body); err == nil && mt == mediaTypeJSON {
+		data.JSON = obj
+	}
+	var b bytes.Buffer
+	if err := m.tpl.Execute(&b, data); err != nil {
+		return nil, fmt.Errorf("error executing mock template: %w", err)
+	}
+	return b.Bytes(), nil
+}
+
+// serveMock writes the mock response and records the exchange like a proxied one.
+func (h *Handler) serveMock(w http.ResponseWriter, r *http.Request, rr *RequestResponse) {
+	logger := h.log(rr).With(slog.String("step", "serveMock"))
+	m := h.mock
+
+	rr.Mocked = true
+	h.recordRequest(rr, r)
+
+	// Read the request body, the template may need it.
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		rr.reqCapture = newBodyCapture(rr.captureRequest, rr.reqLimits, logger)
+		var err error
+		body, err = io.ReadAll(io.LimitReader(io.TeeReader(r.Body, rr.reqCapture), maxDecodedSize))
+		if err != nil {
+			logger.Error("cannot read request body", slog.String("err", err.Error()))
+		}
+	}
+	// The trailer is available after the body is read.
+	rr.ReqTrailer = r.Trailer.Clone()
+
+	status := m.status
+	b, err := m.render(r, body)
+	if err != nil {
+		logger.Error("mock not rendered", slog.String("err", err.Error()))
+		rr.Error = err
+		rr.ErrorKind = ErrorOther
+		status = http.StatusInternalServerError
+		b = nil
+	} else {
+		for k, v := range m.header {
+			w.Header()[http.CanonicalHeaderKey(k)] = append([]string(nil), v...)
+		}
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+
+	rr.End = time.Now()
+	rr.Duration = rr.End.Sub(rr.Start)
+	rr.StatusCode = status
+	rr.Status = fmt.Sprintf("%d %s", status, http.StatusText(status))
+	rr.RespProto = r.Proto
+	rr.RespHeader = w.Header().Clone()
+	if len(b) > 0 {
+		rr.respCapture = newBodyCapture(rr.captureResponse, rr.respLimits, logger)
+		rr.respCapture.Write(b)
+	}
+
+	w.WriteHeader(status)
+	if _, err := w.Write(b); err != nil {
+		logger.Debug("mock response not written", slog.String("err", err.Error()))
+	}
+}
@@ -0,0 +1,178 @@
+package httptap
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxMultipartValue is the maximum size of a text part that is stored in Value.
+const maxMultipartValue = 1024
+
+// MultipartPart describes a part of a multipart/form-data body.
+type MultipartPart struct {
+	FieldName   string `json:"field_name"`
+	FileName    string `json:"file_name,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+	// Value is the content of small text parts that are not files.
+	Value string `json:"value,omitempty"`
+	// EvidenceFile is the file the uploaded file is saved to.
+	EvidenceFile string `json:"evidence_file,omitempty"`
+}
+
+// prefixWriter keeps the first max bytes written to it.
+type prefixWriter struct {
+	buf      bytes.Buffer
+	max      int
+	overflow bool
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := w.max - w.buf.Len(); len(p) > room {
+		p = p[:room]
+		w.overflow = true
+	}
+	w.buf.Write(p)
+	return n, nil
+}
+
+func isTextPart(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mt, _, err := mime.ParseMediaType(contentType)
+	return err == nil && strings.HasPrefix(mt, "text/")
+}
+
+// parseMultipart reads the parts of a multipart body.
+// When evidenceDir is set, the uploaded files are saved in it, named by their digest.
+// On error the parts read so far are returned.
+func parseMultipart(r io.Reader, boundary string, evidenceDir string) ([]MultipartPart, error) {
+	if boundary == "" {
+		return nil, errors.New("no multipart boundary")
+	}
+	var parts []MultipartPart
+	mr := multipart.NewReader(r, boundary)
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return parts, nil
+		}
+		if err != nil {
+			return parts, err
+		}
+		part, err := readPart(p, evidenceDir)
+		p.Close()
+		if err != nil {
+			return parts, err
+		}
+		parts = append(parts, part)
+	}
+}
+
+func readPart(p *multipart.Part, evidenceDir string) (MultipartPart, error) {
+	part := MultipartPart{
+		FieldName:   p.FormName(),
+		FileName:    p.FileName(),
+		ContentType: p.Header.Get("Content-Type"),
+	}
+	hash := sha256.New()
+	value := &prefixWriter{max: maxMultipartValue}
+	w := io.MultiWriter(hash, value)
+
+	var f *os.File
+	if evidenceDir != "" && part.FileName != "" {
+		var err error
+		if f, err = os.CreateTemp(evidenceDir, "part-*"); err != nil {
+			return part, fmt.Errorf("error creating evidence file: %w", err)
+		}
+		defer func() {
+			// Removes the temp file if it was not renamed.
+			f.Close()
+			os.Remove(f.Name())
+		}()
+		w = io.MultiWriter(w, f)
+	}
+
+	n, err := io.Copy(w, p)
+	part.Size = n
+	part.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	if err != nil {
+		return part, err
+	}
+	if part.FileName == "" && !value.overflow && isTextPart(part.ContentType) {
+		part.Value = value.buf.String()
+	}
+	if f != nil {
+		if err := f.Close(); err != nil {
+			return part, fmt.Errorf("error writing evidence file: %w", err)
+		}
+		// Name the file by its digest, the same upload is stored once.
+		name := filepath.Join(evidenceDir, part.SHA256)
+		if err := os.Rename(f.Name(), name); err != nil {
+			return part, fmt.Errorf("error saving evidence file: %w", err)
+		}
+		part.EvidenceFile = name
+	}
+	return part, nil
+}
+
+// decodeMultipart is the BodyDecoder for multipart/form-data.
+func decodeMultipart(b []byte, params map[string]string) (any, error) {
+	return parseMultipart(bytes.NewReader(b), params["boundary"], "")
+}
+
+func init() {
+	RegisterBodyDecoder("multipart/form-data", BodyDecoderFunc(decodeMultipart))
+}
+
+// parseRequestMultipart parses the captured request body into ReqMultipart.
+// Spilled bodies are read from their file. A malformed body comes from the
+// client, it is reported in ReqMultipartError and not logged as an error of the proxy.
+func (h *Handler) parseRequestMultipart(rr *RequestResponse) {
+	if !h.withMultipart {
+		return
+	}
+	logger := h.log(rr).With(slog.String("step", "parseRequestMultipart"))
+	ct, params, err := mime.ParseMediaType(rr.ReqHeader.Get("Content-Type"))
+	if err != nil || ct != "multipart/form-data" {
+		return
+	}
+	var r io.Reader
+	switch {
+	case rr.ReqBody != nil:
+		r = bytes.NewReader(rr.ReqBody.Bytes())
+	case rr.ReqBodyFile != "":
+		f, err := os.Open(rr.ReqBodyFile)
+		if err != nil {
+			logger.Debug("cannot open spilled body", slog.String("err", err.Error()))
+			rr.ReqMultipartError = err.Error()
+			return
+		}
+		defer f.Close()
+		r = f
+	default:
+		return
+	}
+	parts, err := parseMultipart(r, params["boundary"], h.evidenceDir)
+	if err != nil {
+		logger.Debug("multipart body not parsed completely",
+			slog.String("err", err.Error()),
+			slog.Bool("truncated", rr.ReqBodyTruncated),
+		)
+		rr.ReqMultipartError = err.Error()
+	}
+	rr.ReqMultipart = parts
+}
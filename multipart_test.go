@@ -0,0 +1,82 @@
+package httptap
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseMultipart(t *testing.T) {
+	file := bytes.Repeat([]byte("binary\x00data"), 200)
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("description", "invoice march")
+	fw, _ := mw.CreateFormFile("upload", "invoice.pdf")
+	fw.Write(file)
+	mw.Close()
+
+	dir := t.TempDir()
+	parts, err := parseMultipart(bytes.NewReader(body.Bytes()), mw.Boundary(), dir)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if len(parts) != 2 {
+		t.Fatalf("got %d parts, want 2", len(parts))
+	}
+
+	field := parts[0]
+	if field.FieldName != "description" || field.Value != "invoice march" || field.EvidenceFile != "" {
+		t.Errorf("bad field part: %+v", field)
+	}
+
+	sum := sha256.Sum256(file)
+	digest := hex.EncodeToString(sum[:])
+	upload := parts[1]
+	if upload.FieldName != "upload" || upload.FileName != "invoice.pdf" {
+		t.Errorf("bad file part: %+v", upload)
+	}
+	if upload.Size != int64(len(file)) || upload.SHA256 != digest || upload.Value != "" {
+		t.Errorf("bad file part: %+v", upload)
+	}
+	if upload.EvidenceFile != filepath.Join(dir, digest) {
+		t.Errorf("evidence file: got %s", upload.EvidenceFile)
+	}
+	saved, err := os.ReadFile(upload.EvidenceFile)
+	if err != nil || !bytes.Equal(saved, file) {
+		t.Errorf("evidence file does not contain the upload: %v", err)
+	}
+	// Only the evidence file is left.
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("got %d files in evidence dir, want 1", len(entries))
+	}
+}
+
+func TestParseRequestMultipartMalformed(t *testing.T) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("description", "invoice march")
+	mw.WriteField("amount", "forty-two")
+	mw.Close()
+
+	h := &Handler{logger: slog.New(slog.NewTextHandler(io.Discard, nil)), withMultipart: true}
+	rr := &RequestResponse{
+		ReqHeader: http.Header{"Content-Type": {mw.FormDataContentType()}},
+		// Cut off in the second part.
+		ReqBody: bytes.NewBuffer(body.Bytes()[:bytes.Index(body.Bytes(), []byte("forty-two"))]),
+	}
+	h.parseRequestMultipart(rr)
+	if rr.ReqMultipartError == "" {
+		t.Errorf("malformed body not reported")
+	}
+	if len(rr.ReqMultipart) != 1 || rr.ReqMultipart[0].Value != "invoice march" {
+		t.Errorf("got parts %+v, want the first part", rr.ReqMultipart)
+	}
+}
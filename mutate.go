@@ -0,0 +1,184 @@
+package httptap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	jsonpatch "github.com/evanphx/json-patch"
+)
+
+// maxMutateSize is the maximum size of a body that is mutated.
+// Larger bodies are forwarded unchanged.
+const maxMutateSize = maxDecodedSize
+
+var errBodyTooLarge = errors.New("body too large to mutate")
+
+// Mutation changes the requests sent to the upstream or the responses
+// returned to the client.
+// The body changes apply to JSON bodies only.
+type Mutation struct {
+	// BodyPatch is a JSON Patch (RFC 6902).
+	BodyPatch []byte
+	// BodyMergePatch is a JSON Merge Patch (RFC 7386), applied after BodyPatch.
+	BodyMergePatch []byte
+
+	AddHeader    http.Header
+	SetHeader    http.Header
+	RemoveHeader []string
+}
+
+type mutation struct {
+	patch  jsonpatch.Patch
+	merge  []byte
+	add    http.Header
+	set    http.Header
+	remove []string
+}
+
+func newMutation(m Mutation) (*mutation, error) {
+	res := &mutation{
+		merge:  m.BodyMergePatch,
+		add:    m.AddHeader,
+		set:    m.SetHeader,
+		remove: m.RemoveHeader,
+	}
+	if len(m.BodyPatch) > 0 {
+		p, err := jsonpatch.DecodePatch(m.BodyPatch)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding body patch: %w", err)
+		}
+		res.patch = p
+	}
+	return res, nil
+}
+
+func (m *mutation) hasBody() bool {
+	return m.patch != nil || len(m.merge) > 0
+}
+
+// applyHeader removes, sets and adds the headers, in that order.
+func (m *mutation) applyHeader(h http.Header) {
+	for _, k := range m.remove {
+		h.Del(k)
+	}
+	for k, v := range m.set {
+		h[http.CanonicalHeaderKey(k)] = append([]string(nil), v...)
+	}
+	for k, v := range m.add {
+		for _, vv := range v {
+			h.Add(k, vv)
+		}
+	}
+}
+
+func (m *mutation) applyBody(b []byte) ([]byte, error) {
+	var err error
+	if m.patch != nil {
+		if b, err = m.patch.Apply(b); err != nil {
+			return nil, fmt.Errorf("error applying body patch: %w", err)
+		}
+	}
+	if len(m.merge) > 0 {
+		if b, err = jsonpatch.MergePatch(b, m.merge); err != nil {
+			return nil, fmt.Errorf("error applying body merge patch: %w", err)
+		}
+	}
+	return b, nil
+}
+
+// mutateBody reads the body, applies the mutation and returns the new body.
+// When the body is not mutated, the returned reader gives the original body.
+// decoded reports if the Content-Encoding was removed.
+func (m *mutation) mutateBody(h http.Header, body io.Reader) (res io.Reader, n int64, decoded bool, err error) {
+	b, err := io.ReadAll(io.LimitReader(body, maxMutateSize+1))
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if len(b) > maxMutateSize {
+		return io.MultiReader(bytes.NewReader(b), body), 0, false, errBodyTooLarge
+	}
+	original := bytes.NewReader(b)
+
+	enc := contentEncoding(h)
+	if enc != "" {
+		if b, err = decodeContent(enc, b, maxMutateSize); err != nil {
+			return original, 0, false, err
+		}
+	}
+	if b, err = m.applyBody(b); err != nil {
+		return original, 0, false, err
+	}
+	return bytes.NewReader(b), int64(len(b)), enc != "", nil
+}
+
+// isJSONBody reports if the Content-Type in h is JSON, including the +json types.
+func isJSONBody(h http.Header) bool {
+	_, _, mt, err := bodyDecoderFor(h)
+	return err == nil && mt == mediaTypeJSON
+}
+
+// setMutatedHeader updates the headers for the mutated body.
+func setMutatedHeader(h http.Header, n int64, decoded bool) {
+	h.Set("Content-Length", strconv.FormatInt(n, 10))
+	if decoded {
+		h.Del("Content-Encoding")
+	}
+}
+
+// mutateRequest changes the request that is sent to the upstream.
+func (h *Handler) mutateRequest(rr *RequestResponse, out *http.Request) {
+	m := h.reqMutation
+	if m == nil {
+		return
+	}
+	logger := h.log(rr).With(slog.String("step", "mutateRequest"))
+	m.applyHeader(out.Header)
+	if !m.hasBody() || out.Body == nil || out.Body == http.NoBody || !isJSONBody(out.Header) {
+		return
+	}
+	body, n, decoded, err := m.mutateBody(out.Header, out.Body)
+	if body == nil {
+		// The body cannot be read, let the transport report the error.
+		logger.Error("cannot read request body", slog.String("err", err.Error()))
+		return
+	}
+	out.Body = io.NopCloser(body)
+	if err != nil {
+		logger.Error("request body not mutated", slog.String("err", err.Error()))
+		return
+	}
+	out.ContentLength = n
+	out.GetBody = nil
+	setMutatedHeader(out.Header, n, decoded)
+}
+
+// mutateResponse changes the response that is returned to the client.
+func (h *Handler) mutateResponse(rr *RequestResponse, r *http.Response) error {
+	m := h.respMutation
+	if m == nil {
+		return nil
+	}
+	logger := h.log(rr).With(slog.String("step", "mutateResponse"))
+	m.applyHeader(r.Header)
+	if !m.hasBody() || r.Body == nil || r.Body == http.NoBody || !isJSONBody(r.Header) {
+		return nil
+	}
+	body, n, decoded, err := m.mutateBody(r.Header, r.Body)
+	if body == nil {
+		return fmt.Errorf("error reading response body: %w", err)
+	}
+	r.Body = io.NopCloser(body)
+	if err != nil {
+		logger.Error("response body not mutated", slog.String("err", err.Error()))
+		return nil
+	}
+	r.ContentLength = n
+	r.TransferEncoding = nil
+	setMutatedHeader(r.Header, n, decoded)
+	return nil
+}
@@ -0,0 +1,118 @@
+package httptap
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// NetworkConditions emulate a slow link between the client and the upstream.
+// Zero values disable the limit.
+type NetworkConditions struct {
+	// UploadBytesPerSecond limits the request body sent to the upstream.
+	UploadBytesPerSecond int64
+	// DownloadBytesPerSecond limits the response body returned to the client.
+	DownloadBytesPerSecond int64
+	// Jitter adds a random delay up to Jitter to every chunk.
+	Jitter time.Duration
+	// ChunkSize is the maximum number of bytes that is read and written at once.
+	// The response is flushed after every chunk.
+	ChunkSize int
+}
+
+func (n *NetworkConditions) upload() bool {
+	return n.UploadBytesPerSecond > 0 || n.Jitter > 0 || n.ChunkSize > 0
+}
+
+func (n *NetworkConditions) download() bool {
+	return n.DownloadBytesPerSecond > 0 || n.Jitter > 0 || n.ChunkSize > 0
+}
+
+// sleep waits for d or until the context is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+// throttledReader reads at most rate bytes per second in chunks of at most chunk bytes.
+type throttledReader struct {
+	io.ReadCloser
+	ctx    context.Context
+	rate   int64
+	jitter time.Duration
+	chunk  int
+
+	start time.Time
+	read  int64
+}
+
+func newThrottledReader(ctx context.Context, rc io.ReadCloser, rate int64, n *NetworkConditions) *throttledReader {
+	return &throttledReader{
+		ReadCloser: rc,
+		ctx:        ctx,
+		rate:       rate,
+		jitter:     n.Jitter,
+		chunk:      n.ChunkSize,
+	}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if t.start.IsZero() {
+		t.start = time.Now()
+	}
+	if t.chunk > 0 && len(p) > t.chunk {
+		p = p[:t.chunk]
+	}
+	// Do not read more than one second of data at once.
+	if t.rate > 0 && int64(len(p)) > t.rate {
+		p = p[:t.rate]
+	}
+	n, err := t.ReadCloser.Read(p)
+	if n == 0 {
+		return n, err
+	}
+	t.read += int64(n)
+
+	var d time.Duration
+	if t.rate > 0 {
+		// The time the bytes read so far take on the link.
+		due := time.Duration(float64(t.read) / float64(t.rate) * float64(time.Second))
+		d = due - time.Since(t.start)
+	}
+	if t.jitter > 0 {
+		d += rand.N(t.jitter)
+	}
+	if serr := sleep(t.ctx, d); serr != nil && err == nil {
+		err = serr
+	}
+	return n, err
+}
+
+// throttleRequest limits the request body that is sent to the upstream.
+func (h *Handler) throttleRequest(out *http.Request) {
+	n := h.network
+	if n == nil || !n.upload() || out.Body == nil || out.Body == http.NoBody {
+		return
+	}
+	out.Body = newThrottledReader(out.Context(), out.Body, n.UploadBytesPerSecond, n)
+}
+
+// throttleResponse limits the response body that is returned to the client.
+func (h *Handler) throttleResponse(r *http.Response) {
+	n := h.network
+	if n == nil || !n.download() || r.Body == nil || r.Body == http.NoBody {
+		return
+	}
+	r.Body = newThrottledReader(r.Request.Context(), r.Body, n.DownloadBytesPerSecond, n)
+}
@@ -0,0 +1,107 @@
+package httptap
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/myhops/httptap/pii"
+)
+
+// PIIAction is what happens with the values found by the PII detectors.
+type PIIAction string
+
+const (
+	// PIIMask replaces the values with *** and reports them in PIIFindings.
+	PIIMask PIIAction = "mask"
+	// PIIAnnotate only reports the values in PIIFindings.
+	PIIAnnotate PIIAction = "annotate"
+)
+
+// scanPII scans the captured bodies, headers and query for sensitive values.
+func (h *Handler) scanPII(rr *RequestResponse) {
+	if h.pii == nil {
+		return
+	}
+	// Spilled bodies are not scanned, they are withheld from the taps.
+	if rr.ReqBodyFile != "" || rr.RespBodyFile != "" {
+		h.log(rr).Warn("spilled body cannot be scanned, withheld from taps", slog.String("step", "scanPII"))
+		rr.ReqBodyFile = ""
+		rr.RespBodyFile = ""
+	}
+	mask := h.piiAction == PIIMask
+	rr.PIIFindings = append(rr.PIIFindings, h.scanBody("request_body", rr.ReqBody, mask)...)
+	rr.PIIFindings = append(rr.PIIFindings, h.scanBody("response_body", rr.RespBody, mask)...)
+	rr.PIIFindings = append(rr.PIIFindings, h.scanHeader("request_header:", rr.ReqHeader, mask)...)
+	rr.PIIFindings = append(rr.PIIFindings, h.scanHeader("response_header:", rr.RespHeader, mask)...)
+	rr.PIIFindings = append(rr.PIIFindings, h.scanQuery(rr, mask)...)
+	rr.PIIFindings = append(rr.PIIFindings, h.scanMultipart(rr.ReqMultipart, mask)...)
+	h.metrics().observePII(rr.PIIFindings)
+	if len(rr.PIIFindings) > 0 {
+		h.log(rr).Debug("pii found", slog.String("step", "scanPII"), slog.Int("findings", len(rr.PIIFindings)))
+	}
+}
+
+func (h *Handler) scanBody(location string, b *bytes.Buffer, mask bool) []pii.Finding {
+	if b == nil || b.Len() == 0 {
+		return nil
+	}
+	text, findings := h.pii.Scan(location, b.String(), mask)
+	if mask && len(findings) > 0 {
+		b.Reset()
+		b.WriteString(text)
+	}
+	return findings
+}
+
+func (h *Handler) scanHeader(prefix string, hdr http.Header, mask bool) []pii.Finding {
+	var res []pii.Finding
+	for k, v := range hdr {
+		for i := range v {
+			text, findings := h.pii.Scan(prefix+k, v[i], mask)
+			if mask {
+				v[i] = text
+			}
+			res = append(res, findings...)
+		}
+	}
+	return res
+}
+
+func (h *Handler) scanQuery(rr *RequestResponse, mask bool) []pii.Finding {
+	if rr.URL == nil || rr.URL.RawQuery == "" {
+		return nil
+	}
+	q, err := url.ParseQuery(rr.URL.RawQuery)
+	if err != nil {
+		return nil
+	}
+	var res []pii.Finding
+	for k, v := range q {
+		for i := range v {
+			text, findings := h.pii.Scan("query:"+k, v[i], mask)
+			v[i] = text
+			res = append(res, findings...)
+		}
+	}
+	if mask && len(res) > 0 {
+		// The URL is shared with the proxied request, change a copy.
+		u := *rr.URL
+		u.RawQuery = q.Encode()
+		rr.URL = &u
+	}
+	return res
+}
+
+func (h *Handler) scanMultipart(parts []MultipartPart, mask bool) []pii.Finding {
+	var res []pii.Finding
+	for i := range parts {
+		text, findings := h.pii.Scan("multipart:"+parts[i].FieldName, parts[i].Value, mask)
+		if mask {
+			parts[i].Value = text
+		}
+		res = append(res, findings...)
+	}
+	return res
+}
@@ -0,0 +1,226 @@
+// Package pii detects common sensitive values in text, like email addresses,
+// credit card numbers, IBANs, Dutch BSNs, phone numbers, JWTs and private keys.
+package pii
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Mask replaces the detected values when masking.
+const Mask = "***"
+
+// Detector finds one kind of sensitive value.
+type Detector struct {
+	Name    string
+	pattern *regexp.Regexp
+	// valid checks a match, e.g. with a checksum. Nil accepts all matches.
+	valid func(string) bool
+}
+
+func (d *Detector) match(s string) bool {
+	return d.valid == nil || d.valid(s)
+}
+
+// The detectors in the order they are applied.
+// Longer and more specific values go first, so their digits are not
+// reported by the number detectors.
+var detectors = []*Detector{
+	{
+		Name:    "privatekey",
+		pattern: regexp.MustCompile(`-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY-----[\s\S]*?-----END (?:[A-Z0-9]+ )*PRIVATE KEY-----`),
+	},
+	{
+		Name:    "jwt",
+		pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`),
+	},
+	{
+		Name:    "email",
+		pattern: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`),
+	},
+	{
+		Name:    "iban",
+		pattern: regexp.MustCompile(`\b[A-Z]{2}[0-9]{2}(?: ?[A-Z0-9]){11,30}\b`),
+		valid:   ValidIBAN,
+	},
+	{
+		Name:    "creditcard",
+		pattern: regexp.MustCompile(`\b(?:[0-9][ -]?){12,18}[0-9]\b`),
+		valid:   ValidLuhn,
+	},
+	{
+		Name:    "bsn",
+		pattern: regexp.MustCompile(`\b[0-9]{9}\b`),
+		valid:   ValidBSN,
+	},
+	{
+		Name:    "phone",
+		pattern: regexp.MustCompile(`(?:\+|\b00)[1-9][0-9]{0,2}[ \-]?(?:[0-9][ \-]?){6,11}[0-9]\b|\b0[1-9](?:[ \-]?[0-9]){8}\b`),
+	},
+}
+
+// Names returns the names of the detectors.
+func Names() []string {
+	res := make([]string, 0, len(detectors))
+	for _, d := range detectors {
+		res = append(res, d.Name)
+	}
+	return res
+}
+
+// Finding reports the values a detector found in a location.
+type Finding struct {
+	Detector string `json:"detector"`
+	Location string `json:"location"`
+	Count    int    `json:"count"`
+}
+
+// Scanner scans text with a set of detectors.
+type Scanner struct {
+	detectors []*Detector
+}
+
+// NewScanner returns a scanner for the named detectors, all detectors when names is empty.
+func NewScanner(names ...string) (*Scanner, error) {
+	if len(names) == 0 {
+		return &Scanner{detectors: detectors}, nil
+	}
+	want := map[string]bool{}
+	for _, n := range names {
+		want[strings.ToLower(n)] = true
+	}
+	s := &Scanner{}
+	// Keep the order of the detectors.
+	for _, d := range detectors {
+		if want[d.Name] {
+			s.detectors = append(s.detectors, d)
+			delete(want, d.Name)
+		}
+	}
+	if len(want) > 0 {
+		unknown := make([]string, 0, len(want))
+		for n := range want {
+			unknown = append(unknown, n)
+		}
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown pii detectors: %s", strings.Join(unknown, ", "))
+	}
+	return s, nil
+}
+
+// Scan returns the findings in text for location.
+// A value is found by one detector, the first in order, also when a later
+// detector matches it too, like the digits of a card number.
+// When mask is set, the returned text has the detected values replaced by Mask.
+func (s *Scanner) Scan(location, text string, mask bool) (string, []Finding) {
+	var (
+		findings []Finding
+		found    [][]int
+	)
+	overlaps := func(loc []int) bool {
+		for _, f := range found {
+			if loc[0] < f[1] && f[0] < loc[1] {
+				return true
+			}
+		}
+		return false
+	}
+	for _, d := range s.detectors {
+		n := 0
+		for _, loc := range d.pattern.FindAllStringIndex(text, -1) {
+			if overlaps(loc) || !d.match(text[loc[0]:loc[1]]) {
+				continue
+			}
+			found = append(found, loc)
+			n++
+		}
+		if n > 0 {
+			findings = append(findings, Finding{Detector: d.Name, Location: location, Count: n})
+		}
+	}
+	if !mask || len(found) == 0 {
+		return text, findings
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i][0] < found[j][0] })
+	var sb strings.Builder
+	last := 0
+	for _, loc := range found {
+		sb.WriteString(text[last:loc[0]])
+		sb.WriteString(Mask)
+		last = loc[1]
+	}
+	sb.WriteString(text[last:])
+	return sb.String(), findings
+}
+
+func digits(s string) []int {
+	res := make([]int, 0, len(s))
+	for _, c := range s {
+		if c >= '0' && c <= '9' {
+			res = append(res, int(c-'0'))
+		}
+	}
+	return res
+}
+
+// ValidLuhn reports if the digits in s pass the Luhn check of credit card numbers.
+func ValidLuhn(s string) bool {
+	d := digits(s)
+	if len(d) < 13 || len(d) > 19 {
+		return false
+	}
+	sum := 0
+	for i := range d {
+		v := d[len(d)-1-i]
+		if i%2 == 1 {
+			v *= 2
+			if v > 9 {
+				v -= 9
+			}
+		}
+		sum += v
+	}
+	return sum%10 == 0
+}
+
+// ValidIBAN reports if s is an IBAN with a valid checksum.
+func ValidIBAN(s string) bool {
+	s = strings.ReplaceAll(s, " ", "")
+	if len(s) < 15 || len(s) > 34 {
+		return false
+	}
+	// Move the country code and checksum to the end and convert letters to numbers.
+	var b strings.Builder
+	for _, c := range s[4:] + s[:4] {
+		switch {
+		case c >= '0' && c <= '9':
+			b.WriteRune(c)
+		case c >= 'A' && c <= 'Z':
+			fmt.Fprintf(&b, "%d", c-'A'+10)
+		default:
+			return false
+		}
+	}
+	n, ok := new(big.Int).SetString(b.String(), 10)
+	if !ok {
+		return false
+	}
+	return new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+// ValidBSN reports if s is a Dutch citizen service number that passes the 11-proof.
+func ValidBSN(s string) bool {
+	d := digits(s)
+	if len(d) != 9 {
+		return false
+	}
+	sum := 0
+	for i := 0; i < 8; i++ {
+		sum += (9 - i) * d[i]
+	}
+	sum -= d[8]
+	return sum != 0 && sum%11 == 0
+}
@@ -2,13 +2,18 @@ package httptap
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"regexp"
+	"strings"
 	"sync"
+
+	"github.com/myhops/httptap/metrics"
 )
 
 type (
@@ -32,10 +37,16 @@ type ProxyConfig struct {
 }
 
 type RequestContext struct {
+	// Handler is the handler that proxies the request.
 	Handler         *Handler
 	RequestResponse *RequestResponse
 	Logger          *slog.Logger
+	// RequestID is the id of the request, empty without WithRequestID.
+	RequestID string
+	// Trace is the trace context of the request, nil without WithTraceContext.
+	Trace *TraceContext
 
+	route   *route
 	closers []io.Closer
 }
 
@@ -84,9 +95,39 @@ func (p *bytesPool) Put(b []byte) {
 
 type Proxy struct {
 	http.ServeMux
-	upstream   *url.URL
-	logger     *slog.Logger
-	hasDefault bool
+	upstream *url.URL
+	logger   *slog.Logger
+
+	// routes maps the patterns to the routes registered on the ServeMux.
+	routes      map[string]*route
+	defaultOnce sync.Once
+	// handlers are the handlers in the order the taps were added.
+	mu       sync.Mutex
+	handlers []*Handler
+
+	// Header lists that apply to all taps.
+	includeHeaders []string
+	excludeHeaders []string
+	headerMasks    []HeaderMask
+
+	// Asynchronous dispatch of the records to the taps.
+	async      bool
+	queueSize  int
+	workers    int
+	dropPolicy DropPolicy
+	dispatcher *dispatcher
+
+	// Response written when the upstream cannot be reached.
+	errorContentType string
+	errorBody        []byte
+
+	// Read or generate the request ids.
+	requestID *requestID
+	// Continue the W3C trace context.
+	traceContext bool
+
+	registry *metrics.Registry
+	metrics  *proxyMetrics
 
 	bytespool *bytesPool
 }
@@ -94,6 +135,7 @@ type Proxy struct {
 func New(upstream string, options ...proxyOption) (*Proxy, error) {
 	p := &Proxy{
 		ServeMux:  *http.NewServeMux(),
+		routes:    map[string]*route{},
 		bytespool: newBytesPool(0),
 	}
 
@@ -105,8 +147,20 @@ func New(upstream string, options ...proxyOption) (*Proxy, error) {
 	if p.logger == nil {
 		p.logger = slog.Default()
 	}
+	if p.registry != nil {
+		p.metrics = newProxyMetrics(p.registry)
+	}
+	if p.async {
+		p.dispatcher = newDispatcher(p.queueSize, p.workers, p.dropPolicy, p.logger)
+		p.dispatcher.metrics = p.metrics
+	}
 
 	var err error
+	if p.requestID != nil {
+		if p.requestID, err = newRequestID(p.requestID.header, p.requestID.format); err != nil {
+			return nil, err
+		}
+	}
 	// Parse upstream.
 	if p.upstream, err = url.Parse(upstream); err != nil {
 		return nil, fmt.Errorf("error parsing upstream: %w", err)
@@ -115,6 +169,7 @@ func New(upstream string, options ...proxyOption) (*Proxy, error) {
 }
 
 type proxyOption = func(p *Proxy)
+type ProxyOptions []proxyOption
 
 func WithLogger(logger *slog.Logger) proxyOption {
 	return proxyOption(func(p *Proxy) {
@@ -122,25 +177,156 @@ func WithLogger(logger *slog.Logger) proxyOption {
 	})
 }
 
+// WithAsyncDispatch calls the taps from a pool of workers instead of
+// from the server goroutine. Records are queued in a bounded queue,
+// policy decides what to do when the queue is full.
+// Call Flush to drain the queue on shutdown.
+func WithAsyncDispatch(queueSize, workers int, policy DropPolicy) proxyOption {
+	return proxyOption(func(p *Proxy) {
+		p.async = true
+		p.queueSize = queueSize
+		p.workers = workers
+		p.dropPolicy = policy
+	})
+}
+
+// WithErrorResponse sets the body of the 502 response that is written
+// when the exchange with the upstream fails.
+func WithErrorResponse(contentType string, body []byte) proxyOption {
+	return proxyOption(func(p *Proxy) {
+		p.errorContentType = contentType
+		p.errorBody = body
+	})
+}
+
+// WithRequestID reads the request id from header, X-Request-Id when empty,
+// or generates one in format when the request has none.
+// The id is forwarded upstream, returned to the client, recorded
+// in RequestResponse.RequestID and added to the request logger.
+func WithRequestID(header string, format RequestIDFormat) proxyOption {
+	return proxyOption(func(p *Proxy) {
+		p.requestID = &requestID{header: header, format: format}
+	})
+}
+
+// WithTraceContext makes the proxy a hop of the W3C trace of the request.
+// The proxy continues the trace in traceparent or starts a new one, and passes
+// the span of the upstream call in traceparent. The spans are recorded in
+// RequestResponse.Trace.
+func WithTraceContext() proxyOption {
+	return proxyOption(func(p *Proxy) {
+		p.traceContext = true
+	})
+}
+
+// WithMetrics adds the metrics of the proxy and the taps to reg.
+func WithMetrics(reg *metrics.Registry) proxyOption {
+	return proxyOption(func(p *Proxy) {
+		p.registry = reg
+	})
+}
+
+// WithGlobalIncludeHeaders sets the headers that are included for all taps.
+func WithGlobalIncludeHeaders(header []string) proxyOption {
+	return proxyOption(func(p *Proxy) {
+		p.includeHeaders = canonicalHeaders(header)
+	})
+}
+
+// WithGlobalExcludeHeaders sets the headers that are excluded for all taps.
+func WithGlobalExcludeHeaders(header []string) proxyOption {
+	return proxyOption(func(p *Proxy) {
+		p.excludeHeaders = canonicalHeaders(header)
+	})
+}
+
+// WithGlobalHeaderMasks sets the header masks for all taps.
+func WithGlobalHeaderMasks(masks ...HeaderMask) proxyOption {
+	return proxyOption(func(p *Proxy) {
+		p.headerMasks = masks
+	})
+}
+
 // Tap options can modify the handler.
 // The passed handler has proxy, logger and upstream set.
 type tapOption func(p *Handler)
 type TapOptions []tapOption
 
+// Tap adds tap to the patterns, see AddTap. It panics when a pattern or an
+// option is invalid, like ServeMux.Handle does for patterns.
 func (p *Proxy) Tap(patterns []string, tap Tap, options ...tapOption) {
+	if err := p.AddTap(patterns, tap, options...); err != nil {
+		panic(fmt.Sprintf("httptap: %s", err))
+	}
+}
+
+// AddTap adds tap to the patterns. Nothing is added when a pattern is invalid
+// or conflicts with the patterns of the other taps, or when an option cannot
+// be applied, the error tells why.
+func (p *Proxy) AddTap(patterns []string, tap Tap, options ...tapOption) error {
 	logger := p.logger
 	h := NewHandler(p.upstream, p, tap, logger, options...)
+	if err := errors.Join(h.Err(), p.checkPatterns(patterns)); err != nil {
+		return err
+	}
 
-	rp := &httputil.ReverseProxy{
+	h.rp = &httputil.ReverseProxy{
 		Rewrite:        h.rewrite,
 		ModifyResponse: h.modifyResponse,
+		ErrorHandler:   h.errorHandler,
 		ErrorLog:       slog.NewLogLogger(logger.Handler(), slog.LevelError),
 		BufferPool:     p.bytespool,
 	}
+	// Send the part of a truncated body before the connection is aborted,
+	// and deliver the chunks of a throttled body as they come.
+	if (h.faults != nil && h.faults.Truncate != nil) || (h.network != nil && h.network.download()) {
+		h.rp.FlushInterval = -1
+	}
+	h.patterns = append([]string(nil), patterns...)
+	p.mu.Lock()
+	p.handlers = append(p.handlers, h)
+	p.mu.Unlock()
+
+	// Add the handler to the route of the pattern, taps on the same pattern share the route.
+	for _, pattern := range patterns {
+		if rt, ok := p.routes[pattern]; ok {
+			rt.add(h)
+			continue
+		}
+		rt := newRoute(pattern, h)
+		p.routes[pattern] = rt
+		p.ServeMux.Handle(pattern, rt)
+	}
+	return nil
+}
+
+// registeredAt is the source location of a conflicting pattern in the panic
+// of ServeMux, it is the location in the proxy, not of the caller.
+var registeredAt = regexp.MustCompile(` \(registered at [^)]*\)`)
+
+// checkPatterns reports the patterns that ServeMux would panic on, the invalid
+// ones and the ones that conflict with the patterns of the proxy.
+// The patterns of the proxy are shared, not conflicting.
+func (p *Proxy) checkPatterns(patterns []string) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			msg := registeredAt.ReplaceAllString(fmt.Sprint(v), "")
+			err = errors.New(strings.ReplaceAll(msg, "\n", " "))
+		}
+	}()
+	mux := http.NewServeMux()
+	seen := map[string]bool{}
+	for pattern := range p.routes {
+		seen[pattern] = true
+		mux.Handle(pattern, http.NotFoundHandler())
+	}
 	for _, pattern := range patterns {
-		p.ServeMux.Handle(pattern, rp)
-		p.hasDefault = p.hasDefault || pattern == "/"
+		if !seen[pattern] {
+			seen[pattern] = true
+			mux.Handle(pattern, http.NotFoundHandler())
+		}
 	}
+	return nil
 }
 
 func nopTap(logger *slog.Logger) TapFunc {
@@ -151,20 +337,29 @@ func nopTap(logger *slog.Logger) TapFunc {
 }
 
 func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if !p.hasDefault {
-		p.Tap([]string{"/"}, nopTap(p.logger), WithRequestBody(false), WithResponseBody(false))
-		p.hasDefault = true
-	}
+	// Proxy the requests that do not match a tap.
+	p.defaultOnce.Do(func() {
+		if _, ok := p.routes["/"]; !ok {
+			p.Tap([]string{"/"}, nopTap(p.logger), WithRequestBody(false), WithResponseBody(false))
+		}
+	})
 	// Add the request context to the request.
 	rc := &RequestContext{
 		Logger: p.logger,
 	}
 	r = r.WithContext(withRequestContext(r.Context(), rc))
+	p.setRequestID(w, r, rc)
+	p.startTrace(r, rc)
+
+	// Serve the record also when the handler panics, e.g. with http.ErrAbortHandler
+	// when the upstream breaks off the response.
+	defer p.finish(r, rc)
 	p.ServeMux.ServeHTTP(w, r)
-	rc.closers = append(rc.closers, r.Body)
+}
 
-	// Call the handler.
-	rc.Handler.Serve(r.Context(), rc.RequestResponse)
+// finish closes the bodies and passes the record to the taps.
+func (p *Proxy) finish(r *http.Request, rc *RequestContext) {
+	rc.closers = append(rc.closers, r.Body)
 
 	// Close all bodies.
 	for _, c := range rc.closers {
@@ -172,12 +367,51 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			c.Close()
 		}
 	}
+
+	// The request was not handled by a tap, e.g. redirected by the ServeMux.
+	rt, rr := rc.route, rc.RequestResponse
+	if rt == nil || rr == nil {
+		return
+	}
+
+	p.metrics.observe(rt, rr)
+
+	// Drop the records of unsampled requests that are not kept.
+	if !rt.keep(rr) {
+		rt.release(rr)
+		return
+	}
+
+	// Hand the record to the dispatcher, the request context ends when we return.
+	if p.dispatcher != nil {
+		p.dispatcher.dispatch(context.WithoutCancel(r.Context()), rt, rr)
+		return
+	}
+
+	// Call the handlers.
+	rt.serve(r.Context(), rr)
 }
 
-func canonicalHeaders(header []string) []string {
-	var res = make([]string, 0, len(header))
-	for _, h := range header {
-		res = append(res, http.CanonicalHeaderKey(h))
+// Flush waits until all queued records are served by the taps.
+// The proxy does not queue new records after Flush is called.
+func (p *Proxy) Flush(ctx context.Context) error {
+	if p.dispatcher == nil {
+		return nil
 	}
-	return res
+	err := p.dispatcher.flush(ctx)
+	stats := p.dispatcher.stats()
+	p.logger.Info("dispatcher flushed",
+		slog.Uint64("queued", stats.Queued),
+		slog.Uint64("dispatched", stats.Dispatched),
+		slog.Uint64("dropped", stats.Dropped),
+	)
+	return err
+}
+
+// DispatchStats returns the counters of the asynchronous dispatcher.
+func (p *Proxy) DispatchStats() DispatchStats {
+	if p.dispatcher == nil {
+		return DispatchStats{}
+	}
+	return p.dispatcher.stats()
 }
@@ -0,0 +1,267 @@
+package httptap
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/myhops/httptap/jsonpath"
+	"github.com/myhops/httptap/yamljson"
+)
+
+// RedactAction is what happens with a value selected by a RedactRule.
+type RedactAction string
+
+const (
+	// RedactMask replaces the value with ***.
+	RedactMask RedactAction = "mask"
+	// RedactRemove removes the value.
+	RedactRemove RedactAction = "remove"
+	// RedactHash replaces the value with its SHA-256 hash.
+	RedactHash RedactAction = "hash"
+	// RedactHMAC replaces the value with a keyed HMAC-SHA256 token,
+	// the same value always maps to the same token.
+	RedactHMAC RedactAction = "hmac"
+)
+
+// RedactRule selects values in bodies with a JSONPath, e.g. $..email
+// or $.items[*].iban. Form fields and multipart values are selected by
+// their name, e.g. $.password.
+type RedactRule struct {
+	Path   string
+	Action RedactAction
+}
+
+type redactRule struct {
+	path   *jsonpath.Path
+	action RedactAction
+}
+
+// redactor redacts bodies before the taps see them.
+type redactor struct {
+	rules []redactRule
+	key   []byte
+}
+
+func newRedactor(rules []RedactRule) (*redactor, error) {
+	r := &redactor{}
+	for _, rule := range rules {
+		p, err := jsonpath.Compile(rule.Path)
+		if err != nil {
+			return nil, err
+		}
+		switch rule.Action {
+		case RedactMask, RedactRemove, RedactHash, RedactHMAC:
+		default:
+			return nil, fmt.Errorf("bad redact action %q for %s", rule.Action, rule.Path)
+		}
+		r.rules = append(r.rules, redactRule{path: p, action: rule.Action})
+	}
+	return r, nil
+}
+
+// needsKey reports if a rule pseudonymizes with HMAC.
+func (r *redactor) needsKey() bool {
+	for _, rule := range r.rules {
+		if rule.action == RedactHMAC {
+			return true
+		}
+	}
+	return false
+}
+
+// valueBytes returns the bytes that are hashed for v.
+// Strings are hashed without the JSON quotes.
+func valueBytes(v any) []byte {
+	if s, ok := v.(string); ok {
+		return []byte(s)
+	}
+	b, _ := json.Marshal(v)
+	return b
+}
+
+func (r *redactor) replace(action RedactAction, v any) (any, bool) {
+	switch action {
+	case RedactRemove:
+		return nil, true
+	case RedactHash:
+		sum := sha256.Sum256(valueBytes(v))
+		return "sha256:" + hex.EncodeToString(sum[:]), false
+	case RedactHMAC:
+		m := hmac.New(sha256.New, r.key)
+		m.Write(valueBytes(v))
+		return "hmac:" + hex.EncodeToString(m.Sum(nil)), false
+	default:
+		return maskedValue, false
+	}
+}
+
+// redact applies the rules to doc.
+func (r *redactor) redact(doc any) any {
+	for _, rule := range r.rules {
+		action := rule.action
+		doc = rule.path.Apply(doc, func(v any) (any, bool) {
+			return r.replace(action, v)
+		})
+	}
+	return doc
+}
+
+// redactJSON redacts the JSON document in b.
+func (r *redactor) redactJSON(b []byte) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var doc any
+	if err := d.Decode(&doc); err != nil {
+		return nil, err
+	}
+	doc = r.redact(doc)
+
+	var buf bytes.Buffer
+	e := json.NewEncoder(&buf)
+	e.SetEscapeHTML(false)
+	if err := e.Encode(doc); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// randomKey returns a key for HMAC tokens that are stable for the lifetime of the process.
+func randomKey() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}
+
+// redactNDJSON redacts each JSON document of newline delimited JSON.
+func (r *redactor) redactNDJSON(b []byte) ([]byte, error) {
+	var res [][]byte
+	for _, line := range bytes.Split(b, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		rl, err := r.redactJSON(line)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, rl)
+	}
+	return append(bytes.Join(res, []byte("\n")), '\n'), nil
+}
+
+// redactYAML redacts the YAML document in b, it is converted to JSON and back.
+func (r *redactor) redactYAML(b []byte) ([]byte, error) {
+	j, err := yamljson.Y2J(b)
+	if err != nil {
+		return nil, err
+	}
+	if j, err = r.redactJSON(j); err != nil {
+		return nil, err
+	}
+	return yamljson.J2Y(j)
+}
+
+// redactForm redacts the url encoded form in b. The rules select the fields
+// like in the map of decodeForm, e.g. $.password.
+func (r *redactor) redactForm(b []byte) ([]byte, error) {
+	doc, err := decodeForm(b, nil)
+	if err != nil {
+		return nil, err
+	}
+	doc = r.redact(doc)
+	v := url.Values{}
+	for k, x := range doc.(map[string]any) {
+		switch x := x.(type) {
+		case []any:
+			for _, xx := range x {
+				v.Add(k, fmt.Sprint(xx))
+			}
+		default:
+			v.Add(k, fmt.Sprint(x))
+		}
+	}
+	return []byte(v.Encode()), nil
+}
+
+// redactMultipart redacts the values of the text parts, the rules select
+// them by field name, e.g. $.password. Removed values are emptied.
+func (r *redactor) redactMultipart(parts []MultipartPart) {
+	for i := range parts {
+		p := &parts[i]
+		if p.Value == "" {
+			continue
+		}
+		doc := r.redact(map[string]any{p.FieldName: p.Value}).(map[string]any)
+		v, ok := doc[p.FieldName]
+		if !ok {
+			p.Value = ""
+			continue
+		}
+		p.Value = fmt.Sprint(v)
+	}
+}
+
+// bodyRedactor returns the function that redacts bodies of media type mt,
+// nil when the rules cannot be applied to the media type.
+func (r *redactor) bodyRedactor(mt string) func([]byte) ([]byte, error) {
+	switch mt {
+	case mediaTypeJSON:
+		return r.redactJSON
+	case "application/x-ndjson", "application/jsonl":
+		return r.redactNDJSON
+	case "application/yaml", "application/x-yaml", "text/yaml":
+		return r.redactYAML
+	case "application/x-www-form-urlencoded":
+		return r.redactForm
+	}
+	return nil
+}
+
+// redactBody redacts the captured body in buf. The bodies the rules cannot
+// be applied to, like XML, multipart and spilled bodies, are withheld from
+// the taps, so the decoded bodies are not set either.
+func (h *Handler) redactBody(logger *slog.Logger, hdr http.Header, buf **bytes.Buffer, file *string) {
+	if *file != "" {
+		logger.Warn("spilled body cannot be redacted, withheld from taps")
+		*file = ""
+		return
+	}
+	if *buf == nil || (*buf).Len() == 0 {
+		return
+	}
+	_, _, mt, err := bodyDecoderFor(hdr)
+	redact := h.redactor.bodyRedactor(mt)
+	if err != nil || redact == nil {
+		logger.Debug("body cannot be redacted, withheld from taps", slog.String("content_type", hdr.Get("Content-Type")))
+		*buf = nil
+		return
+	}
+	b, err := redact((*buf).Bytes())
+	if err != nil {
+		// Do not pass bodies to the taps that might contain the values.
+		logger.Error("cannot redact body, withheld from taps", slog.String("err", err.Error()))
+		*buf = nil
+		return
+	}
+	(*buf).Reset()
+	(*buf).Write(b)
+}
+
+// redactBodies applies the redaction rules to the captured bodies and
+// to the values of the multipart request.
+func (h *Handler) redactBodies(rr *RequestResponse) {
+	if h.redactor == nil || len(h.redactor.rules) == 0 {
+		return
+	}
+	logger := h.log(rr).With(slog.String("step", "redactBodies"))
+	h.redactBody(logger, rr.ReqHeader, &rr.ReqBody, &rr.ReqBodyFile)
+	h.redactBody(logger, rr.RespHeader, &rr.RespBody, &rr.RespBodyFile)
+	h.redactor.redactMultipart(rr.ReqMultipart)
+}
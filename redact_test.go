@@ -0,0 +1,139 @@
+package httptap
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRedactJSON(t *testing.T) {
+	r, err := newRedactor([]RedactRule{
+		{Path: "$..email", Action: RedactHMAC},
+		{Path: "$.items[*].iban", Action: RedactMask},
+		{Path: "$.password", Action: RedactRemove},
+		{Path: "$.phone", Action: RedactHash},
+	})
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	r.key = []byte("test key")
+
+	in := []byte(`{"email":"a@example.com","password":"secret","phone":"0612345678",` +
+		`"amount":12345678901234567890,"items":[{"iban":"NL91ABNA0417164300"},{"note":"<b>"}],` +
+		`"contact":{"email":"a@example.com"}}`)
+	out, err := r.redactJSON(in)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	for _, leaked := range []string{"a@example.com", "secret", "0612345678", "NL91ABNA0417164300"} {
+		if strings.Contains(string(out), leaked) {
+			t.Errorf("output contains %s: %s", leaked, out)
+		}
+	}
+	// Large numbers and HTML are not mangled.
+	if !strings.Contains(string(out), "12345678901234567890") || !strings.Contains(string(out), "<b>") {
+		t.Errorf("output changed other values: %s", out)
+	}
+
+	var doc map[string]any
+	if err := json.Unmarshal(out, &doc); err != nil {
+		t.Fatalf("output is not JSON: %s", err)
+	}
+	if _, ok := doc["password"]; ok {
+		t.Errorf("password not removed")
+	}
+	// The same value gives the same token.
+	token := doc["email"].(string)
+	if !strings.HasPrefix(token, "hmac:") || token != doc["contact"].(map[string]any)["email"] {
+		t.Errorf("tokens differ: %v", doc)
+	}
+	if !strings.HasPrefix(doc["phone"].(string), "sha256:") {
+		t.Errorf("phone not hashed: %v", doc["phone"])
+	}
+}
+
+func TestRedactorBadRules(t *testing.T) {
+	if _, err := newRedactor([]RedactRule{{Path: "email", Action: RedactMask}}); err == nil {
+		t.Errorf("expected error for bad path")
+	}
+	if _, err := newRedactor([]RedactRule{{Path: "$.email", Action: "shred"}}); err == nil {
+		t.Errorf("expected error for bad action")
+	}
+}
+
+func TestRedactBody(t *testing.T) {
+	r, err := newRedactor([]RedactRule{
+		{Path: "$..email", Action: RedactMask},
+		{Path: "$.password", Action: RedactRemove},
+	})
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	h := &Handler{redactor: r}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tests := []struct {
+		contentType string
+		body        string
+		withheld    bool
+	}{
+		{contentType: "application/json", body: `{"email":"a@example.com","password":"secret"}`},
+		{contentType: "application/x-ndjson", body: "{\"email\":\"a@example.com\"}\n{\"password\":\"secret\"}\n"},
+		{contentType: "application/yaml", body: "email: a@example.com\npassword: secret\n"},
+		{contentType: "application/x-www-form-urlencoded", body: "email=a%40example.com&password=secret&x=1"},
+		{contentType: "application/xml", body: "<user><email>a@example.com</email></user>", withheld: true},
+		{contentType: "multipart/form-data; boundary=b", body: "--b\r\n\r\na@example.com\r\n--b--\r\n", withheld: true},
+		{contentType: "text/plain", body: "a@example.com", withheld: true},
+		{contentType: "", body: "a@example.com", withheld: true},
+	}
+	for _, tt := range tests {
+		hdr := http.Header{}
+		if tt.contentType != "" {
+			hdr.Set("Content-Type", tt.contentType)
+		}
+		buf := bytes.NewBufferString(tt.body)
+		file := ""
+		h.redactBody(logger, hdr, &buf, &file)
+		if tt.withheld {
+			if buf != nil {
+				t.Errorf("%s: body not withheld: %s", tt.contentType, buf)
+			}
+			continue
+		}
+		if buf == nil {
+			t.Errorf("%s: body withheld", tt.contentType)
+			continue
+		}
+		for _, leaked := range []string{"a@example.com", "a%40example.com", "secret"} {
+			if strings.Contains(buf.String(), leaked) {
+				t.Errorf("%s: output contains %s: %s", tt.contentType, leaked, buf)
+			}
+		}
+		if _, _, err := decodeBody(hdr, buf.Bytes()); err != nil {
+			t.Errorf("%s: output cannot be decoded: %s", tt.contentType, err)
+		}
+	}
+}
+
+func TestRedactMultipart(t *testing.T) {
+	r, err := newRedactor([]RedactRule{
+		{Path: "$.email", Action: RedactMask},
+		{Path: "$.password", Action: RedactRemove},
+	})
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	parts := []MultipartPart{
+		{FieldName: "email", Value: "a@example.com"},
+		{FieldName: "password", Value: "secret"},
+		{FieldName: "name", Value: "alice"},
+	}
+	r.redactMultipart(parts)
+	if parts[0].Value != maskedValue || parts[1].Value != "" || parts[2].Value != "alice" {
+		t.Errorf("got %+v", parts)
+	}
+}
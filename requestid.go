@@ -0,0 +1,137 @@
+package httptap
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// RequestIDFormat is the format of the generated request ids.
+type RequestIDFormat string
+
+const (
+	// RequestIDUUIDv7 generates time ordered UUIDs, RFC 9562.
+	RequestIDUUIDv7 RequestIDFormat = "uuidv7"
+	// RequestIDULID generates ULIDs, 26 characters of Crockford base32.
+	RequestIDULID RequestIDFormat = "ulid"
+)
+
+// maxRequestIDLength is the length of the longest incoming id that is accepted,
+// a longer id is replaced.
+const maxRequestIDLength = 128
+
+type requestID struct {
+	header string
+	format RequestIDFormat
+}
+
+func newRequestID(header string, format RequestIDFormat) (*requestID, error) {
+	if header == "" {
+		header = "X-Request-Id"
+	}
+	switch format {
+	case "":
+		format = RequestIDUUIDv7
+	case RequestIDUUIDv7, RequestIDULID:
+	default:
+		return nil, fmt.Errorf("unknown request id format %q", format)
+	}
+	return &requestID{header: http.CanonicalHeaderKey(header), format: format}, nil
+}
+
+// get returns the id of the incoming request or a new one.
+func (g *requestID) get(r *http.Request) string {
+	if id := r.Header.Get(g.header); id != "" && len(id) <= maxRequestIDLength {
+		return id
+	}
+	return g.generate(time.Now())
+}
+
+func (g *requestID) generate(now time.Time) string {
+	if g.format == RequestIDULID {
+		return newULID(now)
+	}
+	return newUUIDv7(now)
+}
+
+// newUUIDv7 returns a UUID with the unix time in milliseconds in the first 48 bits.
+func newUUIDv7(now time.Time) string {
+	var u [16]byte
+	rand.Read(u[6:])
+	ms := uint64(now.UnixMilli())
+	u[0] = byte(ms >> 40)
+	u[1] = byte(ms >> 32)
+	binary.BigEndian.PutUint32(u[2:6], uint32(ms))
+	u[6] = u[6]&0x0f | 0x70 // version 7
+	u[8] = u[8]&0x3f | 0x80 // variant 10
+
+	var b [36]byte
+	hex.Encode(b[0:8], u[0:4])
+	b[8] = '-'
+	hex.Encode(b[9:13], u[4:6])
+	b[13] = '-'
+	hex.Encode(b[14:18], u[6:8])
+	b[18] = '-'
+	hex.Encode(b[19:23], u[8:10])
+	b[23] = '-'
+	hex.Encode(b[24:], u[10:])
+	return string(b[:])
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newULID returns a ULID, 48 bits of unix time in milliseconds and 80 random bits.
+func newULID(now time.Time) string {
+	var u [16]byte
+	rand.Read(u[6:])
+	ms := uint64(now.UnixMilli())
+	u[0] = byte(ms >> 40)
+	u[1] = byte(ms >> 32)
+	binary.BigEndian.PutUint32(u[2:6], uint32(ms))
+
+	// 128 bits in 26 characters of 5 bits, the first character has 3 bits.
+	hi := binary.BigEndian.Uint64(u[:8])
+	lo := binary.BigEndian.Uint64(u[8:])
+	var b [26]byte
+	for i := 25; i >= 0; i-- {
+		b[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(b[:])
+}
+
+// setRequestID adds the id to the request, so it is forwarded upstream,
+// and to the response.
+func (p *Proxy) setRequestID(w http.ResponseWriter, r *http.Request, rc *RequestContext) {
+	if p.requestID == nil {
+		return
+	}
+	id := p.requestID.get(r)
+	r.Header.Set(p.requestID.header, id)
+	w.Header().Set(p.requestID.header, id)
+	rc.RequestID = id
+	rc.Logger = rc.Logger.With(slog.String("request_id", id))
+}
+
+// echoRequestID removes the id from the upstream response, the client gets the
+// one that is already set by the proxy. The record shows the id as sent.
+func (h *Handler) echoRequestID(rr *RequestResponse, r *http.Response) {
+	if h.p == nil || h.p.requestID == nil || rr.RequestID == "" {
+		return
+	}
+	r.Header.Del(h.p.requestID.header)
+	rr.RespHeader.Set(h.p.requestID.header, rr.RequestID)
+}
+
+// log returns the logger of the handler for the request.
+func (h *Handler) log(rr *RequestResponse) *slog.Logger {
+	if rr == nil || rr.RequestID == "" {
+		return h.logger
+	}
+	return h.logger.With(slog.String("request_id", rr.RequestID))
+}
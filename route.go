@@ -0,0 +1,198 @@
+package httptap
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// route is the handler of a pattern. The first handler proxies the request,
+// the record is served to all handlers in the order they were added.
+// The bodies are captured once, with the union of the options of the handlers.
+type route struct {
+	pattern  string
+	handlers []*Handler
+	// wildcards are the names of the wildcards in the pattern.
+	wildcards []string
+
+	// The union of the capture limits of the handlers.
+	reqLimits  captureLimits
+	respLimits captureLimits
+}
+
+func newRoute(pattern string, h *Handler) *route {
+	return &route{
+		pattern:    pattern,
+		handlers:   []*Handler{h},
+		wildcards:  patternWildcards(pattern),
+		reqLimits:  h.reqLimits,
+		respLimits: h.respLimits,
+	}
+}
+
+func (rt *route) add(h *Handler) {
+	rt.handlers = append(rt.handlers, h)
+	rt.reqLimits = unionLimits(rt.reqLimits, h.reqLimits)
+	rt.respLimits = unionLimits(rt.respLimits, h.respLimits)
+}
+
+// patternWildcards returns the names of the wildcards in a ServeMux pattern,
+// like id in "GET /orders/{id}" and path in "/files/{path...}".
+func patternWildcards(pattern string) []string {
+	// Skip the method and the host.
+	if i := strings.IndexByte(pattern, '/'); i >= 0 {
+		pattern = pattern[i:]
+	}
+	var res []string
+	for _, seg := range strings.Split(pattern, "/") {
+		if !strings.HasPrefix(seg, "{") || !strings.HasSuffix(seg, "}") {
+			continue
+		}
+		name := strings.TrimSuffix(seg[1:len(seg)-1], "...")
+		if name != "" && name != "$" {
+			res = append(res, name)
+		}
+	}
+	return res
+}
+
+// unionLimits returns limits that capture what a and b capture.
+// The lowest spill threshold is used.
+func unionLimits(a, b captureLimits) captureLimits {
+	res := a
+	if a.maxBytes == 0 || b.maxBytes == 0 {
+		res.maxBytes = 0
+	} else {
+		res.maxBytes = max(a.maxBytes, b.maxBytes)
+	}
+	if b.spillThreshold > 0 && (a.spillThreshold == 0 || b.spillThreshold < a.spillThreshold) {
+		res.spillThreshold = b.spillThreshold
+		res.spillDir = b.spillDir
+	}
+	return res
+}
+
+// ServeHTTP adds the handler and a new RequestResponse to the request context
+// and proxies the request with the first handler.
+func (rt *route) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc := RequestContextValue(r.Context())
+	if rc == nil {
+		rc = &RequestContext{Logger: rt.handlers[0].logger}
+		r = r.WithContext(withRequestContext(r.Context(), rc))
+	}
+	h := rt.handlers[0]
+
+	rr := &RequestResponse{
+		Start:      time.Now(),
+		RequestID:  rc.RequestID,
+		Trace:      rc.Trace,
+		Pattern:    rt.pattern,
+		reqLimits:  rt.reqLimits,
+		respLimits: rt.respLimits,
+	}
+	if len(rt.wildcards) > 0 {
+		rr.PathValues = make(map[string]string, len(rt.wildcards))
+		for _, name := range rt.wildcards {
+			rr.PathValues[name] = r.PathValue(name)
+		}
+	}
+	// Decide before the bodies are captured.
+	rr.sampled = make([]bool, len(rt.handlers))
+	for i, hh := range rt.handlers {
+		rr.sampled[i] = hh.sample(r, rr.RequestID)
+		rr.captureRequest = rr.captureRequest || (hh.withRequestBody.Load() && rr.sampled[i])
+		rr.captureResponse = rr.captureResponse || (hh.withResponseBody.Load() && rr.sampled[i])
+	}
+	rr.Sampled = rr.sampled[0]
+
+	// Add myself and the request response to the request context.
+	rc.Handler = h
+	rc.RequestResponse = rr
+	rc.route = rt
+
+	h.serveHTTP(w, r, rr)
+}
+
+// keep reports if any handler serves the record.
+func (rt *route) keep(rr *RequestResponse) bool {
+	for i, h := range rt.handlers {
+		if h.keep(rr, rr.sampled[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// serve serves the record to the handlers. Every handler gets its own copy,
+// as the handlers change the record.
+func (rt *route) serve(ctx context.Context, rr *RequestResponse) {
+	if len(rt.handlers) == 1 {
+		rt.handlers[0].Serve(ctx, rr)
+		return
+	}
+	rt.handlers[0].finishCapture(rr)
+	for i, h := range rt.handlers {
+		if !h.keep(rr, rr.sampled[i]) {
+			continue
+		}
+		h.Serve(ctx, rr.cloneFor(h, rr.sampled[i]))
+	}
+	rt.release(rr)
+}
+
+// release returns the body buffers to the pool and removes the spill files.
+func (rt *route) release(rr *RequestResponse) {
+	rt.handlers[0].release(rr)
+}
+
+// cloneFor returns a copy of rr with the bodies that h asked for.
+func (rr *RequestResponse) cloneFor(h *Handler, sampled bool) *RequestResponse {
+	c := *rr
+	c.reqCapture = nil
+	c.respCapture = nil
+	c.Sampled = sampled
+
+	if rr.URL != nil {
+		u := *rr.URL
+		c.URL = &u
+	}
+	c.ReqHeader = rr.ReqHeader.Clone()
+	c.ReqTrailer = rr.ReqTrailer.Clone()
+	c.RespHeader = rr.RespHeader.Clone()
+	c.RespTrailer = rr.RespTrailer.Clone()
+	c.Faults = slices.Clone(rr.Faults)
+	c.PathValues = maps.Clone(rr.PathValues)
+	if rr.Trace != nil {
+		tc := *rr.Trace
+		c.Trace = &tc
+	}
+
+	withReq := h.withRequestBody.Load() && sampled
+	c.ReqBody = cloneBody(rr.ReqBody, withReq, h.reqLimits.maxBytes, &c.ReqBodyTruncated)
+	if !withReq {
+		c.ReqBodyFile = ""
+	}
+	withResp := h.withResponseBody.Load() && sampled
+	c.RespBody = cloneBody(rr.RespBody, withResp, h.respLimits.maxBytes, &c.RespBodyTruncated)
+	if !withResp {
+		c.RespBodyFile = ""
+	}
+	return &c
+}
+
+// cloneBody copies at most maxBytes of b, 0 is unlimited.
+func cloneBody(b *bytes.Buffer, enabled bool, maxBytes int64, truncated *bool) *bytes.Buffer {
+	if b == nil || !enabled {
+		return nil
+	}
+	data := b.Bytes()
+	if maxBytes > 0 && int64(len(data)) > maxBytes {
+		data = data[:maxBytes]
+		*truncated = true
+	}
+	return bytes.NewBuffer(slices.Clone(data))
+}
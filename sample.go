@@ -0,0 +1,88 @@
+package httptap
+
+import (
+	"hash/fnv"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type sampling struct {
+	rate           float64
+	keepErrors     bool
+	keepSlowerThan time.Duration
+}
+
+// sampleKey returns the key of the sampling decision, the trace id of the
+// W3C traceparent header or the request id. All hops of a trace get the
+// same decision. The request id is the one of WithRequestID, or else the
+// X-Request-Id header.
+func sampleKey(h http.Header, requestID string) string {
+	// traceparent: version-traceid-parentid-flags
+	if tp := h.Get("Traceparent"); tp != "" {
+		parts := strings.Split(tp, "-")
+		if len(parts) == 4 && len(parts[1]) == 32 {
+			return parts[1]
+		}
+	}
+	if requestID != "" {
+		return requestID
+	}
+	return h.Get("X-Request-Id")
+}
+
+// sample decides if the request with requestID is sampled.
+func (s *sampling) sample(r *http.Request, requestID string) bool {
+	if s.rate >= 1 {
+		return true
+	}
+	if s.rate <= 0 {
+		return false
+	}
+	key := sampleKey(r.Header, requestID)
+	if key == "" {
+		return rand.Float64() < s.rate
+	}
+	f := fnv.New64a()
+	f.Write([]byte(key))
+	return float64(mix(f.Sum64())) < s.rate*math.MaxUint64
+}
+
+// mix spreads the bits of the hash, keys that differ only in the last
+// characters give hashes with almost the same high bits.
+func mix(x uint64) uint64 {
+	// The finalizer of splitmix64.
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// keep reports if the record of an unsampled request is kept anyway.
+func (s *sampling) keep(rr *RequestResponse) bool {
+	if s.keepErrors && (rr.Error != nil || rr.StatusCode >= http.StatusInternalServerError) {
+		return true
+	}
+	return s.keepSlowerThan > 0 && rr.Duration >= s.keepSlowerThan
+}
+
+// sample decides if the request with requestID is sampled for the tap.
+// The requests are not sampled for a disabled tap.
+func (h *Handler) sample(r *http.Request, requestID string) bool {
+	if h.disabled.Load() {
+		return false
+	}
+	return h.sampling == nil || h.sampling.sample(r, requestID)
+}
+
+// keep reports if the record is served to the tap.
+func (h *Handler) keep(rr *RequestResponse, sampled bool) bool {
+	if h.disabled.Load() {
+		return false
+	}
+	return sampled || (h.sampling != nil && h.sampling.keep(rr))
+}
@@ -6,12 +6,28 @@ import (
 	"net/http"
 	"net/url"
 	"time"
+
+	"github.com/myhops/httptap/pii"
 )
 
 type RequestResponse struct {
 	Start    time.Time
 	End      time.Time
 	Duration time.Duration
+	// Timing is the breakdown of the exchange with the upstream,
+	// nil when the upstream was not called.
+	Timing *Timing
+	// RequestID is the id of the request, see WithRequestID.
+	RequestID string
+	// Trace is the W3C trace context, see WithTraceContext.
+	Trace *TraceContext
+
+	// Pattern is the ServeMux pattern that matched the request and
+	// PathValues are the values of its wildcards, like id in "GET /orders/{id}".
+	Pattern    string
+	PathValues map[string]string
+	// TapName is the name of the tap that serves the record.
+	TapName string
 
 	Host       string
 	URL        *url.URL
@@ -21,8 +37,32 @@ type RequestResponse struct {
 	ReqTrailer http.Header
 	// This buffer is valid until the end of Serve.
 	// Do not read from this buffer, but create a reader from it.
+	// ReqBody is nil when the body is spilled to ReqBodyFile.
 	ReqBody     *bytes.Buffer
 	ReqBodyJSON any
+	// ReqBodyDecoded is the body decoded with the BodyDecoder registered
+	// for its Content-Type.
+	ReqBodyDecoded any
+	// ReqBodySize is the size of the body as sent, also when it is not captured.
+	ReqBodySize int64
+	// ReqBodyTruncated is set when the body is larger than the capture limit.
+	ReqBodyTruncated bool
+	// ReqBodyFile is the name of the file that contains the captured body when
+	// it exceeded the spill threshold. The file is removed at the end of Serve.
+	ReqBodyFile string
+	// ReqBodyEncoding is the Content-Encoding that was removed from the captured
+	// body, ReqBodyDecodedSize is the size after decoding.
+	// The forwarded body is never decoded.
+	ReqBodyEncoding    string
+	ReqBodyDecodedSize int64
+	// ReqBodyDecodeError is set when the Content-Encoding of the captured body
+	// cannot be removed, e.g. when it is corrupt. ReqBody is then encoded.
+	ReqBodyDecodeError string
+	// ReqMultipart describes the parts of a multipart/form-data body.
+	// ReqMultipartError is set when the body cannot be parsed completely,
+	// ReqMultipart then has the parts before the error.
+	ReqMultipart      []MultipartPart
+	ReqMultipartError string
 
 	StatusCode  int
 	Status      string
@@ -31,7 +71,8 @@ type RequestResponse struct {
 	RespTrailer http.Header
 	// RespBody contains the body of the response, can be nil.
 	//
-	// This buffer is valid until the end of Serve.
+	// This buffer is valid until the end of Serve, also when the taps
+	// are called asynchronously.
 	//
 	// Do not read from this buffer, but create a reader from it.
 	// 	r := bytes.NewReader(RespBody.Bytes())
@@ -40,8 +81,48 @@ type RequestResponse struct {
 	// 	savedBody := make([]byte, RespBody.Len())
 	//  copy(savedBody, RespBody.Bytes())
 	//  r := bytes.NewReader(savedBody)
-	RespBody     *bytes.Buffer
-	RespBodyJSON any
+	RespBody            *bytes.Buffer
+	RespBodyJSON        any
+	RespBodyDecoded     any
+	RespBodySize        int64
+	RespBodyTruncated   bool
+	RespBodyFile        string
+	RespBodyEncoding    string
+	RespBodyDecodedSize int64
+	RespBodyDecodeError string
+
+	// Error is set when the exchange with the upstream failed,
+	// ErrorKind classifies the error.
+	Error     error
+	ErrorKind ErrorKind
+
+	// Sampled is false when the request was not sampled, the record is
+	// then kept because it failed or was slow and has no bodies.
+	Sampled bool
+
+	// Mocked is set when the response was returned by a mock
+	// instead of the upstream.
+	Mocked bool
+
+	// Faults are the faults that were injected, the response is synthetic
+	// or cut off when Faults contains an error, abort or truncate fault.
+	Faults []InjectedFault
+
+	// PIIFindings reports the sensitive values found by the PII detectors.
+	PIIFindings []pii.Finding
+
+	reqCapture  *bodyCapture
+	respCapture *bodyCapture
+	tracer      *tracer
+	// truncate is set when the response body must be truncated.
+	truncate bool
+
+	// The capture settings of the route, sampled is the decision per handler.
+	captureRequest  bool
+	captureResponse bool
+	reqLimits       captureLimits
+	respLimits      captureLimits
+	sampled         []bool
 }
 
 type Tap interface {
@@ -54,4 +135,3 @@ type TapFunc func(context.Context, *RequestResponse)
 func (t TapFunc) Serve(ctx context.Context, r *RequestResponse) {
 	t(ctx, r)
 }
-
@@ -4,10 +4,14 @@ import (
 	"context"
 	"log/slog"
 	"net/http"
+	"sort"
 
 	"github.com/myhops/httptap"
 )
 
+// LogTapMessage is the message of the records written by LogTap.
+const LogTapMessage = "upstream called"
+
 type LogTapConfig struct {
 	
 }
@@ -40,13 +44,90 @@ func (t *LogTap) Serve(ctx context.Context, rr *httptap.RequestResponse) {
 		slog.Any("response_header", slog.GroupValue(t.headerToAttrs(rr.RespHeader)...)),
 		slog.Any("response_trailer", slog.GroupValue(t.headerToAttrs(rr.RespTrailer)...)),
 	}
+	if rr.RequestID != "" {
+		attrs = append(attrs, slog.String("request_id", rr.RequestID))
+	}
+	if tc := rr.Trace; tc != nil {
+		attrs = append(attrs, slog.String("trace_id", tc.TraceID), slog.String("span_id", tc.SpanID))
+	}
+	if rr.TapName != "" {
+		attrs = append(attrs, slog.String("tap_name", rr.TapName))
+	}
+	if rr.Pattern != "" {
+		attrs = append(attrs, slog.String("pattern", rr.Pattern))
+	}
+	if len(rr.PathValues) > 0 {
+		names := make([]string, 0, len(rr.PathValues))
+		for k := range rr.PathValues {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		pv := make([]slog.Attr, 0, len(names))
+		for _, k := range names {
+			pv = append(pv, slog.String(k, rr.PathValues[k]))
+		}
+		attrs = append(attrs, slog.Any("path_values", slog.GroupValue(pv...)))
+	}
+	if tm := rr.Timing; tm != nil {
+		attrs = append(attrs, slog.Any("timing", slog.GroupValue(
+			slog.Duration("dns", tm.DNS),
+			slog.Duration("connect", tm.Connect),
+			slog.Duration("tls_handshake", tm.TLSHandshake),
+			slog.Duration("wait", tm.Wait),
+			slog.Duration("time_to_first_byte", tm.TimeToFirstByte),
+			slog.Duration("body_transfer", tm.BodyTransfer),
+			slog.Bool("conn_reused", tm.ConnReused),
+		)))
+	}
+	if rr.ReqBodySize > 0 {
+		attrs = append(attrs, slog.Int64("request_body_size", rr.ReqBodySize))
+	}
+	if rr.RespBodySize > 0 {
+		attrs = append(attrs, slog.Int64("response_body_size", rr.RespBodySize))
+	}
+	if rr.ReqBodyTruncated {
+		attrs = append(attrs, slog.Bool("request_body_truncated", true))
+	}
+	if rr.RespBodyTruncated {
+		attrs = append(attrs, slog.Bool("response_body_truncated", true))
+	}
+	if !rr.Sampled {
+		attrs = append(attrs, slog.Bool("sampled", false))
+	}
+	if rr.Mocked {
+		attrs = append(attrs, slog.Bool("mocked", true))
+	}
+	if len(rr.Faults) > 0 {
+		attrs = append(attrs, slog.Any("faults", rr.Faults))
+	}
+	if len(rr.PIIFindings) > 0 {
+		attrs = append(attrs, slog.Any("pii_findings", rr.PIIFindings))
+	}
+	if rr.Error != nil {
+		attrs = append(attrs,
+			slog.String("error", rr.Error.Error()),
+			slog.String("error_kind", string(rr.ErrorKind)),
+		)
+	}
 	if rr.ReqBodyJSON != nil {
 		attrs = append(attrs, slog.Any("request_body_json", rr.ReqBodyJSON))
 	}
 	if rr.RespBodyJSON != nil {
 		attrs = append(attrs, slog.Any("response_body_json", rr.RespBodyJSON))
 	}
-	t.logger.LogAttrs(ctx, t.level, "upstream called", attrs...)
+	if len(rr.ReqMultipart) > 0 {
+		attrs = append(attrs, slog.Any("request_multipart", rr.ReqMultipart))
+	}
+	if rr.ReqMultipartError != "" {
+		attrs = append(attrs, slog.String("request_multipart_error", rr.ReqMultipartError))
+	}
+	if rr.ReqBodyJSON == nil && rr.ReqBodyDecoded != nil {
+		attrs = append(attrs, slog.Any("request_body_decoded", rr.ReqBodyDecoded))
+	}
+	if rr.RespBodyJSON == nil && rr.RespBodyDecoded != nil {
+		attrs = append(attrs, slog.Any("response_body_decoded", rr.RespBodyDecoded))
+	}
+	t.logger.LogAttrs(ctx, t.level, LogTapMessage, attrs...)
 }
 
 func (t *LogTap) isBlocked(key string) bool {
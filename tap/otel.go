@@ -0,0 +1,438 @@
+package tap
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/myhops/httptap"
+)
+
+const (
+	DefaultOTelServiceName   = "httptap"
+	DefaultOTelBatchSize     = 512
+	DefaultOTelFlushInterval = 5 * time.Second
+	// DefaultOTelExportTimeout limits an export, a hanging collector does not
+	// block the exporter and the batches after it.
+	DefaultOTelExportTimeout = 10 * time.Second
+
+	otelScope = "github.com/myhops/httptap/tap"
+)
+
+// OTelTapConfig configures the export of the spans.
+type OTelTapConfig struct {
+	// Endpoint is the base URL of the OTLP/HTTP receiver, like http://localhost:4318.
+	// The spans are posted to Endpoint/v1/traces.
+	Endpoint    string
+	ServiceName string
+	// Headers are added to the export requests, e.g. for authentication.
+	Headers map[string]string
+	// BatchSize is the number of spans per export.
+	BatchSize int
+	// FlushInterval is the longest time a span waits for export.
+	FlushInterval time.Duration
+	// Client sends the export requests, a client with DefaultOTelExportTimeout when nil.
+	Client *http.Client
+}
+
+// OTelTap exports a server span and a client span per exchange as OTLP/HTTP JSON.
+// The spans use the trace context of the proxy, see httptap.WithTraceContext.
+// Without it, every exchange starts its own trace. The exchanges of traces the
+// caller did not sample, traceparent flags 00, are not exported.
+type OTelTap struct {
+	logger   *slog.Logger
+	url      string
+	headers  map[string]string
+	client   *http.Client
+	resource otelResource
+	size     int
+	interval time.Duration
+
+	// mu guards closed, Serve does not send after the queue is closed.
+	mu     sync.RWMutex
+	closed bool
+	spans  chan otelSpan
+	done   chan struct{}
+}
+
+func NewOTelTap(logger *slog.Logger, cfg OTelTapConfig) (*OTelTap, error) {
+	if cfg.Endpoint == "" {
+		return nil, fmt.Errorf("otel tap: no endpoint")
+	}
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = DefaultOTelServiceName
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultOTelBatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = DefaultOTelFlushInterval
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: DefaultOTelExportTimeout}
+	}
+	t := &OTelTap{
+		logger:  logger.With(slog.String("tap", "otel")),
+		url:     strings.TrimSuffix(cfg.Endpoint, "/") + "/v1/traces",
+		headers: cfg.Headers,
+		client:  cfg.Client,
+		resource: otelResource{Attributes: []otelAttr{
+			stringAttr("service.name", cfg.ServiceName),
+		}},
+		size:     cfg.BatchSize,
+		interval: cfg.FlushInterval,
+		spans:    make(chan otelSpan, 4*cfg.BatchSize),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t, nil
+}
+
+// Serve queues the spans of the exchange, the spans are dropped when the queue is full.
+func (t *OTelTap) Serve(ctx context.Context, rr *httptap.RequestResponse) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.closed {
+		return
+	}
+	// Honor the sampling decision of the caller.
+	if rr.Trace != nil && !rr.Trace.Sampled() {
+		return
+	}
+	for _, s := range recordSpans(rr) {
+		select {
+		case t.spans <- s:
+		default:
+			t.logger.Warn("span dropped, queue full", slog.String("span", s.Name))
+		}
+	}
+}
+
+// Shutdown exports the queued spans and stops the exporter.
+// The records served after Shutdown are not exported.
+func (t *OTelTap) Shutdown(ctx context.Context) error {
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.spans)
+	}
+	t.mu.Unlock()
+	select {
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+// run exports the spans when the batch is full or the interval passed.
+func (t *OTelTap) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	batch := make([]otelSpan, 0, t.size)
+	for {
+		select {
+		case s, ok := <-t.spans:
+			if !ok {
+				t.export(batch)
+				return
+			}
+			batch = append(batch, s)
+			if len(batch) < t.size {
+				continue
+			}
+		case <-ticker.C:
+		}
+		t.export(batch)
+		batch = batch[:0]
+	}
+}
+
+func (t *OTelTap) export(spans []otelSpan) {
+	if len(spans) == 0 {
+		return
+	}
+	body, err := json.Marshal(otelTraces{ResourceSpans: []otelResourceSpans{{
+		Resource:   t.resource,
+		ScopeSpans: []otelScopeSpans{{Scope: otelScopeInfo{Name: otelScope}, Spans: spans}},
+	}}})
+	if err != nil {
+		t.logger.Error("cannot marshal spans", slog.String("err", err.Error()))
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		t.logger.Error("cannot create export request", slog.String("err", err.Error()))
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		t.logger.Error("export failed", slog.String("err", err.Error()), slog.Int("spans", len(spans)))
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		t.logger.Error("export rejected", slog.String("status", resp.Status), slog.Int("spans", len(spans)))
+		return
+	}
+	t.logger.Debug("spans exported", slog.Int("spans", len(spans)))
+}
+
+// recordSpans returns the server span of the proxy and the client span of the
+// upstream call, with the attributes of the HTTP semantic conventions.
+func recordSpans(rr *httptap.RequestResponse) []otelSpan {
+	tc := rr.Trace
+	if tc == nil {
+		tc = &httptap.TraceContext{TraceID: randomHex(16), SpanID: randomHex(8), Flags: 0x01}
+	}
+	end := rr.End
+	if end.IsZero() {
+		end = time.Now()
+	}
+	if rr.Timing != nil {
+		end = end.Add(rr.Timing.BodyTransfer)
+	}
+
+	server := otelSpan{
+		TraceID:      tc.TraceID,
+		SpanID:       tc.SpanID,
+		ParentSpanID: tc.ParentSpanID,
+		TraceState:   tc.State,
+		Name:         rr.Method,
+		Kind:         otelKindServer,
+		Start:        nanos(rr.Start),
+		End:          nanos(end),
+	}
+	route := patternRoute(rr.Pattern)
+	if route != "" {
+		server.Name = rr.Method + " " + route
+		server.Attributes = append(server.Attributes, stringAttr("http.route", route))
+	}
+	server.Attributes = append(server.Attributes,
+		stringAttr("http.request.method", rr.Method),
+		stringAttr("server.address", rr.Host),
+	)
+	if u := rr.URL; u != nil {
+		server.Attributes = append(server.Attributes, stringAttr("url.path", u.Path))
+		if u.RawQuery != "" {
+			server.Attributes = append(server.Attributes, stringAttr("url.query", u.RawQuery))
+		}
+	}
+	if v := protoVersion(rr.ReqProto); v != "" {
+		server.Attributes = append(server.Attributes, stringAttr("network.protocol.version", v))
+	}
+	if ua := rr.ReqHeader.Get("User-Agent"); ua != "" {
+		server.Attributes = append(server.Attributes, stringAttr("user_agent.original", ua))
+	}
+	if rr.ReqBodySize > 0 {
+		server.Attributes = append(server.Attributes, intAttr("http.request.body.size", rr.ReqBodySize))
+	}
+	if rr.RespBodySize > 0 {
+		server.Attributes = append(server.Attributes, intAttr("http.response.body.size", rr.RespBodySize))
+	}
+	if rr.RequestID != "" {
+		server.Attributes = append(server.Attributes, stringAttr("httptap.request_id", rr.RequestID))
+	}
+	if rr.TapName != "" {
+		server.Attributes = append(server.Attributes, stringAttr("httptap.tap_name", rr.TapName))
+	}
+	if rr.Mocked {
+		server.Attributes = append(server.Attributes, boolAttr("httptap.mocked", true))
+	}
+	for _, f := range rr.Faults {
+		server.Events = append(server.Events, otelEvent{
+			Time:       nanos(rr.Start),
+			Name:       "fault",
+			Attributes: []otelAttr{stringAttr("httptap.fault", f.String())},
+		})
+	}
+	setStatus(&server, rr, http.StatusInternalServerError)
+
+	if tc.ClientSpanID == "" {
+		return []otelSpan{server}
+	}
+	client := otelSpan{
+		TraceID:      tc.TraceID,
+		SpanID:       tc.ClientSpanID,
+		ParentSpanID: tc.SpanID,
+		TraceState:   tc.State,
+		Name:         rr.Method,
+		Kind:         otelKindClient,
+		Start:        nanos(tc.ClientStart),
+		End:          nanos(end),
+		Attributes:   []otelAttr{stringAttr("http.request.method", rr.Method)},
+	}
+	if u := rr.URL; u != nil {
+		client.Attributes = append(client.Attributes,
+			stringAttr("url.full", u.String()),
+			stringAttr("server.address", u.Hostname()),
+		)
+		if port := urlPort(u.Scheme, u.Port()); port > 0 {
+			client.Attributes = append(client.Attributes, intAttr("server.port", port))
+		}
+	}
+	if v := protoVersion(rr.RespProto); v != "" {
+		client.Attributes = append(client.Attributes, stringAttr("network.protocol.version", v))
+	}
+	if tm := rr.Timing; tm != nil && tm.TimeToFirstByte > 0 {
+		firstByte := tc.ClientStart.Add(tm.TimeToFirstByte)
+		client.Events = append(client.Events,
+			otelEvent{Time: nanos(firstByte.Add(-tm.Wait)), Name: "request sent"},
+			otelEvent{Time: nanos(firstByte), Name: "first response byte"},
+		)
+	}
+	setStatus(&client, rr, http.StatusBadRequest)
+	return []otelSpan{server, client}
+}
+
+// setStatus sets the status code and the error type of the span, status codes
+// from errorStatus up are errors.
+func setStatus(s *otelSpan, rr *httptap.RequestResponse, errorStatus int) {
+	if rr.StatusCode > 0 {
+		s.Attributes = append(s.Attributes, intAttr("http.response.status_code", int64(rr.StatusCode)))
+	}
+	switch {
+	case rr.Error != nil:
+		s.Attributes = append(s.Attributes, stringAttr("error.type", string(rr.ErrorKind)))
+		s.Status = otelStatus{Code: otelStatusError, Message: rr.Error.Error()}
+	case rr.StatusCode >= errorStatus:
+		s.Attributes = append(s.Attributes, stringAttr("error.type", strconv.Itoa(rr.StatusCode)))
+		s.Status = otelStatus{Code: otelStatusError}
+	}
+}
+
+// patternRoute returns the path of a ServeMux pattern, without method and host.
+func patternRoute(pattern string) string {
+	if i := strings.IndexByte(pattern, '/'); i >= 0 {
+		return pattern[i:]
+	}
+	return ""
+}
+
+// protoVersion returns 1.1 for HTTP/1.1.
+func protoVersion(proto string) string {
+	_, v, _ := strings.Cut(proto, "/")
+	return v
+}
+
+func urlPort(scheme, port string) int64 {
+	if port != "" {
+		p, _ := strconv.ParseInt(port, 10, 64)
+		return p
+	}
+	switch scheme {
+	case "http":
+		return 80
+	case "https":
+		return 443
+	}
+	return 0
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func nanos(t time.Time) string {
+	if t.IsZero() {
+		return "0"
+	}
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// The OTLP/JSON encoding of the trace export request. The ids are hex,
+// the 64 bit integers are strings.
+type otelTraces struct {
+	ResourceSpans []otelResourceSpans `json:"resourceSpans"`
+}
+
+type otelResourceSpans struct {
+	Resource   otelResource     `json:"resource"`
+	ScopeSpans []otelScopeSpans `json:"scopeSpans"`
+}
+
+type otelResource struct {
+	Attributes []otelAttr `json:"attributes"`
+}
+
+type otelScopeSpans struct {
+	Scope otelScopeInfo `json:"scope"`
+	Spans []otelSpan    `json:"spans"`
+}
+
+type otelScopeInfo struct {
+	Name string `json:"name"`
+}
+
+const (
+	otelKindServer = 2
+	otelKindClient = 3
+
+	otelStatusError = 2
+)
+
+type otelSpan struct {
+	TraceID      string      `json:"traceId"`
+	SpanID       string      `json:"spanId"`
+	ParentSpanID string      `json:"parentSpanId,omitempty"`
+	TraceState   string      `json:"traceState,omitempty"`
+	Name         string      `json:"name"`
+	Kind         int         `json:"kind"`
+	Start        string      `json:"startTimeUnixNano"`
+	End          string      `json:"endTimeUnixNano"`
+	Attributes   []otelAttr  `json:"attributes,omitempty"`
+	Events       []otelEvent `json:"events,omitempty"`
+	Status       otelStatus  `json:"status"`
+}
+
+type otelEvent struct {
+	Time       string     `json:"timeUnixNano"`
+	Name       string     `json:"name"`
+	Attributes []otelAttr `json:"attributes,omitempty"`
+}
+
+type otelStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otelAttr struct {
+	Key   string    `json:"key"`
+	Value otelValue `json:"value"`
+}
+
+type otelValue struct {
+	String *string `json:"stringValue,omitempty"`
+	Int    *string `json:"intValue,omitempty"`
+	Bool   *bool   `json:"boolValue,omitempty"`
+}
+
+func stringAttr(key, v string) otelAttr {
+	return otelAttr{Key: key, Value: otelValue{String: &v}}
+}
+
+func intAttr(key string, v int64) otelAttr {
+	s := strconv.FormatInt(v, 10)
+	return otelAttr{Key: key, Value: otelValue{Int: &s}}
+}
+
+func boolAttr(key string, v bool) otelAttr {
+	return otelAttr{Key: key, Value: otelValue{Bool: &v}}
+}
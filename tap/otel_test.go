@@ -0,0 +1,217 @@
+package tap
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/myhops/httptap"
+)
+
+func TestOTelTap(t *testing.T) {
+	// The collector stand-in.
+	var (
+		mu      sync.Mutex
+		exports []otelTraces
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" ||
+			r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("unexpected export request %s %v", r.URL.Path, r.Header)
+		}
+		var req otelTraces
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("cannot decode export: %s", err)
+		}
+		mu.Lock()
+		exports = append(exports, req)
+		mu.Unlock()
+	}))
+	defer collector.Close()
+
+	traceparents := make(chan string, 2)
+	us := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents <- r.Header.Get("Traceparent")
+		if r.URL.Path == "/orders/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer us.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ot, err := NewOTelTap(logger, OTelTapConfig{
+		Endpoint:      collector.URL,
+		ServiceName:   "orders-proxy",
+		Headers:       map[string]string{"Authorization": "Bearer token"},
+		BatchSize:     3,
+		FlushInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	pr, err := httptap.New(us.URL, httptap.WithLogger(logger), httptap.WithTraceContext())
+	if err != nil {
+		t.Fatalf("error creating proxy: %s", err)
+	}
+	pr.Tap([]string{"GET /orders/{id}"}, ot)
+	ps := httptest.NewServer(pr)
+
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		caller  = "00f067aa0ba902b7"
+	)
+	req, _ := http.NewRequest(http.MethodGet, ps.URL+"/orders/42?x=1", nil)
+	req.Header.Set("Traceparent", "00-"+traceID+"-"+caller+"-01")
+	req.Header.Set("Tracestate", "vendor=abc")
+	for _, r := range []*http.Request{req, mustRequest(t, ps.URL+"/orders/broken")} {
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("get error: %s", err)
+		}
+		resp.Body.Close()
+	}
+	ps.Close()
+	if err := ot.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown error: %s", err)
+	}
+
+	// Three spans in the first batch, the last one on shutdown.
+	var spans []otelSpan
+	for _, e := range exports {
+		rs := e.ResourceSpans[0]
+		if v := rs.Resource.Attributes[0].Value.String; v == nil || *v != "orders-proxy" {
+			t.Errorf("service name not set")
+		}
+		spans = append(spans, rs.ScopeSpans[0].Spans...)
+	}
+	if len(exports) != 2 || len(spans) != 4 {
+		t.Fatalf("got %d exports with %d spans", len(exports), len(spans))
+	}
+
+	server, client := spans[0], spans[1]
+	if server.TraceID != traceID || server.ParentSpanID != caller || server.Kind != otelKindServer ||
+		server.TraceState != "vendor=abc" || server.Name != "GET /orders/{id}" {
+		t.Errorf("server span: %+v", server)
+	}
+	if client.TraceID != traceID || client.ParentSpanID != server.SpanID || client.Kind != otelKindClient {
+		t.Errorf("client span: %+v", client)
+	}
+	// The upstream sees the client span as its parent.
+	if tp := <-traceparents; tp != "00-"+traceID+"-"+client.SpanID+"-01" {
+		t.Errorf("upstream got traceparent %q", tp)
+	}
+	if got := attr(server, "http.route"); got != "/orders/{id}" {
+		t.Errorf("http.route %q", got)
+	}
+	if got := attr(server, "url.query"); got != "x=1" {
+		t.Errorf("url.query %q", got)
+	}
+	if got := attr(client, "http.response.status_code"); got != "200" {
+		t.Errorf("status code %q", got)
+	}
+	if got := attr(client, "url.full"); !strings.HasPrefix(got, us.URL+"/orders/42") {
+		t.Errorf("url.full %q", got)
+	}
+
+	// The second request starts a new trace and fails.
+	broken := spans[2]
+	if broken.TraceID == traceID || broken.ParentSpanID != "" || broken.Status.Code != otelStatusError {
+		t.Errorf("broken server span: %+v", broken)
+	}
+	if got := attr(broken, "error.type"); got != "500" {
+		t.Errorf("error.type %q", got)
+	}
+}
+
+func mustRequest(t *testing.T, url string) *http.Request {
+	r, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("request error: %s", err)
+	}
+	return r
+}
+
+func attr(s otelSpan, key string) string {
+	for _, a := range s.Attributes {
+		if a.Key != key {
+			continue
+		}
+		switch {
+		case a.Value.String != nil:
+			return *a.Value.String
+		case a.Value.Int != nil:
+			return *a.Value.Int
+		}
+	}
+	return ""
+}
+
+func TestOTelTapNotSampled(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		spans []otelSpan
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req otelTraces
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("cannot decode export: %s", err)
+		}
+		mu.Lock()
+		for _, rs := range req.ResourceSpans {
+			spans = append(spans, rs.ScopeSpans[0].Spans...)
+		}
+		mu.Unlock()
+	}))
+	defer collector.Close()
+	us := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer us.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ot, err := NewOTelTap(logger, OTelTapConfig{Endpoint: collector.URL, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if ot.client.Timeout <= 0 {
+		t.Errorf("default client has no timeout")
+	}
+	pr, err := httptap.New(us.URL, httptap.WithLogger(logger), httptap.WithTraceContext())
+	if err != nil {
+		t.Fatalf("error creating proxy: %s", err)
+	}
+	pr.Tap([]string{"/"}, ot)
+	ps := httptest.NewServer(pr)
+
+	const sampled = "4bf92f3577b34da6a3ce929d0e0e4736"
+	for _, tp := range []string{
+		"00-0af7651916cd43dd8448eb211c80319c-00f067aa0ba902b7-00",
+		"00-" + sampled + "-00f067aa0ba902b7-01",
+	} {
+		req := mustRequest(t, ps.URL)
+		req.Header.Set("Traceparent", tp)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("get error: %s", err)
+		}
+		resp.Body.Close()
+	}
+	ps.Close()
+	if err := ot.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown error: %s", err)
+	}
+
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want the 2 of the sampled trace", len(spans))
+	}
+	for _, s := range spans {
+		if s.TraceID != sampled {
+			t.Errorf("exported span of trace %s", s.TraceID)
+		}
+	}
+}
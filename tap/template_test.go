@@ -243,3 +243,34 @@ func TestTemplateTapBody(t *testing.T) {
 
 	// t.Error()
 }
+
+func TestTemplateTapRoute(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&out, nil))
+	tpl, err := NewTemplateTap(logger,
+		`{{.Data.TapName}} {{.Data.Pattern}} id={{.Data.PathValues.id}}`, "", "")
+	if err != nil {
+		t.Fatalf("error: %s", err.Error())
+	}
+
+	us := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer us.Close()
+
+	pr, err := httptap.New(us.URL, httptap.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	if err != nil {
+		t.Fatalf("error creating proxy: %s", err)
+	}
+	pr.Tap([]string{"GET /orders/{id}"}, tpl, httptap.WithTapName("orders"))
+
+	ps := httptest.NewServer(pr)
+	resp, err := http.Get(ps.URL + "/orders/42")
+	if err != nil {
+		t.Fatalf("get error: %s", err)
+	}
+	resp.Body.Close()
+	ps.Close()
+
+	if want := `data="orders GET /orders/{id} id=42"`; !bytes.Contains(out.Bytes(), []byte(want)) {
+		t.Errorf("got %s, want %s", out.String(), want)
+	}
+}
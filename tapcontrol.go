@@ -0,0 +1,121 @@
+package httptap
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// ErrTapNotFound is returned when no tap has the name.
+var ErrTapNotFound = errors.New("tap not found")
+
+// TapInfo describes a tap and its options.
+type TapInfo struct {
+	Name     string   `json:"name"`
+	Patterns []string `json:"patterns"`
+	Enabled  bool     `json:"enabled"`
+
+	RequestBody          bool    `json:"requestBody"`
+	ResponseBody         bool    `json:"responseBody"`
+	RequestJSON          bool    `json:"requestJSON,omitempty"`
+	ResponseJSON         bool    `json:"responseJSON,omitempty"`
+	MaxRequestBodyBytes  int64   `json:"maxRequestBodyBytes,omitempty"`
+	MaxResponseBodyBytes int64   `json:"maxResponseBodyBytes,omitempty"`
+	Filter               string  `json:"filter,omitempty"`
+	SampleRate           float64 `json:"sampleRate"`
+	Redact               bool    `json:"redact,omitempty"`
+	PII                  bool    `json:"pii,omitempty"`
+	Mock                 bool    `json:"mock,omitempty"`
+	Faults               bool    `json:"faults,omitempty"`
+	Network              bool    `json:"network,omitempty"`
+}
+
+func (h *Handler) info() TapInfo {
+	ti := TapInfo{
+		Name:                 h.name,
+		Patterns:             append([]string(nil), h.patterns...),
+		Enabled:              !h.disabled.Load(),
+		RequestBody:          h.withRequestBody.Load(),
+		ResponseBody:         h.withResponseBody.Load(),
+		RequestJSON:          h.withRequestJSON,
+		ResponseJSON:         h.withResponseJSON,
+		MaxRequestBodyBytes:  h.reqLimits.maxBytes,
+		MaxResponseBodyBytes: h.respLimits.maxBytes,
+		SampleRate:           1,
+		Redact:               h.redactor != nil,
+		PII:                  h.pii != nil,
+		Mock:                 h.mock != nil,
+		Faults:               h.faults != nil,
+		Network:              h.network != nil,
+	}
+	if h.filter != nil {
+		ti.Filter = h.filter.String()
+	}
+	if h.sampling != nil {
+		ti.SampleRate = h.sampling.rate
+	}
+	return ti
+}
+
+// Taps returns the taps in the order they were added.
+func (p *Proxy) Taps() []TapInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	res := make([]TapInfo, 0, len(p.handlers))
+	for _, h := range p.handlers {
+		res = append(res, h.info())
+	}
+	return res
+}
+
+// tapHandlers returns the handlers of the taps with the name.
+func (p *Proxy) tapHandlers(name string) ([]*Handler, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var res []*Handler
+	for _, h := range p.handlers {
+		if h.name == name {
+			res = append(res, h)
+		}
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("%w: %q", ErrTapNotFound, name)
+	}
+	return res, nil
+}
+
+// SetTapEnabled enables or disables the taps with the name. A disabled tap
+// gets no records, the requests on its patterns are still proxied.
+func (p *Proxy) SetTapEnabled(name string, enabled bool) error {
+	hs, err := p.tapHandlers(name)
+	if err != nil {
+		return err
+	}
+	for _, h := range hs {
+		h.disabled.Store(!enabled)
+	}
+	p.logger.Info("tap changed", slog.String("tap", name), slog.Bool("enabled", enabled))
+	return nil
+}
+
+// SetTapBodyCapture switches the capture of the request and response bodies
+// of the taps with the name, nil leaves the setting as is.
+// The change applies to the requests that start after it.
+func (p *Proxy) SetTapBodyCapture(name string, request, response *bool) error {
+	hs, err := p.tapHandlers(name)
+	if err != nil {
+		return err
+	}
+	for _, h := range hs {
+		if request != nil {
+			h.withRequestBody.Store(*request)
+		}
+		if response != nil {
+			h.withResponseBody.Store(*response)
+		}
+	}
+	ti := hs[0].info()
+	p.logger.Info("tap changed", slog.String("tap", name),
+		slog.Bool("request_body", ti.RequestBody), slog.Bool("response_body", ti.ResponseBody))
+	return nil
+}
@@ -1,9 +1,13 @@
 package httptap
 
 import (
+	"errors"
+	"fmt"
 	"log/slog"
+	"time"
 
 	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/myhops/httptap/pii"
 )
 
 func WithLogAttrs(attrs ...slog.Attr) tapOption {
@@ -12,15 +16,22 @@ func WithLogAttrs(attrs ...slog.Attr) tapOption {
 	})
 }
 
+// WithTapName sets the name of the tap, it is passed in TapName.
+func WithTapName(name string) tapOption {
+	return tapOption(func(h *Handler) {
+		h.name = name
+	})
+}
+
 func WithRequestBody(yes ...bool) tapOption {
 	return tapOption(func(h *Handler) {
-		h.withRequestBody = len(yes) != 1 || yes[0]
+		h.withRequestBody.Store(len(yes) != 1 || yes[0])
 	})
 }
 
 func WithResponseBody(yes ...bool) tapOption {
 	return tapOption(func(h *Handler) {
-		h.withResponseBody = len(yes) != 1 || yes[0]
+		h.withResponseBody.Store(len(yes) != 1 || yes[0])
 	})
 }
 
@@ -36,11 +47,43 @@ func WithResponseJSON(yes ...bool) tapOption {
 	})
 }
 
+// WithRequestDecoded decodes the request body into ReqBodyDecoded
+// with the BodyDecoder registered for its Content-Type.
+func WithRequestDecoded(yes ...bool) tapOption {
+	return tapOption(func(h *Handler) {
+		h.withRequestDecoded = len(yes) != 1 || yes[0]
+	})
+}
+
+// WithResponseDecoded decodes the response body into RespBodyDecoded
+// with the BodyDecoder registered for its Content-Type.
+func WithResponseDecoded(yes ...bool) tapOption {
+	return tapOption(func(h *Handler) {
+		h.withResponseDecoded = len(yes) != 1 || yes[0]
+	})
+}
+
+// WithRequestMultipart parses multipart/form-data request bodies into ReqMultipart.
+// It needs the request body.
+func WithRequestMultipart(yes ...bool) tapOption {
+	return tapOption(func(h *Handler) {
+		h.withMultipart = len(yes) != 1 || yes[0]
+	})
+}
+
+// WithEvidenceDir saves the files uploaded in multipart requests to dir,
+// named by their SHA-256 digest.
+func WithEvidenceDir(dir string) tapOption {
+	return tapOption(func(h *Handler) {
+		h.evidenceDir = dir
+	})
+}
+
 func WithRequestBodyPatch(patch []byte) tapOption {
 	return tapOption(func(h *Handler) {
 		p, err := jsonpatch.DecodePatch(patch)
 		if err != nil {
-			h.logger.Error("ReqBody DecodePatch error", slog.String("err", err.Error()))
+			h.optionError("request body patch", err)
 			return
 		}
 		h.reqBodyPatch = p
@@ -52,10 +95,7 @@ func WithResponseBodyPatch(patch []byte) tapOption {
 		logger := h.logger.With(slog.String("step", "WithResponseBodyPatch"))
 		p, err := jsonpatch.DecodePatch(patch)
 		if err != nil {
-			logger.Error("RespBody DecodePatch error",
-				slog.String("err", err.Error()),
-				slog.String("patch", string(patch)),
-			)
+			h.optionError("response body patch", err)
 			return
 		}
 		logger.Info("adding patch")
@@ -74,3 +114,199 @@ func WithExcludeHeaders(header []string) tapOption {
 		h.excludeHeaders = canonicalHeaders(header)
 	})
 }
+
+// WithHeaderMasks masks the values of the matching headers.
+func WithHeaderMasks(masks ...HeaderMask) tapOption {
+	return tapOption(func(h *Handler) {
+		h.headerMasks = masks
+	})
+}
+
+// WithMaxRequestBodyBytes limits the captured part of the request body.
+// The request is always forwarded completely.
+func WithMaxRequestBodyBytes(n int64) tapOption {
+	return tapOption(func(h *Handler) {
+		h.reqLimits.maxBytes = n
+	})
+}
+
+// WithMaxResponseBodyBytes limits the captured part of the response body.
+// The response is always forwarded completely.
+func WithMaxResponseBodyBytes(n int64) tapOption {
+	return tapOption(func(h *Handler) {
+		h.respLimits.maxBytes = n
+	})
+}
+
+// WithRequestBodySpill writes captured request bodies larger than threshold
+// to a temp file in dir instead of keeping them in memory.
+func WithRequestBodySpill(threshold int64, dir string) tapOption {
+	return tapOption(func(h *Handler) {
+		h.reqLimits.spillThreshold = threshold
+		h.reqLimits.spillDir = dir
+	})
+}
+
+// WithResponseBodySpill writes captured response bodies larger than threshold
+// to a temp file in dir instead of keeping them in memory.
+func WithResponseBodySpill(threshold int64, dir string) tapOption {
+	return tapOption(func(h *Handler) {
+		h.respLimits.spillThreshold = threshold
+		h.respLimits.spillDir = dir
+	})
+}
+
+// WithRedaction redacts the values selected by the rules in the JSON, NDJSON,
+// YAML and form bodies and in the multipart values before the taps see them.
+// Other bodies are withheld from the taps.
+// Rules with action hmac use the key set with WithRedactionKey, or a random key.
+func WithRedaction(rules ...RedactRule) tapOption {
+	return tapOption(func(h *Handler) {
+		logger := h.logger.With(slog.String("step", "WithRedaction"))
+		r, err := newRedactor(rules)
+		if err != nil {
+			h.optionError("redaction", err)
+			return
+		}
+		if h.redactor != nil {
+			r.key = h.redactor.key
+		}
+		if r.needsKey() && r.key == nil {
+			logger.Warn("no redaction key set, using a random key")
+			r.key = randomKey()
+		}
+		h.redactor = r
+	})
+}
+
+// WithRedactionKey sets the key for HMAC pseudonymization.
+// Use the same key across proxies to get the same tokens.
+// An empty key is an error, the tokens could be reversed by hashing guesses.
+func WithRedactionKey(key []byte) tapOption {
+	return tapOption(func(h *Handler) {
+		if len(key) == 0 {
+			h.optionError("redaction key", errors.New("empty key"))
+			return
+		}
+		if h.redactor == nil {
+			h.redactor = &redactor{}
+		}
+		h.redactor.key = key
+	})
+}
+
+// WithPIIDetectors scans the captured bodies, headers and query for sensitive
+// values with the named detectors, all detectors when no names are passed.
+// The findings are reported in PIIFindings and masked when action is PIIMask.
+func WithPIIDetectors(action PIIAction, names ...string) tapOption {
+	return tapOption(func(h *Handler) {
+		s, err := pii.NewScanner(names...)
+		if err != nil {
+			h.optionError("pii detectors", err)
+			return
+		}
+		h.pii = s
+		h.piiAction = action
+	})
+}
+
+// WithRequestMutation changes the requests that are sent to the upstream.
+func WithRequestMutation(m Mutation) tapOption {
+	return tapOption(func(h *Handler) {
+		mm, err := newMutation(m)
+		if err != nil {
+			h.optionError("request mutation", err)
+			return
+		}
+		h.reqMutation = mm
+	})
+}
+
+// WithResponseMutation changes the responses that are returned to the client.
+func WithResponseMutation(m Mutation) tapOption {
+	return tapOption(func(h *Handler) {
+		mm, err := newMutation(m)
+		if err != nil {
+			h.optionError("response mutation", err)
+			return
+		}
+		h.respMutation = mm
+	})
+}
+
+// WithMock returns the mock response instead of proxying the request.
+// The exchange is recorded and served to the tap like a proxied one.
+func WithMock(m Mock) tapOption {
+	return tapOption(func(h *Handler) {
+		mm, err := newMock(m)
+		if err != nil {
+			h.optionError("mock", err)
+			return
+		}
+		h.mock = mm
+	})
+}
+
+// WithFaults injects faults in a percentage of the requests.
+// The injected faults are recorded in Faults.
+func WithFaults(f Faults) tapOption {
+	return tapOption(func(h *Handler) {
+		if err := validateFaults(f); err != nil {
+			h.optionError("faults", err)
+			return
+		}
+		h.faults = &f
+	})
+}
+
+// WithNetworkConditions limits the bandwidth, adds jitter and forces
+// small chunks to emulate a slow link.
+func WithNetworkConditions(n NetworkConditions) tapOption {
+	return tapOption(func(h *Handler) {
+		h.network = &n
+	})
+}
+
+// WithSampleRate serves a fraction rate, 0 to 1, of the requests to the tap.
+// The decision is keyed on the trace id in traceparent or on the request id,
+// the header of WithRequestID or X-Request-Id, so all hops of a trace get
+// the same decision.
+// The bodies of unsampled requests are not captured.
+func WithSampleRate(rate float64) tapOption {
+	return tapOption(func(h *Handler) {
+		if rate < 0 || rate > 1 {
+			h.optionError("sample rate", fmt.Errorf("%v is not between 0 and 1", rate))
+			return
+		}
+		if h.sampling == nil {
+			h.sampling = &sampling{}
+		}
+		h.sampling.rate = rate
+	})
+}
+
+// WithSampleKeep keeps the records of unsampled requests that failed or
+// that took at least slowerThan, zero disables the latter.
+// Use it together with WithSampleRate.
+func WithSampleKeep(errors bool, slowerThan time.Duration) tapOption {
+	return tapOption(func(h *Handler) {
+		if h.sampling == nil {
+			h.sampling = &sampling{rate: 1}
+		}
+		h.sampling.keepErrors = errors
+		h.sampling.keepSlowerThan = slowerThan
+	})
+}
+
+// WithFilter serves only the records that match the filter expression to the tap.
+// Use CompileFilter to check the expression.
+func WithFilter(expr string) tapOption {
+	return tapOption(func(h *Handler) {
+		f, err := CompileFilter(expr)
+		if err != nil {
+			h.optionError("filter", err)
+			return
+		}
+		h.filter = f
+	})
+}
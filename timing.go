@@ -0,0 +1,153 @@
+package httptap
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Timing is the breakdown of the exchange with the upstream.
+// The phases that did not happen, like DNS for a reused connection, are zero.
+type Timing struct {
+	// DNS is the time of the DNS lookup.
+	DNS time.Duration `json:"dns,omitempty"`
+	// Connect is the time to set up the TCP connection.
+	Connect time.Duration `json:"connect,omitempty"`
+	// TLSHandshake is the time of the TLS handshake.
+	TLSHandshake time.Duration `json:"tls_handshake,omitempty"`
+	// Wait is the time between writing the request and the first byte
+	// of the response, the processing time of the upstream.
+	Wait time.Duration `json:"wait,omitempty"`
+	// TimeToFirstByte is the time from getting a connection to the first
+	// byte of the response.
+	TimeToFirstByte time.Duration `json:"time_to_first_byte,omitempty"`
+	// BodyTransfer is the time from the first byte until the response body
+	// is read completely.
+	BodyTransfer time.Duration `json:"body_transfer,omitempty"`
+	// ConnReused is set when the connection was reused.
+	ConnReused bool `json:"conn_reused"`
+}
+
+// tracer collects the timing, the callbacks run on the transport goroutines.
+type tracer struct {
+	mu     sync.Mutex
+	timing Timing
+
+	getConn, dnsStart, connectStart, tlsStart time.Time
+	wroteRequest, firstByte, bodyDone         time.Time
+}
+
+func (t *tracer) clientTrace() *httptrace.ClientTrace {
+	now := func(f func(now time.Time)) {
+		n := time.Now()
+		t.mu.Lock()
+		f(n)
+		t.mu.Unlock()
+	}
+	return &httptrace.ClientTrace{
+		GetConn: func(string) {
+			now(func(n time.Time) { t.getConn = n })
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			now(func(time.Time) { t.timing.ConnReused = info.Reused })
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			now(func(n time.Time) { t.dnsStart = n })
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			now(func(n time.Time) { t.timing.DNS = n.Sub(t.dnsStart) })
+		},
+		ConnectStart: func(string, string) {
+			now(func(n time.Time) {
+				if t.connectStart.IsZero() {
+					t.connectStart = n
+				}
+			})
+		},
+		ConnectDone: func(_, _ string, err error) {
+			// With more addresses, the first successful dial wins.
+			now(func(n time.Time) {
+				if err == nil && t.timing.Connect == 0 {
+					t.timing.Connect = n.Sub(t.connectStart)
+				}
+			})
+		},
+		TLSHandshakeStart: func() {
+			now(func(n time.Time) { t.tlsStart = n })
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, _ error) {
+			now(func(n time.Time) { t.timing.TLSHandshake = n.Sub(t.tlsStart) })
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			now(func(n time.Time) { t.wroteRequest = n })
+		},
+		GotFirstResponseByte: func() {
+			now(func(n time.Time) { t.firstByte = n })
+		},
+	}
+}
+
+// done records the end of the response body.
+func (t *tracer) done() {
+	n := time.Now()
+	t.mu.Lock()
+	if t.bodyDone.IsZero() {
+		t.bodyDone = n
+	}
+	t.mu.Unlock()
+}
+
+// result returns the timing of the exchange.
+func (t *tracer) result() *Timing {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	res := t.timing
+	if !t.firstByte.IsZero() {
+		if !t.getConn.IsZero() {
+			res.TimeToFirstByte = t.firstByte.Sub(t.getConn)
+		}
+		if !t.wroteRequest.IsZero() {
+			res.Wait = t.firstByte.Sub(t.wroteRequest)
+		}
+		if !t.bodyDone.IsZero() {
+			res.BodyTransfer = t.bodyDone.Sub(t.firstByte)
+		}
+	}
+	return &res
+}
+
+// timedBody records when the body is read completely or closed.
+type timedBody struct {
+	io.ReadCloser
+	t *tracer
+}
+
+func (b *timedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.t.done()
+	}
+	return n, err
+}
+
+func (b *timedBody) Close() error {
+	b.t.done()
+	return b.ReadCloser.Close()
+}
+
+// traceRequest adds the client trace to the outgoing request.
+func (h *Handler) traceRequest(rr *RequestResponse, out *http.Request) *http.Request {
+	rr.tracer = &tracer{}
+	return out.WithContext(httptrace.WithClientTrace(out.Context(), rr.tracer.clientTrace()))
+}
+
+// traceResponse records the end of the response body.
+func (h *Handler) traceResponse(rr *RequestResponse, r *http.Response) {
+	if rr.tracer == nil || r.Body == nil || r.Body == http.NoBody {
+		return
+	}
+	r.Body = &timedBody{ReadCloser: r.Body, t: rr.tracer}
+}
@@ -0,0 +1,118 @@
+package httptap
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TraceContext is the W3C trace context of the exchange, see WithTraceContext.
+// The ids are lower case hex.
+type TraceContext struct {
+	TraceID string
+	// ParentSpanID is the span of the caller, empty when the proxy starts the trace.
+	ParentSpanID string
+	// SpanID is the span of the proxy, the server span.
+	SpanID string
+	// ClientSpanID is the span of the call to the upstream, empty when
+	// the upstream was not called.
+	ClientSpanID string
+	// ClientStart is the start of the call to the upstream.
+	ClientStart time.Time
+	Flags       byte
+	// State is the tracestate header, passed on as is.
+	State string
+}
+
+// Sampled reports if the caller records the trace.
+func (tc *TraceContext) Sampled() bool {
+	return tc.Flags&0x01 != 0
+}
+
+// Traceparent returns the traceparent header with the span as the parent.
+func (tc *TraceContext) Traceparent(spanID string) string {
+	return fmt.Sprintf("00-%s-%s-%02x", tc.TraceID, spanID, tc.Flags)
+}
+
+// parseTraceparent parses version-traceid-parentid-flags.
+func parseTraceparent(s string) (traceID, parentID string, flags byte, ok bool) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 {
+		return "", "", 0, false
+	}
+	version, traceID, parentID, fl := parts[0], parts[1], parts[2], parts[3]
+	if !isHex(version, 2) || version == "ff" {
+		return "", "", 0, false
+	}
+	// Version 00 has exactly four fields, later versions may add fields.
+	if version == "00" && len(parts) != 4 {
+		return "", "", 0, false
+	}
+	if !isHex(traceID, 32) || traceID == strings.Repeat("0", 32) ||
+		!isHex(parentID, 16) || parentID == strings.Repeat("0", 16) || !isHex(fl, 2) {
+		return "", "", 0, false
+	}
+	b, _ := hex.DecodeString(fl)
+	return traceID, parentID, b[0], true
+}
+
+// isHex reports if s has n lower case hex digits.
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// newTraceContext continues the trace of the request or starts a sampled one.
+func newTraceContext(h http.Header) *TraceContext {
+	tc := &TraceContext{SpanID: randomHex(8)}
+	if traceID, parentID, flags, ok := parseTraceparent(h.Get("Traceparent")); ok {
+		tc.TraceID = traceID
+		tc.ParentSpanID = parentID
+		tc.Flags = flags
+		tc.State = strings.Join(h.Values("Tracestate"), ",")
+		return tc
+	}
+	tc.TraceID = randomHex(16)
+	tc.Flags = 0x01
+	return tc
+}
+
+// startTrace adds the trace context of the request to the request context.
+func (p *Proxy) startTrace(r *http.Request, rc *RequestContext) {
+	if !p.traceContext {
+		return
+	}
+	rc.Trace = newTraceContext(r.Header)
+}
+
+// propagateTrace starts the client span and passes it to the upstream
+// as the parent in traceparent.
+func (h *Handler) propagateTrace(rr *RequestResponse, out *http.Request) {
+	tc := rr.Trace
+	if tc == nil {
+		return
+	}
+	tc.ClientSpanID = randomHex(8)
+	tc.ClientStart = time.Now()
+	out.Header.Set("Traceparent", tc.Traceparent(tc.ClientSpanID))
+	out.Header.Del("Tracestate")
+	if tc.State != "" {
+		out.Header.Set("Tracestate", tc.State)
+	}
+}